@@ -2,19 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	cudascope "github.com/sergey/cudascope"
 	"github.com/sergey/cudascope/internal/agent"
+	"github.com/sergey/cudascope/internal/alert"
+	"github.com/sergey/cudascope/internal/anomaly"
 	"github.com/sergey/cudascope/internal/api"
 	"github.com/sergey/cudascope/internal/collector"
 	"github.com/sergey/cudascope/internal/config"
+	"github.com/sergey/cudascope/internal/ingestauth"
+	"github.com/sergey/cudascope/internal/notify"
+	"github.com/sergey/cudascope/internal/oidc"
+	"github.com/sergey/cudascope/internal/rulesfile"
 	"github.com/sergey/cudascope/internal/storage"
 )
 
@@ -24,15 +37,23 @@ func main() {
 
 	// Healthcheck mode: just probe the HTTP endpoint and exit
 	if cfg.Mode == "healthcheck" {
-		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/api/v1/healthz", cfg.Port))
+		scheme, client := "http", http.DefaultClient
+		if cfg.TLSCert != "" {
+			tlsCfg, err := healthcheckTLSConfig(cfg)
+			if err != nil {
+				log.Printf("healthcheck: %v", err)
+				os.Exit(1)
+			}
+			scheme = "https"
+			client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+		}
+		resp, err := client.Get(fmt.Sprintf("%s://localhost:%d/api/v1/healthz", scheme, cfg.Port))
 		if err != nil || resp.StatusCode != 200 {
 			os.Exit(1)
 		}
 		os.Exit(0)
 	}
 
-	log.Printf("CudaScope starting (mode=%s, port=%d)", cfg.Mode, cfg.Port)
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -44,6 +65,26 @@ func main() {
 		cancel()
 	}()
 
+	switch cfg.Command {
+	case "":
+	case "record":
+		runRecord(ctx, cfg)
+		return
+	case "ingest-key":
+		runIngestKey(cfg)
+		return
+	case "token":
+		runToken(cfg)
+		return
+	case "notify-test":
+		runNotifyTest(ctx, cfg)
+		return
+	default:
+		log.Fatalf("unknown command: %s", cfg.Command)
+	}
+
+	log.Printf("CudaScope starting (mode=%s, port=%d)", cfg.Mode, cfg.Port)
+
 	var httpSrv *http.Server
 
 	switch cfg.Mode {
@@ -68,9 +109,14 @@ func main() {
 		}
 	}
 
+	background.Wait()
 	log.Println("CudaScope stopped")
 }
 
+// background tracks goroutines that must finish their shutdown work before
+// the process exits.
+var background sync.WaitGroup
+
 func runStandalone(ctx context.Context, cancel context.CancelFunc, cfg *config.Config) *http.Server {
 	// Open database
 	db, err := storage.Open(cfg.DataDir)
@@ -81,21 +127,22 @@ func runStandalone(ctx context.Context, cancel context.CancelFunc, cfg *config.C
 
 	// Register local node
 	hostname, _ := os.Hostname()
-	db.RegisterNode("local", hostname, 0)
+	labels := parseLabels(cfg.NodeLabels)
+	db.RegisterNode("local", hostname, 0, "", labels)
 
-	// Initialize GPU collector
-	gpuCol, err := collector.NewGPUCollector()
+	// Initialize GPU backend
+	gpuSrc, err := newGPUSource(cfg)
 	if err != nil {
-		log.Fatalf("failed to initialize GPU collector: %v", err)
+		log.Fatalf("failed to initialize GPU backend: %v", err)
 	}
-	go func() { <-ctx.Done(); gpuCol.Shutdown() }()
+	go func() { <-ctx.Done(); gpuSrc.Shutdown() }()
 
 	// Register GPU devices under 'local' node
-	if err := db.RegisterGPUDevices("local", gpuCol.Devices()); err != nil {
+	if err := db.RegisterGPUDevices("local", gpuSrc.Devices()); err != nil {
 		log.Fatalf("failed to register GPU devices: %v", err)
 	}
-	db.RegisterNode("local", hostname, len(gpuCol.Devices()))
-	logDevices(gpuCol.Devices())
+	db.RegisterNode("local", hostname, len(gpuSrc.Devices()), collector.DeviceHash(gpuSrc.Devices()), labels)
+	logDevices(gpuSrc.Devices())
 
 	// Host collector
 	hostCol := collector.NewHostCollector("local")
@@ -104,7 +151,7 @@ func runStandalone(ctx context.Context, cancel context.CancelFunc, cfg *config.C
 	hub := api.NewHub()
 
 	// Start collector
-	col := collector.New(gpuCol, hostCol, db, hub, cfg.CollectInterval, cfg.HostInterval)
+	col := collector.New(gpuSrc, hostCol, db, hub, cfg.CollectInterval, cfg.HostInterval, cfg.NVLinkInterval)
 	go col.Run(ctx)
 
 	// Start retention
@@ -114,12 +161,16 @@ func runStandalone(ctx context.Context, cancel context.CancelFunc, cfg *config.C
 		H1:  cfg.Retention1h,
 	})
 
+	// Start alert evaluation
+	alerts, rules := newAlertEngine(ctx, db, cfg)
+	go alerts.Run(ctx)
+
 	// Start API server
-	server := newAPIServer(db, hub, cfg)
+	server := newAPIServer(db, hub, alerts, rules, cfg)
 	httpSrv := server.HTTPServer(cfg.Port)
 	go func() {
 		log.Printf("HTTP server listening on :%d", cfg.Port)
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listen(httpSrv, cfg); err != nil && err != http.ErrServerClosed {
 			log.Printf("server error: %v", err)
 			cancel()
 		}
@@ -147,12 +198,16 @@ func runHub(ctx context.Context, cancel context.CancelFunc, cfg *config.Config)
 		H1:  cfg.Retention1h,
 	})
 
+	// Start alert evaluation
+	alerts, rules := newAlertEngine(ctx, db, cfg)
+	go alerts.Run(ctx)
+
 	// Start API server (with ingest endpoints)
-	server := newAPIServer(db, hub, cfg)
+	server := newAPIServer(db, hub, alerts, rules, cfg)
 	httpSrv := server.HTTPServer(cfg.Port)
 	go func() {
 		log.Printf("HTTP server listening on :%d", cfg.Port)
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listen(httpSrv, cfg); err != nil && err != http.ErrServerClosed {
 			log.Printf("server error: %v", err)
 			cancel()
 		}
@@ -172,31 +227,61 @@ func runAgent(ctx context.Context, cancel context.CancelFunc, cfg *config.Config
 	}
 	log.Printf("agent node_id=%s, hub=%s", nodeID, cfg.HubURL)
 
-	// Initialize GPU collector
-	gpuCol, err := collector.NewGPUCollector()
+	// Initialize GPU backend
+	gpuSrc, err := newGPUSource(cfg)
 	if err != nil {
-		log.Fatalf("failed to initialize GPU collector: %v", err)
+		log.Fatalf("failed to initialize GPU backend: %v", err)
 	}
-	go func() { <-ctx.Done(); gpuCol.Shutdown() }()
+	go func() { <-ctx.Done(); gpuSrc.Shutdown() }()
 
-	logDevices(gpuCol.Devices())
+	logDevices(gpuSrc.Devices())
 
 	// Host collector
 	hostCol := collector.NewHostCollector(nodeID)
 
-	// Agent sink (pushes metrics to hub)
-	agentSink := agent.New(cfg.HubURL, nodeID)
+	// Agent sink (pushes metrics to hub, spooling them to disk while it is unreachable)
+	var spool *agent.Spool
+	if cfg.SpoolMaxMB > 0 {
+		dir := cfg.SpoolDir
+		if dir == "" {
+			dir = filepath.Join(cfg.DataDir, "spool")
+		}
+		spool, err = agent.OpenSpool(dir, int64(cfg.SpoolMaxMB)*1024*1024, cfg.SpoolMaxAge)
+		if err != nil {
+			log.Printf("spooling disabled: %v", err)
+			spool = nil
+		} else {
+			log.Printf("spooling undelivered batches to %s (max %d MiB, %v)", dir, cfg.SpoolMaxMB, cfg.SpoolMaxAge)
+		}
+	}
+	tlsCfg, err := agentTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("agent TLS: %v", err)
+	}
+	key := cfg.IngestKey
+	if key == "" && cfg.IngestSecret != "" {
+		key = ingestauth.NodeKey(cfg.IngestSecret, nodeID)
+	}
+	if cfg.BatchEncoding != "gzip" && cfg.BatchEncoding != "zstd" {
+		log.Fatalf("--batch-encoding must be gzip or zstd")
+	}
+	agentSink := agent.New(cfg.HubURL, nodeID, parseLabels(cfg.NodeLabels), cfg.FlushInterval, cfg.BatchEncoding, spool, tlsCfg, key, cfg.IngestToken)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		agentSink.Run(ctx)
+	}()
 
 	// Register with hub (retries until successful)
 	go func() {
-		if err := agentSink.Register(ctx, gpuCol.Devices()); err != nil {
+		if err := agentSink.Register(ctx, gpuSrc.Devices()); err != nil {
 			log.Printf("registration cancelled: %v", err)
 			return
 		}
 	}()
 
 	// Start collector with agent sink (no broadcast — no local WS clients)
-	col := collector.New(gpuCol, hostCol, agentSink, nil, cfg.CollectInterval, cfg.HostInterval)
+	col := collector.New(gpuSrc, hostCol, agentSink, nil, cfg.CollectInterval, cfg.HostInterval, cfg.NVLinkInterval)
 	go col.Run(ctx)
 
 	// Minimal health endpoint for Docker healthcheck
@@ -205,6 +290,7 @@ func runAgent(ctx context.Context, cancel context.CancelFunc, cfg *config.Config
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
+	mux.HandleFunc("/metrics", agentSink.ServeMetrics)
 	httpSrv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Port),
 		Handler: mux,
@@ -218,21 +304,337 @@ func runAgent(ctx context.Context, cancel context.CancelFunc, cfg *config.Config
 	return httpSrv
 }
 
-func newAPIServer(db *storage.DB, hub *api.Hub, cfg *config.Config) *api.Server {
-	alertCfg := api.AlertConfig{
-		TempMax: cfg.AlertTempMax,
-		GPUUtil: cfg.AlertGPUUtil,
-		MemUtil: cfg.AlertMemUtil,
+// runRecord collects metrics into a trace file until interrupted.
+func runRecord(ctx context.Context, cfg *config.Config) {
+	if cfg.RecordOut == "" {
+		log.Fatalf("record requires --out")
+	}
+
+	gpuSrc, err := newGPUSource(cfg)
+	if err != nil {
+		log.Fatalf("failed to initialize GPU backend: %v", err)
+	}
+	defer gpuSrc.Shutdown()
+	logDevices(gpuSrc.Devices())
+
+	f, err := os.Create(cfg.RecordOut)
+	if err != nil {
+		log.Fatalf("failed to create trace file: %v", err)
+	}
+	rec, err := collector.NewRecorder(f, gpuSrc.Devices())
+	if err != nil {
+		log.Fatalf("failed to start recording: %v", err)
+	}
+	defer rec.Close()
+
+	// No storage: every snapshot goes straight to the recorder. Run returns
+	// once its event watcher has stopped, so nothing is recorded after Close.
+	col := collector.New(gpuSrc, collector.NewHostCollector("local"), nil, rec, cfg.CollectInterval, cfg.HostInterval, cfg.NVLinkInterval)
+	log.Printf("recording to %s (Ctrl-C to stop)", cfg.RecordOut)
+	col.Run(ctx)
+	log.Printf("recorded %d snapshots to %s", rec.Count(), cfg.RecordOut)
+}
+
+// newGPUSource creates the GPU backend selected by --gpu-backend.
+func newGPUSource(cfg *config.Config) (collector.GPUSource, error) {
+	switch cfg.GPUBackend {
+	case "nvml":
+		gc, err := collector.NewGPUCollector(collector.NewProcReader(cfg.HostRoot))
+		if err != nil {
+			return nil, err
+		}
+		return gc, nil
+	case "sim":
+		log.Printf("using simulated GPU backend (%d GPUs, seed=%d)", cfg.SimGPUs, cfg.SimSeed)
+		return collector.NewSimGPUSource(cfg.SimGPUs, cfg.SimSeed, cfg.SimMIG), nil
+	case "replay":
+		if cfg.ReplayFile == "" {
+			return nil, fmt.Errorf("replay backend requires --replay-file")
+		}
+		rs, err := collector.NewReplaySource(cfg.ReplayFile, cfg.ReplaySpeed, cfg.ReplayLoop)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("replaying %s at %gx speed", cfg.ReplayFile, cfg.ReplaySpeed)
+		return rs, nil
+	default:
+		return nil, fmt.Errorf("unknown gpu backend: %s", cfg.GPUBackend)
+	}
+}
+
+// newAlertEngine loads the alert rules, notification channels and silences
+// and starts evaluating and notifying. With --rules-file, they are reloaded
+// when the file changes or on SIGHUP.
+func newAlertEngine(ctx context.Context, db *storage.DB, cfg *config.Config) (*alert.Engine, *rulesfile.Loader) {
+	if cfg.AlertInterval <= 0 {
+		log.Fatalf("--alert-interval must be positive")
+	}
+	loader := newRulesLoader(cfg)
+	ac, err := loader.Load()
+	if err != nil {
+		log.Fatalf("alert rules: %v", err)
+	}
+
+	// With a rules file, channels may appear on reload
+	var mgr *notify.Manager
+	var notifier alert.Notifier
+	if ac.Notify != nil || cfg.RulesFile != "" {
+		mgr, err = notify.New(notifyConfig(ac))
+		if err != nil {
+			log.Fatalf("notify config: %v", err)
+		}
+		go mgr.Run(ctx)
+		notifier = mgr
+	}
+
+	health := alert.HealthConfig{StaleAfter: cfg.StaleAfter}
+	if cfg.Mode == "hub" {
+		// Only agents check in; the standalone node never goes offline
+		health.OfflineAfter = cfg.OfflineAfter
+	}
+	if cfg.WasteAlert {
+		waste, err := wasteConfig(cfg)
+		if err != nil {
+			log.Fatalf("waste alert: %v", err)
+		}
+		health.Waste = &waste
+	}
+	if cfg.AnomalyScore > 0 {
+		det, err := anomaly.New(db, anomaly.Config{Threshold: cfg.AnomalyScore, HalfLife: cfg.AnomalyHalfLife})
+		if err != nil {
+			log.Fatalf("anomaly detection: %v", err)
+		}
+		go det.Run(ctx)
+		health.Anomaly = cfg.AnomalyScore
+	}
+	engine, err := alert.New(db, ac.Rules, health, cfg.AlertInterval, notifier)
+	if err != nil {
+		log.Fatalf("alert engine: %v", err)
+	}
+	engine.SetSilences(ac.Silences)
+	log.Printf("evaluating %d alert rules every %s, notifying %d channels", len(ac.Rules), cfg.AlertInterval, len(notifyConfig(ac).Channels))
+
+	if cfg.RulesFile != "" {
+		go rulesfile.Watch(ctx, cfg.RulesFile, 5*time.Second, func() {
+			ac, err := loader.Load()
+			if err != nil {
+				log.Printf("alert rules: %v (keeping the current rules)", err)
+				return
+			}
+			if err := mgr.Reload(notifyConfig(ac)); err != nil {
+				log.Printf("notify config: %v (keeping the current rules)", err)
+				return
+			}
+			engine.SetRules(ac.Rules)
+			engine.SetSilences(ac.Silences)
+			log.Printf("reloaded %s: %d alert rules, %d channels, %d silences",
+				cfg.RulesFile, len(ac.Rules), len(notifyConfig(ac).Channels), len(ac.Silences))
+		})
+	}
+	return engine, loader
+}
+
+// newRulesLoader reads the alert config given on the command line: the
+// --alert-* thresholds, --alert-rules and --notify-config.
+func newRulesLoader(cfg *config.Config) *rulesfile.Loader {
+	loader := &rulesfile.Loader{
+		File:  cfg.RulesFile,
+		Rules: alert.LegacyRules(cfg.AlertTempMax, cfg.AlertGPUUtil, cfg.AlertMemUtil),
+	}
+	if cfg.AlertRules != "" {
+		rules, err := alert.LoadRules(cfg.AlertRules)
+		if err != nil {
+			log.Fatalf("alert rules: %v", err)
+		}
+		loader.Rules = append(loader.Rules, rules...)
+	}
+	if cfg.NotifyConfig != "" {
+		nc, err := notify.Load(cfg.NotifyConfig)
+		if err != nil {
+			log.Fatalf("notify config: %v", err)
+		}
+		loader.Notify = nc
+	}
+	return loader
+}
+
+func notifyConfig(ac *rulesfile.Config) *notify.Config {
+	if ac.Notify == nil {
+		return &notify.Config{}
+	}
+	return ac.Notify
+}
+
+// wasteConfig returns what counts as an idle GPU allocation.
+func wasteConfig(cfg *config.Config) (storage.WasteConfig, error) {
+	if cfg.WasteUtil <= 0 || cfg.WasteMinMem < 0 || cfg.WasteAfter < 0 {
+		return storage.WasteConfig{}, fmt.Errorf("--waste-util must be positive, --waste-min-mem and --waste-after not negative")
+	}
+	return storage.WasteConfig{MaxUtil: cfg.WasteUtil, MinMem: uint64(cfg.WasteMinMem), MinIdle: cfg.WasteAfter}, nil
+}
+
+// runNotifyTest sends a test notification: cudascope notify-test <channel>.
+func runNotifyTest(ctx context.Context, cfg *config.Config) {
+	if len(cfg.Args) != 1 || (cfg.NotifyConfig == "" && cfg.RulesFile == "") {
+		log.Fatalf("usage: cudascope notify-test <channel> --notify-config=<file> | --rules-file=<file>")
+	}
+	ac, err := newRulesLoader(cfg).Load()
+	if err != nil {
+		log.Fatalf("alert rules: %v", err)
+	}
+	mgr, err := notify.New(notifyConfig(ac))
+	if err != nil {
+		log.Fatalf("notify config: %v", err)
+	}
+	if err := mgr.Test(ctx, cfg.Args[0]); err != nil {
+		log.Fatalf("notify-test: %v", err)
+	}
+	log.Printf("sent test notification to %s", cfg.Args[0])
+}
+
+func newAPIServer(db *storage.DB, hub *api.Hub, alerts *alert.Engine, rules *rulesfile.Loader, cfg *config.Config) *api.Server {
+	ingestAuth := api.IngestAuth{Secret: cfg.IngestSecret, Tokens: cfg.IngestTokens}
+	if cfg.TLSCA != "" {
+		if cfg.TLSCert == "" {
+			log.Fatalf("--tls-ca requires --tls-cert and --tls-key")
+		}
+		pool, err := loadCertPool(cfg.TLSCA)
+		if err != nil {
+			log.Fatalf("client CA: %v", err)
+		}
+		ingestAuth.ClientCAs = pool
+	}
+	sso := api.SSOConfig{
+		OIDC: oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+		},
+		GroupsClaim:   cfg.OIDCGroups,
+		AdminGroups:   splitList(cfg.OIDCAdmins),
+		ViewerGroups:  splitList(cfg.OIDCViewers),
+		SessionSecret: cfg.SessionSecret,
+	}
+	if cfg.OIDCIssuer != "" && (cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "") {
+		log.Fatalf("--oidc-issuer requires --oidc-client-id and --oidc-redirect-url")
+	}
+	waste, err := wasteConfig(cfg)
+	if err != nil {
+		// Only the waste report needs them; the zero config turns it off
+		log.Printf("warning: %v, /api/v1/waste is disabled", err)
 	}
 	if cfg.DevMode {
-		return api.NewServer(db, hub, nil, true, cfg.UIDir, cfg.Auth, alertCfg)
+		return api.NewServer(db, hub, nil, true, cfg.UIDir, cfg.Auth, alerts, rules, waste, ingestAuth, sso)
 	}
 	fs, err := cudascope.UIFS()
 	if err != nil {
 		log.Printf("warning: embedded UI not available: %v", err)
-		return api.NewServer(db, hub, nil, false, "", cfg.Auth, alertCfg)
+		return api.NewServer(db, hub, nil, false, "", cfg.Auth, alerts, rules, waste, ingestAuth, sso)
+	}
+	return api.NewServer(db, hub, fs, false, "", cfg.Auth, alerts, rules, waste, ingestAuth, sso)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseLabels parses --node-labels: comma-separated key=value pairs.
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, item := range splitList(s) {
+		k, v, ok := strings.Cut(item, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			log.Fatalf("--node-labels: %q is not key=value", item)
+		}
+		labels[k] = strings.TrimSpace(v)
+	}
+	return labels
+}
+
+// listen serves httpSrv over TLS when a certificate is configured.
+func listen(httpSrv *http.Server, cfg *config.Config) error {
+	if cfg.TLSCert != "" {
+		return httpSrv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	}
+	return httpSrv.ListenAndServe()
+}
+
+// agentTLSConfig builds the agent's TLS settings for talking to the hub: the
+// CA to verify the hub with and the client certificate to present, if any.
+func agentTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCA == "" && cfg.TLSCert == "" {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{}
+	if cfg.TLSCA != "" {
+		pool, err := loadCertPool(cfg.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// healthcheckTLSConfig verifies the hub's own certificate against --tls-ca
+// (or the system roots). The certificate is issued for the node's name, not
+// localhost, so that name is checked instead.
+func healthcheckTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{ServerName: leaf.Subject.CommonName}
+	if len(leaf.DNSNames) > 0 {
+		tlsCfg.ServerName = leaf.DNSNames[0]
+	}
+	if cfg.TLSCA != "" {
+		if tlsCfg.RootCAs, err = loadCertPool(cfg.TLSCA); err != nil {
+			return nil, err
+		}
 	}
-	return api.NewServer(db, hub, fs, false, "", cfg.Auth, alertCfg)
+	return tlsCfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// runIngestKey prints the ingest key of a node, derived from the hub's
+// ingest secret, for configuring that node's agent with --ingest-key.
+func runIngestKey(cfg *config.Config) {
+	if cfg.IngestSecret == "" || len(cfg.Args) != 1 {
+		log.Fatalf("usage: cudascope ingest-key --ingest-secret=SECRET <node-id>")
+	}
+	fmt.Println(ingestauth.NodeKey(cfg.IngestSecret, cfg.Args[0]))
 }
 
 func logDevices(devices []collector.GPUDevice) {
@@ -241,3 +643,84 @@ func logDevices(devices []collector.GPUDevice) {
 		log.Printf("  GPU %d: %s (%d MiB, driver %s)", d.ID, d.Name, d.MemTotal, d.DriverVer)
 	}
 }
+
+// runToken manages API tokens in the database under --data-dir:
+// token create --name NAME [--role ROLE] [--node NODE] [--expires DURATION],
+// token list, token revoke ID.
+func runToken(cfg *config.Config) {
+	if len(cfg.Args) == 0 {
+		log.Fatalf("usage: cudascope token create|list|revoke")
+	}
+	db, err := storage.Open(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	switch cfg.Args[0] {
+	case "create":
+		if cfg.TokenName == "" {
+			log.Fatalf("token create requires --name")
+		}
+		if (cfg.TokenNode != "") != (cfg.TokenRole == storage.RoleAgent) {
+			log.Fatalf("agent tokens require --node, and --node only applies to agent tokens")
+		}
+		var expiresAt int64
+		if cfg.TokenExpires > 0 {
+			expiresAt = time.Now().Add(cfg.TokenExpires).Unix()
+		}
+		secret, t, err := db.CreateToken(cfg.TokenName, cfg.TokenRole, cfg.TokenNode, expiresAt)
+		if err != nil {
+			log.Fatalf("create token: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "created %s token %d (%s); it is shown only once:\n", t.Role, t.ID, t.Name)
+		fmt.Println(secret)
+
+	case "list":
+		tokens, err := db.GetTokens()
+		if err != nil {
+			log.Fatalf("list tokens: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tROLE\tNODE\tPREFIX\tCREATED\tEXPIRES\tLAST USED")
+		for _, t := range tokens {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Role, orDash(t.NodeID), t.Prefix,
+				formatUnix(t.CreatedAt), formatUnix(t.ExpiresAt), formatUnix(t.LastUsed))
+		}
+		tw.Flush()
+
+	case "revoke":
+		if len(cfg.Args) != 2 {
+			log.Fatalf("usage: cudascope token revoke ID")
+		}
+		id, err := strconv.ParseInt(cfg.Args[1], 10, 64)
+		if err != nil {
+			log.Fatalf("invalid token id %q", cfg.Args[1])
+		}
+		ok, err := db.RevokeToken(id)
+		if err != nil {
+			log.Fatalf("revoke token: %v", err)
+		}
+		if !ok {
+			log.Fatalf("token %d not found", id)
+		}
+		fmt.Printf("revoked token %d\n", id)
+
+	default:
+		log.Fatalf("unknown token command: %s", cfg.Args[0])
+	}
+}
+
+func formatUnix(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).Format("2006-01-02 15:04")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -7,7 +7,9 @@ toolchain go1.24.13
 require (
 	github.com/NVIDIA/go-nvml v0.12.4-0
 	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.18.2
 	github.com/shirou/gopsutil/v4 v4.26.1
+	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.44.3
 )
 
@@ -2,44 +2,212 @@ package agent
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
 	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/ingestauth"
 )
 
-// Agent pushes collected metrics to the hub.
+// Agent pushes collected metrics to the hub. Samples are buffered and sent
+// every flush interval as one compressed batch to /api/v1/ingest/batch.
 type Agent struct {
 	hubURL string
 	nodeID string
+	labels map[string]string
 	client *http.Client
+	dialer *websocket.Dialer
+	key    string // ingest signing key (empty = requests are not signed)
+	token  string // API token sent as a bearer token (empty = none)
+
+	flushInterval time.Duration // 0 = send every snapshot as it is collected
+	encoding      string        // batch Content-Encoding: gzip or zstd
+
+	bufMu      sync.Mutex
+	buf        []collector.Snapshot
+	bufSamples int
+
+	// spool holds batches the hub did not accept; nil disables spooling
+	spool *Spool
+	wake  chan struct{}
+
+	mu       sync.Mutex
+	spooling bool // the hub is unreachable and batches go to the spool
+
+	// Registration, re-sent on every stream connection and whenever the hub
+	// does not know the node
+	devices      []collector.GPUDevice
+	deviceHash   string
+	registered   chan struct{} // closed once devices are known
+	registerOnce sync.Once
+
+	streamMu sync.Mutex
+	stream   *stream // nil while not connected; batches then go over HTTP
+}
+
+// request is an HTTP request body bound for the hub.
+type request struct {
+	Path     string `json:"path"`               // ingest endpoint
+	Encoding string `json:"encoding,omitempty"` // Content-Encoding of the body
+	Body     []byte `json:"-"`
 }
 
-// New creates a new Agent that pushes metrics to the given hub URL.
-func New(hubURL, nodeID string) *Agent {
+const batchPath = "/api/v1/ingest/batch"
+
+// replayRetry is how long replay waits after the hub refused a batch.
+const replayRetry = 5 * time.Second
+
+// New creates a new Agent that pushes metrics to the given hub URL every
+// flushInterval, compressed with encoding (gzip or zstd). Batches that cannot be delivered are persisted to spool (if
+// not nil) and replayed in order once the hub is reachable again.
+//
+// tlsCfg (may be nil) holds the CAs trusted for the hub and the agent's
+// client certificate; key, if set, is the node key requests are signed with
+// (see ingestauth).
+func New(hubURL, nodeID string, labels map[string]string, flushInterval time.Duration, encoding string, spool *Spool, tlsCfg *tls.Config, key, token string) *Agent {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 10 * time.Second}
+	if tlsCfg != nil {
+		// Separate copies: the transport adds HTTP/2 to its config's ALPN
+		// protocols, which the websocket handshake must not offer
+		transport.TLSClientConfig = tlsCfg.Clone()
+		dialer.TLSClientConfig = tlsCfg.Clone()
+	}
 	return &Agent{
-		hubURL: hubURL,
-		nodeID: nodeID,
-		client: &http.Client{Timeout: 10 * time.Second},
+		hubURL:        hubURL,
+		nodeID:        nodeID,
+		labels:        labels,
+		client:        &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		dialer:        dialer,
+		key:           key,
+		token:         token,
+		flushInterval: flushInterval,
+		encoding:      encoding,
+		spool:         spool,
+		wake:          make(chan struct{}, 1),
+		registered:    make(chan struct{}),
+	}
+}
+
+// Run keeps the ingest stream to the hub open, flushes buffered samples
+// every flush interval and replays spooled batches. Blocks until ctx is
+// cancelled, then spools (or, without a spool, sends) what is left.
+func (a *Agent) Run(ctx context.Context) {
+	go a.streamLoop(ctx)
+	if a.spool != nil {
+		go a.replayLoop(ctx)
+	}
+	if a.flushInterval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			a.drain()
+			return
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// replayLoop replays spooled batches to the hub, oldest first, until ctx is
+// cancelled.
+func (a *Agent) replayLoop(ctx context.Context) {
+	if n := a.spool.Len(); n > 0 {
+		log.Printf("spool: %d batches pending from a previous run", n)
+		a.setSpooling(true)
+	}
+
+	for {
+		if !a.replay(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(replayRetry):
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.wake:
+		}
 	}
 }
 
+// replay delivers spooled batches until the spool is empty (returns true)
+// or the hub fails (returns false).
+func (a *Agent) replay(ctx context.Context) bool {
+	for ctx.Err() == nil {
+		req, ok := a.spool.Peek()
+		if !ok {
+			if a.setSpooling(false) {
+				st := a.spool.Stats()
+				log.Printf("spool: drained, hub at %s reachable again (%d batches replayed)", a.hubURL, st.ReplayedBatches)
+			}
+			return true
+		}
+
+		err := a.send(req)
+		var se *statusError
+		switch {
+		case err == nil:
+			a.spool.Pop(true)
+		case errors.As(err, &se) && se.permanent():
+			log.Printf("spool: hub rejected spooled batch: %v (dropping)", err)
+			a.spool.Pop(false)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// setSpooling records whether batches are being spooled and reports whether
+// that changed.
+func (a *Agent) setSpooling(on bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	changed := a.spooling != on
+	a.spooling = on
+	return changed
+}
+
+// SpoolStats returns the spool depth and counters; ok is false when
+// spooling is disabled.
+func (a *Agent) SpoolStats() (st SpoolStats, ok bool) {
+	if a.spool == nil {
+		return SpoolStats{}, false
+	}
+	return a.spool.Stats(), true
+}
+
 // Register sends device info and node registration to the hub.
 // Retries until successful or context cancelled.
 func (a *Agent) Register(ctx context.Context, devices []collector.GPUDevice) error {
-	payload := struct {
-		NodeID   string              `json:"node_id"`
-		Hostname string              `json:"hostname"`
-		Devices  []collector.GPUDevice `json:"devices"`
-	}{
-		NodeID:   a.nodeID,
-		Hostname: a.nodeID,
-		Devices:  devices,
-	}
+	a.registerOnce.Do(func() {
+		a.devices = devices
+		a.deviceHash = collector.DeviceHash(devices)
+		close(a.registered)
+	})
+	payload := a.registration()
 
 	for {
 		err := a.post("/api/v1/ingest/register", payload)
@@ -56,18 +224,28 @@ func (a *Agent) Register(ctx context.Context, devices []collector.GPUDevice) err
 	}
 }
 
+// registration describes this node to the hub.
+func (a *Agent) registration() *collector.Registration {
+	return &collector.Registration{
+		NodeID:   a.nodeID,
+		Hostname: a.nodeID,
+		Devices:  a.devices,
+		Labels:   a.labels,
+	}
+}
+
 // WriteGPUMetrics implements collector.MetricSink.
 func (a *Agent) WriteGPUMetrics(metrics []collector.GPUMetrics) error {
 	for i := range metrics {
 		metrics[i].NodeID = a.nodeID
 	}
-	return a.post("/api/v1/ingest/gpu-metrics", metrics)
+	return a.enqueue(collector.Snapshot{Type: "gpu_metrics", GPUs: metrics}, len(metrics))
 }
 
 // WriteHostMetrics implements collector.MetricSink.
 func (a *Agent) WriteHostMetrics(m *collector.HostMetrics) error {
 	m.NodeID = a.nodeID
-	return a.post("/api/v1/ingest/host-metrics", m)
+	return a.enqueue(collector.Snapshot{Type: "host_metrics", Host: m}, 1)
 }
 
 // WriteGPUProcesses implements collector.MetricSink.
@@ -78,7 +256,149 @@ func (a *Agent) WriteGPUProcesses(procs []collector.GPUProcess) error {
 	for i := range procs {
 		procs[i].NodeID = a.nodeID
 	}
-	return a.post("/api/v1/ingest/gpu-processes", procs)
+	return a.enqueue(collector.Snapshot{Type: "gpu_processes", Processes: procs}, len(procs))
+}
+
+// WriteGPUEvents implements collector.MetricSink.
+func (a *Agent) WriteGPUEvents(events []collector.GPUEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	for i := range events {
+		events[i].NodeID = a.nodeID
+	}
+	return a.enqueue(collector.Snapshot{Type: "gpu_events", Events: events}, len(events))
+}
+
+// WriteNVLinkMetrics implements collector.MetricSink.
+func (a *Agent) WriteNVLinkMetrics(links []collector.NVLinkMetrics) error {
+	if len(links) == 0 {
+		return nil
+	}
+	for i := range links {
+		links[i].NodeID = a.nodeID
+	}
+	return a.enqueue(collector.Snapshot{Type: "nvlink", NVLinks: links}, len(links))
+}
+
+// enqueue buffers a snapshot until the next flush.
+func (a *Agent) enqueue(snap collector.Snapshot, samples int) error {
+	snap.NodeID = a.nodeID
+	snap.Timestamp = time.Now().Unix()
+
+	a.bufMu.Lock()
+	a.buf = append(a.buf, snap)
+	a.bufSamples += samples
+	a.bufMu.Unlock()
+
+	if a.flushInterval <= 0 {
+		return a.flush()
+	}
+	return nil
+}
+
+// flush sends the buffered snapshots to the hub as one compressed batch.
+func (a *Agent) flush() error {
+	req, samples, err := a.takeBatch()
+	if err != nil || samples == 0 {
+		return err
+	}
+	if err := a.push(req, samples); err != nil {
+		log.Printf("failed to send %d samples to hub: %v", samples, err)
+		return err
+	}
+	return nil
+}
+
+// drain saves the buffer on shutdown: to the spool if there is one, to be
+// replayed on the next start, so that shutdown does not wait on the hub.
+func (a *Agent) drain() {
+	req, samples, err := a.takeBatch()
+	if err == nil && samples > 0 {
+		if a.spool != nil {
+			err = a.spool.Append(req, samples)
+		} else {
+			err = a.send(req)
+		}
+	}
+	if err != nil {
+		log.Printf("failed to save %d samples on shutdown: %v", samples, err)
+	}
+}
+
+// takeBatch empties the buffer into a compressed batch request.
+func (a *Agent) takeBatch() (request, int, error) {
+	a.bufMu.Lock()
+	snaps, samples := a.buf, a.bufSamples
+	a.buf, a.bufSamples = nil, 0
+	a.bufMu.Unlock()
+	if len(snaps) == 0 {
+		return request{}, 0, nil
+	}
+
+	var body bytes.Buffer
+	zw, err := compressor(&body, a.encoding)
+	if err != nil {
+		return request{}, samples, err
+	}
+	err = json.NewEncoder(zw).Encode(collector.IngestBatch{
+		Version:    collector.IngestBatchVersion,
+		NodeID:     a.nodeID,
+		DeviceHash: a.knownDeviceHash(),
+		Snapshots:  snaps,
+	})
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return request{}, samples, fmt.Errorf("encode batch: %w", err)
+	}
+	return request{Path: batchPath, Encoding: a.encoding, Body: body.Bytes()}, samples, nil
+}
+
+// compressor returns a writer compressing to w with the given
+// Content-Encoding.
+func compressor(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unsupported batch encoding %q", encoding)
+}
+
+// push delivers a batch of samples to the hub. While earlier batches are
+// still spooled, or if delivery fails, the batch is appended to the spool
+// instead so that the hub receives batches in collection order.
+func (a *Agent) push(req request, samples int) error {
+	if a.spool == nil {
+		return a.send(req)
+	}
+
+	if a.spool.Len() == 0 {
+		err := a.send(req)
+		var se *statusError
+		if err == nil {
+			return nil
+		}
+		if errors.As(err, &se) && se.permanent() {
+			a.spool.Drop(samples)
+			return err
+		}
+		if a.setSpooling(true) {
+			log.Printf("spool: hub unreachable (%v), spooling batches", err)
+		}
+	}
+
+	if err := a.spool.Append(req, samples); err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
 func (a *Agent) post(path string, payload any) error {
@@ -86,16 +406,145 @@ func (a *Agent) post(path string, payload any) error {
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
+	return a.send(request{Path: path, Body: body})
+}
+
+// knownDeviceHash returns the device hash, or "" before Register.
+func (a *Agent) knownDeviceHash() string {
+	select {
+	case <-a.registered:
+		return a.deviceHash
+	default:
+		return ""
+	}
+}
+
+// send delivers a request to the hub. If the hub does not know this node
+// or its devices (its state was lost or replaced), the agent registers again
+// and retries.
+func (a *Agent) send(req request) error {
+	err := a.deliver(req)
+	var se *statusError
+	if !errors.As(err, &se) || se.code != http.StatusConflict {
+		return err
+	}
+
+	log.Printf("hub does not know this node (%s), registering again", se.msg)
+	if err := a.reregister(); err != nil {
+		return fmt.Errorf("re-register: %w", err)
+	}
+	return a.deliver(req)
+}
+
+// reregister sends the registration over the stream if connected, over
+// HTTP otherwise.
+func (a *Agent) reregister() error {
+	select {
+	case <-a.registered:
+	default:
+		return fmt.Errorf("devices not known yet")
+	}
+	if st := a.currentStream(); st != nil {
+		return st.call(collector.StreamMessage{Type: collector.StreamRegister, Register: a.registration()}, nil)
+	}
+	return a.post("/api/v1/ingest/register", a.registration())
+}
+
+// authenticate adds the agent's credentials to a request to the hub.
+func (a *Agent) authenticate(r *http.Request, body []byte) {
+	if a.key != "" {
+		ingestauth.SignRequest(r, a.key, a.nodeID, body)
+	}
+	if a.token != "" {
+		r.Header.Set("Authorization", "Bearer "+a.token)
+	}
+}
 
-	url := a.hubURL + path
-	resp, err := a.client.Post(url, "application/json", bytes.NewReader(body))
+// deliver sends a request over the ingest stream if connected (batches
+// only), over HTTP otherwise.
+func (a *Agent) deliver(req request) error {
+	if st := a.currentStream(); st != nil && req.Path == batchPath {
+		return st.call(collector.StreamMessage{Type: collector.StreamBatch, Encoding: req.Encoding}, req.Body)
+	}
+
+	hr, err := http.NewRequest("POST", a.hubURL+req.Path, bytes.NewReader(req.Body))
 	if err != nil {
-		return fmt.Errorf("POST %s: %w", path, err)
+		return fmt.Errorf("POST %s: %w", req.Path, err)
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	if req.Encoding != "" {
+		hr.Header.Set("Content-Encoding", req.Encoding)
+	}
+	a.authenticate(hr, req.Body)
+
+	resp, err := a.client.Do(hr)
+	if err != nil {
+		return fmt.Errorf("POST %s: %w", req.Path, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("POST %s: status %d", path, resp.StatusCode)
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return &statusError{path: req.Path, code: resp.StatusCode, msg: body.Error}
 	}
 	return nil
 }
+
+// statusError is an error response from the hub.
+type statusError struct {
+	path string
+	code int
+	msg  string // reason given by the hub, if any
+}
+
+func (e *statusError) Error() string {
+	if e.msg != "" {
+		return fmt.Sprintf("POST %s: status %d: %s", e.path, e.code, e.msg)
+	}
+	return fmt.Sprintf("POST %s: status %d", e.path, e.code)
+}
+
+// permanent reports whether retrying the same request cannot succeed: the
+// hub could not parse the batch or found it too large, or still refuses it
+// after send registered again (a batch collected under an earlier device
+// inventory, e.g. spooled before a GPU swap).
+func (e *statusError) permanent() bool {
+	switch e.code {
+	case http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge:
+		return true
+	}
+	return false
+}
+
+// ServeMetrics exposes the agent's spool state in Prometheus text format.
+func (a *Agent) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	st, ok := a.SpoolStats()
+	if !ok {
+		return
+	}
+	node := promEscape(a.nodeID)
+	fmt.Fprintf(w, "# HELP cudascope_agent_spool_batches Batches waiting in the spool for the hub.\n")
+	fmt.Fprintf(w, "# TYPE cudascope_agent_spool_batches gauge\n")
+	fmt.Fprintf(w, "cudascope_agent_spool_batches{node_id=\"%s\"} %d\n", node, st.Batches)
+	fmt.Fprintf(w, "# HELP cudascope_agent_spool_bytes Size of the spool on disk.\n")
+	fmt.Fprintf(w, "# TYPE cudascope_agent_spool_bytes gauge\n")
+	fmt.Fprintf(w, "cudascope_agent_spool_bytes{node_id=\"%s\"} %d\n", node, st.Bytes)
+	fmt.Fprintf(w, "# HELP cudascope_agent_spool_oldest_age_seconds Age of the oldest spooled batch.\n")
+	fmt.Fprintf(w, "# TYPE cudascope_agent_spool_oldest_age_seconds gauge\n")
+	fmt.Fprintf(w, "cudascope_agent_spool_oldest_age_seconds{node_id=\"%s\"} %.0f\n", node, st.OldestAge.Seconds())
+	fmt.Fprintf(w, "# HELP cudascope_agent_spool_replayed_batches_total Spooled batches delivered to the hub.\n")
+	fmt.Fprintf(w, "# TYPE cudascope_agent_spool_replayed_batches_total counter\n")
+	fmt.Fprintf(w, "cudascope_agent_spool_replayed_batches_total{node_id=\"%s\"} %d\n", node, st.ReplayedBatches)
+	fmt.Fprintf(w, "# HELP cudascope_agent_spool_dropped_samples_total Samples lost to spool size/age limits or rejected by the hub.\n")
+	fmt.Fprintf(w, "# TYPE cudascope_agent_spool_dropped_samples_total counter\n")
+	fmt.Fprintf(w, "cudascope_agent_spool_dropped_samples_total{node_id=\"%s\"} %d\n", node, st.DroppedSamples)
+}
+
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
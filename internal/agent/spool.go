@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spool is an on-disk FIFO of ingest batches that could not be delivered to
+// the hub. Each batch is a file named by a monotonically increasing sequence
+// number, holding a one-line JSON header followed by the request body, so the
+// spool survives agent restarts and is replayed in collection order.
+//
+// The spool is bounded by total size and by age; when either bound is hit
+// the oldest batches are dropped and their samples counted as dropped.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu       sync.Mutex
+	entries  []spoolEntry // oldest first
+	bytes    int64
+	nextSeq  uint64
+	dropped  uint64 // samples dropped to enforce the bounds or rejected by the hub
+	replayed uint64 // batches delivered from the spool
+}
+
+// spoolEntry is the in-memory index of one spooled batch.
+type spoolEntry struct {
+	seq  uint64
+	size int64
+	spoolHeader
+}
+
+// spoolHeader is the first line of a spool file.
+type spoolHeader struct {
+	request
+	Time    int64 `json:"ts"`      // when the batch was spooled
+	Samples int   `json:"samples"` // number of samples in the body
+}
+
+// SpoolStats is a point-in-time view of the spool.
+type SpoolStats struct {
+	Batches         int
+	Bytes           int64
+	OldestAge       time.Duration
+	DroppedSamples  uint64
+	ReplayedBatches uint64
+}
+
+const spoolExt = ".batch"
+
+// OpenSpool opens (creating if needed) the spool in dir and indexes any
+// batches left over from a previous run.
+func OpenSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes, maxAge: maxAge}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+	for _, de := range names {
+		name := de.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(dir, name)) // interrupted write
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		e, err := s.readEntry(seq)
+		if err != nil {
+			log.Printf("spool: discarding unreadable batch %s: %v", name, err)
+			os.Remove(s.path(seq))
+			continue
+		}
+		s.entries = append(s.entries, e)
+		s.bytes += e.size
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].seq < s.entries[j].seq })
+	if n := len(s.entries); n > 0 {
+		s.nextSeq = s.entries[n-1].seq + 1
+	}
+
+	s.mu.Lock()
+	s.trimLocked(time.Now(), 0)
+	s.mu.Unlock()
+	return s, nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
+
+func (s *Spool) readEntry(seq uint64) (spoolEntry, error) {
+	f, err := os.Open(s.path(seq))
+	if err != nil {
+		return spoolEntry{}, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return spoolEntry{}, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return spoolEntry{}, fmt.Errorf("read header: %w", err)
+	}
+	e := spoolEntry{seq: seq, size: fi.Size()}
+	if err := json.Unmarshal(line, &e.spoolHeader); err != nil {
+		return spoolEntry{}, fmt.Errorf("parse header: %w", err)
+	}
+	return e, nil
+}
+
+// Append persists a request at the tail of the spool.
+func (s *Spool) Append(req request, samples int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	hdr := spoolHeader{request: req, Time: now.Unix(), Samples: samples}
+	line, err := json.Marshal(hdr)
+	if err != nil {
+		return err
+	}
+	size := int64(len(line) + 1 + len(req.Body))
+	if s.maxBytes > 0 && size > s.maxBytes {
+		s.dropped += uint64(samples)
+		return fmt.Errorf("batch of %d bytes exceeds spool size limit", size)
+	}
+	s.trimLocked(now, size)
+
+	seq := s.nextSeq
+	tmp := s.path(seq) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create spool file: %w", err)
+	}
+	_, err = f.Write(append(append(line, '\n'), req.Body...))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path(seq))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write spool file: %w", err)
+	}
+
+	s.nextSeq++
+	hdr.Body = nil // read back from disk on replay
+	s.entries = append(s.entries, spoolEntry{seq: seq, size: size, spoolHeader: hdr})
+	s.bytes += size
+	return nil
+}
+
+// trimLocked drops batches older than maxAge, then the oldest batches until
+// incoming more bytes fit under maxBytes.
+func (s *Spool) trimLocked(now time.Time, incoming int64) {
+	var n int
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		expired := s.maxAge > 0 && now.Sub(time.Unix(e.Time, 0)) > s.maxAge
+		full := s.maxBytes > 0 && s.bytes+incoming > s.maxBytes
+		if !expired && !full {
+			break
+		}
+		s.removeHeadLocked()
+		s.dropped += uint64(e.Samples)
+		n += e.Samples
+	}
+	if n > 0 {
+		log.Printf("spool: dropped %d samples to stay within size/age limits", n)
+	}
+}
+
+func (s *Spool) removeHeadLocked() {
+	e := s.entries[0]
+	if err := os.Remove(s.path(e.seq)); err != nil && !os.IsNotExist(err) {
+		log.Printf("spool: remove batch %d: %v", e.seq, err)
+	}
+	s.entries = s.entries[1:]
+	s.bytes -= e.size
+}
+
+// Len returns the number of spooled batches.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Peek returns the oldest request, and ok=false if the spool is empty.
+// Batches past maxAge are dropped first.
+func (s *Spool) Peek() (req request, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trimLocked(time.Now(), 0)
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		data, err := os.ReadFile(s.path(e.seq))
+		if err == nil {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				req = e.request
+				req.Body = data[i+1:]
+				return req, true
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		log.Printf("spool: discarding unreadable batch %d: %v", e.seq, err)
+		s.removeHeadLocked()
+		s.dropped += uint64(e.Samples)
+	}
+	return request{}, false
+}
+
+// Pop removes the oldest batch after it was delivered (delivered=true) or
+// permanently rejected by the hub, in which case its samples count as dropped.
+func (s *Spool) Pop(delivered bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) == 0 {
+		return
+	}
+	if delivered {
+		s.replayed++
+	} else {
+		s.dropped += uint64(s.entries[0].Samples)
+	}
+	s.removeHeadLocked()
+}
+
+// Drop counts the samples of a batch the hub permanently rejected before
+// it was spooled as dropped.
+func (s *Spool) Drop(samples int) {
+	s.mu.Lock()
+	s.dropped += uint64(samples)
+	s.mu.Unlock()
+}
+
+// Stats returns the current spool depth and counters.
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := SpoolStats{
+		Batches:         len(s.entries),
+		Bytes:           s.bytes,
+		DroppedSamples:  s.dropped,
+		ReplayedBatches: s.replayed,
+	}
+	if len(s.entries) > 0 {
+		st.OldestAge = time.Since(time.Unix(s.entries[0].Time, 0))
+	}
+	return st
+}
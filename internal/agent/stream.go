@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sergey/cudascope/internal/collector"
+)
+
+const (
+	heartbeatInterval = 15 * time.Second
+	ackTimeout        = 30 * time.Second // also the read deadline between hub messages
+	streamRetryMax    = time.Minute
+	streamRetryNoHub  = 5 * time.Minute // hub without stream support
+)
+
+var errStreamClosed = errors.New("stream closed")
+
+// stream is the agent's end of a connected ingest stream. Batches sent over
+// it are acknowledged by the hub once stored.
+type stream struct {
+	conn *websocket.Conn
+	wmu  sync.Mutex // a websocket.Conn supports only one concurrent writer
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan collector.StreamMessage
+	closed  bool
+}
+
+// streamURL maps the hub URL to its ingest stream endpoint.
+func (a *Agent) streamURL() string {
+	u := strings.TrimSuffix(a.hubURL, "/")
+	switch {
+	case strings.HasPrefix(u, "https://"):
+		u = "wss://" + strings.TrimPrefix(u, "https://")
+	case strings.HasPrefix(u, "http://"):
+		u = "ws://" + strings.TrimPrefix(u, "http://")
+	}
+	return u + "/api/v1/ingest/stream"
+}
+
+// streamLoop keeps a stream to the hub open, reconnecting with backoff.
+// While it is down, batches are posted over HTTP instead.
+func (a *Agent) streamLoop(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-a.registered:
+	}
+
+	retry := replayRetry
+	for ctx.Err() == nil {
+		established, resp, err := a.runStream(ctx)
+		if established {
+			retry = replayRetry
+		}
+		switch {
+		case resp != nil && resp.StatusCode == http.StatusNotFound:
+			log.Printf("hub does not support streaming, using HTTP")
+			retry = streamRetryNoHub
+		case err != nil:
+			if ctx.Err() == nil {
+				log.Printf("hub stream: %v (retrying in %v)", err, retry)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		if retry < streamRetryMax {
+			retry *= 2
+		}
+	}
+}
+
+// runStream dials the hub, registers and serves the stream until it fails,
+// reporting whether it got as far as registering. The handshake response is
+// returned when the upgrade is refused.
+func (a *Agent) runStream(ctx context.Context) (bool, *http.Response, error) {
+	// Authenticate the handshake; messages on the connection are bound to its node
+	hr, err := http.NewRequest("GET", a.streamURL(), nil)
+	if err != nil {
+		return false, nil, err
+	}
+	a.authenticate(hr, nil)
+	conn, resp, err := a.dialer.DialContext(ctx, a.streamURL(), hr.Header)
+	if err != nil {
+		return false, resp, err
+	}
+	st := &stream{conn: conn, pending: make(map[uint64]chan collector.StreamMessage)}
+	defer conn.Close()
+
+	// Acks are only read by serve, so run it before registering
+	done := make(chan error, 1)
+	go func() { done <- a.serve(st) }()
+
+	if err := st.call(collector.StreamMessage{Type: collector.StreamRegister, Register: a.registration()}, nil); err != nil {
+		st.close()
+		<-done
+		return false, nil, fmt.Errorf("register: %w", err)
+	}
+	log.Printf("streaming to hub at %s", a.streamURL())
+
+	a.setStream(st)
+	defer a.setStream(nil)
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			st.wmu.Lock()
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			st.wmu.Unlock()
+			st.close()
+			<-done
+			return true, nil, nil
+		case err := <-done:
+			return true, nil, err
+		case <-heartbeat.C:
+			// Not waited on: the ack only serves to keep the read deadline fresh
+			if err := st.write(collector.StreamMessage{Type: collector.StreamHeartbeat, Seq: st.nextSeq()}, nil); err != nil {
+				st.close()
+				return true, nil, <-done
+			}
+		}
+	}
+}
+
+// serve reads acks and commands from the hub until the stream fails.
+func (a *Agent) serve(st *stream) error {
+	defer st.close()
+	for {
+		st.conn.SetReadDeadline(time.Now().Add(ackTimeout + heartbeatInterval))
+		var msg collector.StreamMessage
+		if err := st.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return err
+		}
+
+		switch msg.Type {
+		case collector.StreamAck:
+			st.mu.Lock()
+			ch := st.pending[msg.Seq]
+			delete(st.pending, msg.Seq)
+			st.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+		case collector.StreamCommand:
+			a.handleCommand(st, msg.Command)
+		}
+	}
+}
+
+// handleCommand runs a command pushed by the hub.
+func (a *Agent) handleCommand(st *stream, cmd string) {
+	log.Printf("hub command: %s", cmd)
+	switch cmd {
+	case "flush":
+		go a.flush()
+	case "register":
+		go st.call(collector.StreamMessage{Type: collector.StreamRegister, Register: a.registration()}, nil)
+	default:
+		log.Printf("unknown hub command %q", cmd)
+	}
+}
+
+func (a *Agent) setStream(st *stream) {
+	a.streamMu.Lock()
+	a.stream = st
+	a.streamMu.Unlock()
+}
+
+// currentStream returns the connected stream, or nil.
+func (a *Agent) currentStream() *stream {
+	a.streamMu.Lock()
+	defer a.streamMu.Unlock()
+	return a.stream
+}
+
+func (st *stream) nextSeq() uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.seq++
+	return st.seq
+}
+
+// write sends a message, as a binary frame followed by payload if there is one.
+func (st *stream) write(msg collector.StreamMessage, payload []byte) error {
+	st.wmu.Lock()
+	defer st.wmu.Unlock()
+	st.conn.SetWriteDeadline(time.Now().Add(ackTimeout))
+	if payload == nil {
+		return st.conn.WriteJSON(msg)
+	}
+
+	w, err := st.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(msg); err != nil { // Encode ends with a newline
+		w.Close()
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// call sends a message and waits for the hub's ack. A rejected message is
+// reported as a *statusError.
+func (st *stream) call(msg collector.StreamMessage, payload []byte) error {
+	ch := make(chan collector.StreamMessage, 1)
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return errStreamClosed
+	}
+	st.seq++
+	msg.Seq = st.seq
+	st.pending[msg.Seq] = ch
+	st.mu.Unlock()
+
+	if err := st.write(msg, payload); err != nil {
+		st.close()
+		return err
+	}
+
+	select {
+	case ack, ok := <-ch:
+		if !ok {
+			return errStreamClosed
+		}
+		if ack.Status >= 400 {
+			return &statusError{path: "/api/v1/ingest/stream", code: ack.Status, msg: ack.Error}
+		}
+		return nil
+	case <-time.After(ackTimeout):
+		st.close() // the hub is stuck; reconnecting resets the sequence
+		return fmt.Errorf("stream %s: no ack within %v", msg.Type, ackTimeout)
+	}
+}
+
+// close fails all calls waiting for an ack and closes the connection.
+func (st *stream) close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return
+	}
+	st.closed = true
+	for seq, ch := range st.pending {
+		close(ch)
+		delete(st.pending, seq)
+	}
+	st.conn.Close()
+}
@@ -0,0 +1,376 @@
+package alert
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// key identifies the series an alert is raised for.
+type key struct {
+	rule   string
+	nodeID string
+	gpuID  int // -1 for host metrics
+}
+
+// Notifier is told when alerts fire and resolve, and when a firing alert is
+// silenced or unsilenced (see storage.Alert.Silenced). Notify is called with
+// the engine locked and must not block.
+type Notifier interface {
+	Notify(r *Rule, a storage.Alert)
+}
+
+// Engine periodically evaluates rules against the latest metrics. Alerts
+// start pending, fire once their rule's condition has held for its for
+// duration and resolve when it clears. Every transition is written to
+// storage; alerts that clear before firing are dropped.
+type Engine struct {
+	store       *storage.DB
+	interval    time.Duration
+	notifier    Notifier // nil = no notifications
+	health      HealthConfig
+	healthRules []Rule
+
+	mu           sync.RWMutex
+	rules        []Rule
+	fileSilences []storage.Silence      // from the rules file
+	active       map[key]*storage.Alert // pending and firing alerts
+	silences     []storage.Silence      // in effect at the last evaluation
+	maintenance  map[string]bool        // nodes in maintenance mode
+}
+
+// New creates an engine for validated rules and the built-in health checks,
+// picking up the alerts that were open when the server last stopped.
+// notifier may be nil.
+func New(store *storage.DB, rules []Rule, health HealthConfig, interval time.Duration, notifier Notifier) (*Engine, error) {
+	open, err := store.GetOpenAlerts()
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		store:       store,
+		interval:    interval,
+		notifier:    notifier,
+		health:      health,
+		healthRules: healthRules(health),
+		rules:       rules,
+		active:      make(map[key]*storage.Alert),
+	}
+	for i := range open {
+		a := open[i]
+		e.active[key{a.Rule, a.NodeID, a.GPUID}] = &a
+	}
+	return e, nil
+}
+
+// SetRules replaces the rules with a validated set. Alerts keep their state
+// if their rule still exists; those of removed rules resolve at the next
+// evaluation.
+func (e *Engine) SetRules(rules []Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+}
+
+// SetSilences sets the silences from the rules file, which apply in addition
+// to those in storage. EndsAt 0 means the silence does not end.
+func (e *Engine) SetSilences(silences []storage.Silence) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fileSilences = silences
+}
+
+// FileSilences returns the silences from the rules file.
+func (e *Engine) FileSilences() []storage.Silence {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]storage.Silence{}, e.fileSilences...)
+}
+
+// Run evaluates the rules every interval. Blocks until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.evaluate(now.Unix())
+		}
+	}
+}
+
+func (e *Engine) evaluate(now int64) {
+	gpus, err := e.store.GetLatestGPUMetrics()
+	if err != nil {
+		log.Printf("alert: get GPU metrics: %v", err)
+		return
+	}
+	hosts, err := e.store.GetLatestHostMetrics()
+	if err != nil {
+		log.Printf("alert: get host metrics: %v", err)
+		return
+	}
+	silences, err := e.store.GetActiveSilences(now)
+	if err != nil {
+		log.Printf("alert: get silences: %v", err)
+		return
+	}
+	nodes, err := e.store.GetNodes()
+	if err != nil {
+		log.Printf("alert: get nodes: %v", err)
+		return
+	}
+	health, err := e.loadHealth(now)
+	if err != nil {
+		log.Printf("alert: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, sil := range e.fileSilences {
+		if sil.StartsAt <= now && (sil.EndsAt == 0 || now < sil.EndsAt) {
+			silences = append(silences, sil)
+		}
+	}
+	e.silences = silences
+	e.maintenance = make(map[string]bool)
+	for _, n := range nodes {
+		if n.Maintenance {
+			e.maintenance[n.NodeID] = true
+		}
+	}
+
+	// What threshold overrides match against
+	labels := make(map[string]map[string]string)
+	for _, n := range nodes {
+		labels[n.NodeID] = n.Labels
+	}
+	devices := make(map[storage.GPUKey]*collector.GPUDevice)
+	for i := range health.devices {
+		d := &health.devices[i]
+		devices[storage.GPUKey{NodeID: d.NodeID, GPUID: d.ID}] = d
+	}
+	hostTargets := make([]target, len(hosts))
+	for j := range hosts {
+		hostTargets[j] = target{nodeID: hosts[j].NodeID, labels: labels[hosts[j].NodeID]}
+	}
+	gpuTargets := make([]target, len(gpus))
+	mig := make([]bool, len(gpus)) // MIG instance rows, which carry only memory use
+	for j := range gpus {
+		t := target{nodeID: gpus[j].NodeID, labels: labels[gpus[j].NodeID]}
+		d := devices[storage.GPUKey{NodeID: gpus[j].NodeID, GPUID: gpus[j].GPUID}]
+		if d != nil {
+			t.uuid, t.model = d.UUID, d.Name
+		}
+		gpuTargets[j] = t
+		mig[j] = (d != nil && d.IsMIG()) || gpus[j].GPUID >= collector.MIGDeviceID(0, 0, 0)
+	}
+
+	seen := make(map[key]bool)
+	for i := range e.rules {
+		r := &e.rules[i]
+		if f, ok := hostMetrics[r.Metric]; ok {
+			for j := range hosts {
+				h := &hosts[j]
+				if r.matches(h.NodeID, -1) {
+					k := key{r.Name, h.NodeID, -1}
+					seen[k] = true
+					e.observe(r, k, f(h), &hostTargets[j], now)
+				}
+			}
+			continue
+		}
+		f := gpuMetrics[r.Metric]
+		for j := range gpus {
+			g := &gpus[j]
+			if mig[j] && !migMetrics[r.Metric] {
+				continue
+			}
+			if r.matches(g.NodeID, g.GPUID) {
+				k := key{r.Name, g.NodeID, g.GPUID}
+				seen[k] = true
+				e.observe(r, k, f(g), &gpuTargets[j], now)
+			}
+		}
+	}
+
+	e.checkHealth(health, nodes, now, seen)
+
+	// Series that stopped reporting and rules that were removed
+	for k, a := range e.active {
+		if !seen[k] {
+			e.clear(k, a, now)
+		}
+	}
+
+	// Silences and maintenance mode that started or ended
+	for _, a := range e.active {
+		if silenced := e.silenced(a); silenced != a.Silenced {
+			a.Silenced = silenced
+			if a.State == storage.AlertFiring && e.notifier != nil {
+				e.notifier.Notify(e.rule(a.Rule), *a)
+			}
+		}
+	}
+}
+
+// silenced reports whether an alert's node is in maintenance or a silence
+// matches it.
+func (e *Engine) silenced(a *storage.Alert) bool {
+	if e.maintenance[a.NodeID] {
+		return true
+	}
+	for i := range e.silences {
+		if e.silences[i].Matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe applies one metric value to the alert of a series. t selects the
+// rule's threshold override (nil = none).
+func (e *Engine) observe(r *Rule, k key, value float64, t *target, now int64) {
+	thresh, scope := r.threshold(t)
+	a := e.active[k]
+	if !r.breached(value, thresh, a != nil && a.State == storage.AlertFiring) {
+		if a != nil {
+			e.clear(k, a, now)
+		}
+		return
+	}
+
+	if a == nil {
+		a = &storage.Alert{
+			Rule:      r.Name,
+			Severity:  r.Severity,
+			State:     storage.AlertPending,
+			NodeID:    k.nodeID,
+			GPUID:     k.gpuID,
+			Metric:    r.Metric,
+			Op:        r.Op,
+			Thresh:    thresh,
+			Scope:     scope,
+			Value:     value,
+			StartedAt: now,
+		}
+		if r.For == 0 {
+			a.State = storage.AlertFiring
+			a.FiredAt = now
+		}
+		if err := e.store.InsertAlert(a); err != nil {
+			log.Printf("alert: store %s: %v", r.Name, err)
+			return
+		}
+		e.active[k] = a
+		if a.State == storage.AlertFiring {
+			e.transition(r, a)
+		}
+		return
+	}
+
+	a.Value = value
+	a.Thresh, a.Scope = thresh, scope
+	if a.State == storage.AlertPending && now-a.StartedAt >= int64(time.Duration(r.For).Seconds()) {
+		a.State = storage.AlertFiring
+		a.FiredAt = now
+		if err := e.store.UpdateAlert(a); err != nil {
+			log.Printf("alert: update %s: %v", r.Name, err)
+			return
+		}
+		e.transition(r, a)
+	}
+}
+
+// clear resolves a firing alert and drops a pending one.
+func (e *Engine) clear(k key, a *storage.Alert, now int64) {
+	var err error
+	if a.State == storage.AlertPending {
+		err = e.store.DeleteAlert(a.ID)
+	} else {
+		a.State = storage.AlertResolved
+		a.ResolvedAt = now
+		err = e.store.UpdateAlert(a)
+	}
+	if err != nil {
+		log.Printf("alert: update %s: %v", a.Rule, err)
+		return
+	}
+	delete(e.active, k)
+	if a.State == storage.AlertResolved {
+		e.transition(e.rule(a.Rule), a)
+	}
+}
+
+// rule returns the rule of an alert. Alerts of removed rules get a stand-in
+// so their resolution still reaches the default channels.
+func (e *Engine) rule(name string) *Rule {
+	for _, rules := range [][]Rule{e.rules, e.healthRules} {
+		for i := range rules {
+			if rules[i].Name == name {
+				return &rules[i]
+			}
+		}
+	}
+	return &Rule{Name: name}
+}
+
+// transition logs and notifies an alert that fired or resolved.
+func (e *Engine) transition(r *Rule, a *storage.Alert) {
+	a.Silenced = e.silenced(a)
+	muted := ""
+	if a.Silenced {
+		muted = " (silenced)"
+	}
+	scope := ""
+	if a.Scope != "" {
+		scope = " for " + a.Scope
+	}
+	log.Printf("alert %s: %s [%s] node=%s gpu=%d %s=%.4g (%s %.4g%s)%s",
+		a.State, a.Rule, a.Severity, a.NodeID, a.GPUID, a.Metric, a.Value, a.Op, a.Thresh, scope, muted)
+	if e.notifier != nil {
+		e.notifier.Notify(r, *a)
+	}
+}
+
+// Alerts returns the current alerts in a state (pending or firing), ordered
+// by node, GPU and rule.
+func (e *Engine) Alerts(state string) []storage.Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	alerts := []storage.Alert{}
+	for _, a := range e.active {
+		if a.State == state {
+			alerts = append(alerts, *a)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		a, b := alerts[i], alerts[j]
+		if a.NodeID != b.NodeID {
+			return a.NodeID < b.NodeID
+		}
+		if a.GPUID != b.GPUID {
+			return a.GPUID < b.GPUID
+		}
+		return a.Rule < b.Rule
+	})
+	return alerts
+}
+
+// Rules returns the rules being evaluated, built-in health rules last.
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append(append([]Rule{}, e.rules...), e.healthRules...)
+}
@@ -0,0 +1,203 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sergey/cudascope/internal/anomaly"
+	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// HealthConfig configures the built-in health alerts. A zero duration
+// disables its checks.
+type HealthConfig struct {
+	// OfflineAfter raises node_offline for agent nodes (other than those in
+	// maintenance) that sent neither metrics nor heartbeats for this long.
+	OfflineAfter time.Duration
+	// StaleAfter raises gpu_metrics_stale for nodes whose host metrics keep
+	// arriving without GPU metrics, and gpu_missing for each registered GPU
+	// that stopped reporting while others on its node still do.
+	StaleAfter time.Duration
+	// Waste raises gpu_idle_allocated for GPUs that held memory while idle
+	// for Waste.MinIdle (nil = disabled).
+	Waste *storage.WasteConfig
+	// Anomaly raises gpu_anomaly for GPUs with a current anomaly scoring at
+	// least this many standard deviations (0 = disabled).
+	Anomaly float64
+}
+
+// Built-in health rules. Their thresholds are set from the HealthConfig.
+const (
+	RuleNodeOffline     = "node_offline"
+	RuleGPUMetricsStale = "gpu_metrics_stale"
+	RuleGPUMissing      = "gpu_missing"
+	RuleNVMLError       = "gpu_nvml_error"
+	RuleIdleAllocated   = "gpu_idle_allocated"
+	RuleAnomaly         = "gpu_anomaly"
+)
+
+// nvmlErrorWindow is how long a GPU's nvml_error event keeps its alert
+// firing; the collector reports errors at most once a minute.
+const nvmlErrorWindow = 5 * time.Minute
+
+func isHealthRule(name string) bool {
+	switch name {
+	case RuleNodeOffline, RuleGPUMetricsStale, RuleGPUMissing, RuleNVMLError, RuleIdleAllocated, RuleAnomaly:
+		return true
+	}
+	return false
+}
+
+// healthRules returns the rules of the enabled health checks. Values are
+// seconds since the last sample or heartbeat, recent error counts, or
+// seconds a GPU has been idle while holding memory, or anomaly scores.
+func healthRules(cfg HealthConfig) []Rule {
+	var rules []Rule
+	if cfg.OfflineAfter > 0 {
+		rules = append(rules, Rule{Name: RuleNodeOffline, Metric: "seconds_since_seen", Op: ">", Threshold: cfg.OfflineAfter.Seconds(), Severity: SeverityCritical})
+	}
+	if cfg.StaleAfter > 0 {
+		rules = append(rules,
+			Rule{Name: RuleGPUMetricsStale, Metric: "seconds_since_gpu_metrics", Op: ">", Threshold: cfg.StaleAfter.Seconds(), Severity: SeverityCritical},
+			Rule{Name: RuleGPUMissing, Metric: "seconds_since_gpu_metrics", Op: ">", Threshold: cfg.StaleAfter.Seconds(), Severity: SeverityCritical},
+		)
+	}
+	if cfg.Waste != nil {
+		rules = append(rules, Rule{Name: RuleIdleAllocated, Metric: "idle_allocated_seconds", Op: ">=", Threshold: cfg.Waste.MinIdle.Seconds(), Severity: SeverityWarning})
+	}
+	if cfg.Anomaly > 0 {
+		rules = append(rules, Rule{Name: RuleAnomaly, Metric: "anomaly_score", Op: ">=", Threshold: cfg.Anomaly, Severity: SeverityWarning})
+	}
+	return append(rules, Rule{Name: RuleNVMLError, Metric: "nvml_errors", Op: ">", Threshold: 0, Severity: SeverityWarning})
+}
+
+// healthData is what the health checks read from storage.
+type healthData struct {
+	since     int64 // start of the activity window
+	gpuLast   map[storage.GPUKey]int64
+	hostLast  map[string]int64
+	devices   []collector.GPUDevice
+	nvmlCount map[storage.GPUKey]int
+	idle      []storage.IdleGPU
+	anomalies []storage.Anomaly
+}
+
+func (e *Engine) loadHealth(now int64) (*healthData, error) {
+	window := 2 * e.health.StaleAfter
+	if window < nvmlErrorWindow {
+		window = nvmlErrorWindow
+	}
+	h := &healthData{since: now - int64(window.Seconds())}
+
+	var err error
+	if h.gpuLast, err = e.store.GetGPUActivity(h.since); err != nil {
+		return nil, fmt.Errorf("get GPU activity: %w", err)
+	}
+	if h.hostLast, err = e.store.GetHostActivity(h.since); err != nil {
+		return nil, fmt.Errorf("get host activity: %w", err)
+	}
+	if h.devices, err = e.store.GetGPUDevices(""); err != nil {
+		return nil, fmt.Errorf("get GPU devices: %w", err)
+	}
+	events, err := e.store.GetGPUEvents(storage.GPUEventsQuery{
+		GPUID: -1,
+		Type:  "nvml_error",
+		From:  now - int64(nvmlErrorWindow.Seconds()),
+		To:    now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get NVML errors: %w", err)
+	}
+	h.nvmlCount = make(map[storage.GPUKey]int)
+	for _, ev := range events {
+		h.nvmlCount[storage.GPUKey{NodeID: ev.NodeID, GPUID: ev.GPUID}]++
+	}
+	if e.health.Waste != nil {
+		if h.idle, err = e.store.GetIdleGPUs(*e.health.Waste, ""); err != nil {
+			return nil, fmt.Errorf("get idle GPUs: %w", err)
+		}
+	}
+	if e.health.Anomaly > 0 {
+		// Anomalies lag by the rollup delay; those of a stopped detector expire
+		if h.anomalies, err = e.store.GetAnomalies(now-int64(2*anomaly.Window.Seconds()), ""); err != nil {
+			return nil, fmt.Errorf("get anomalies: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// checkHealth observes the health alerts of all nodes and GPUs.
+func (e *Engine) checkHealth(h *healthData, nodes []collector.Node, now int64, seen map[key]bool) {
+	rules := make(map[string]*Rule)
+	for i := range e.healthRules {
+		rules[e.healthRules[i].Name] = &e.healthRules[i]
+	}
+	observe := func(rule, nodeID string, gpuID int, value float64) {
+		k := key{rule, nodeID, gpuID}
+		seen[k] = true
+		e.observe(rules[rule], k, value, nil, now)
+	}
+	// age is the time since a sample, or the whole window if there was none
+	age := func(last int64, ok bool) float64 {
+		if !ok {
+			last = h.since
+		}
+		return float64(now - last)
+	}
+
+	if e.health.OfflineAfter > 0 {
+		for _, n := range nodes {
+			// "local" is the standalone server's own node, which the
+			// migrations create in every database; agents never report it
+			if !n.Maintenance && n.NodeID != "local" {
+				observe(RuleNodeOffline, n.NodeID, -1, float64(now-n.LastSeen))
+			}
+		}
+	}
+
+	if e.health.StaleAfter > 0 {
+		stale := int64(e.health.StaleAfter.Seconds())
+		inventory := make(map[string][]int) // physical GPUs by node
+		for _, d := range h.devices {
+			if !d.IsMIG() {
+				inventory[d.NodeID] = append(inventory[d.NodeID], d.ID)
+			}
+		}
+		for nodeID, gpus := range inventory {
+			var newest int64
+			for _, id := range gpus {
+				newest = max(newest, h.gpuLast[storage.GPUKey{NodeID: nodeID, GPUID: id}])
+			}
+			if now-newest <= stale {
+				// GPU metrics arrive: check every registered GPU is among them
+				for _, id := range gpus {
+					last, ok := h.gpuLast[storage.GPUKey{NodeID: nodeID, GPUID: id}]
+					observe(RuleGPUMissing, nodeID, id, age(last, ok))
+				}
+			} else if host, ok := h.hostLast[nodeID]; ok && now-host <= stale {
+				// Host metrics arrive, GPU metrics do not
+				observe(RuleGPUMetricsStale, nodeID, -1, age(newest, newest > 0))
+			}
+		}
+	}
+
+	for k, n := range h.nvmlCount {
+		observe(RuleNVMLError, k.NodeID, k.GPUID, float64(n))
+	}
+
+	for _, g := range h.idle {
+		observe(RuleIdleAllocated, g.NodeID, g.GPUID, float64(g.IdleSeconds))
+	}
+
+	// The GPU's largest deviation across metrics and kinds
+	scores := make(map[storage.GPUKey]float64)
+	for _, a := range h.anomalies {
+		k := storage.GPUKey{NodeID: a.NodeID, GPUID: a.GPUID}
+		scores[k] = max(scores[k], math.Abs(a.Score))
+	}
+	for k, score := range scores {
+		observe(RuleAnomaly, k.NodeID, k.GPUID, score)
+	}
+}
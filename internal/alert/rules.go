@@ -0,0 +1,419 @@
+// Package alert evaluates alert rules against the latest GPU and host
+// metrics in the background and records the resulting alerts, with their
+// pending/firing/resolved transitions, in storage.
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// Severities, lowest first.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Rule fires when a metric of a GPU or host compares to the threshold for
+// at least the For duration.
+type Rule struct {
+	Name      string   `json:"name"`
+	Metric    string   `json:"metric"` // see Metrics
+	Op        string   `json:"op"`     // >, >=, <, <=, ==, !=
+	Threshold float64  `json:"threshold"`
+	For       Duration `json:"for,omitempty"`
+	// Hysteresis keeps a firing alert active until the metric is this far
+	// back on the other side of the threshold, so it does not flap.
+	Hysteresis float64 `json:"hysteresis,omitempty"`
+	Severity   string  `json:"severity,omitempty"` // default warning
+	Node       string  `json:"node,omitempty"`     // node ID glob, e.g. "gpu-*" (empty = all nodes)
+	GPU        *int    `json:"gpu,omitempty"`      // GPU index (nil = all GPUs; ignored for host metrics)
+	// Notify names the notification channels the rule's alerts are sent
+	// to; empty = the default channels.
+	Notify []string `json:"notify,omitempty"`
+	// Overrides replace the threshold for some GPUs or nodes; the most
+	// specific matching override wins.
+	Overrides []Override `json:"overrides,omitempty"`
+}
+
+// Override replaces a rule's threshold for the GPUs it matches. All of its
+// matchers must match. Host metric rules only use node and labels.
+type Override struct {
+	UUID      string            `json:"uuid,omitempty"`   // GPU UUID
+	Node      string            `json:"node,omitempty"`   // node ID glob
+	Labels    map[string]string `json:"labels,omitempty"` // node labels (--node-labels)
+	Model     string            `json:"model,omitempty"`  // GPU name glob, e.g. "*RTX 4090*"
+	Threshold float64           `json:"threshold"`
+}
+
+// target is what overrides are matched against.
+type target struct {
+	nodeID string
+	labels map[string]string
+	uuid   string // GPU targets only
+	model  string
+}
+
+// specificity ranks an override: a UUID beats a node, which beats labels,
+// which beat a model, and more matchers beat fewer of the same rank.
+func (o *Override) specificity() int {
+	n := 0
+	if o.UUID != "" {
+		n += 8
+	}
+	if o.Node != "" {
+		n += 4
+	}
+	if len(o.Labels) > 0 {
+		n += 2
+	}
+	if o.Model != "" {
+		n++
+	}
+	return n
+}
+
+func (o *Override) matches(t *target) bool {
+	if o.UUID != "" && o.UUID != t.uuid {
+		return false
+	}
+	if o.Node != "" {
+		if ok, _ := path.Match(o.Node, t.nodeID); !ok {
+			return false
+		}
+	}
+	for k, v := range o.Labels {
+		if lv, ok := t.labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	if o.Model != "" {
+		if ok, _ := path.Match(o.Model, t.model); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// scope describes the override's matchers, e.g. "model=*RTX 4090*,label:tier=consumer".
+func (o *Override) scope() string {
+	var parts []string
+	if o.UUID != "" {
+		parts = append(parts, "uuid="+o.UUID)
+	}
+	if o.Node != "" {
+		parts = append(parts, "node="+o.Node)
+	}
+	keys := make([]string, 0, len(o.Labels))
+	for k := range o.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, "label:"+k+"="+o.Labels[k])
+	}
+	if o.Model != "" {
+		parts = append(parts, "model="+o.Model)
+	}
+	return strings.Join(parts, ",")
+}
+
+// threshold returns the threshold for a target and the scope of the
+// override that set it ("" for the rule's own). Of equally specific
+// overrides, the first wins.
+func (r *Rule) threshold(t *target) (float64, string) {
+	var best *Override
+	if t != nil {
+		for i := range r.Overrides {
+			o := &r.Overrides[i]
+			if o.matches(t) && (best == nil || o.specificity() > best.specificity()) {
+				best = o
+			}
+		}
+	}
+	if best == nil {
+		return r.Threshold, ""
+	}
+	return best.Threshold, best.scope()
+}
+
+// Duration is a time.Duration written as a string ("5m") in rule files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func percent(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total) * 100
+}
+
+// gpuMetrics are the GPU metrics rules can use, by their API field names.
+// Flags are 1 when set.
+// migMetrics are the GPU metrics MIG instances report; rules on other
+// metrics skip them.
+var migMetrics = map[string]bool{"mem_used": true}
+
+var gpuMetrics = map[string]func(*collector.GPUMetrics) float64{
+	"gpu_util":                  func(m *collector.GPUMetrics) float64 { return m.GPUUtil },
+	"mem_util":                  func(m *collector.GPUMetrics) float64 { return m.MemUtil },
+	"mem_used":                  func(m *collector.GPUMetrics) float64 { return float64(m.MemUsed) },
+	"temperature":               func(m *collector.GPUMetrics) float64 { return float64(m.Temperature) },
+	"fan_speed":                 func(m *collector.GPUMetrics) float64 { return float64(m.FanSpeed) },
+	"power_draw":                func(m *collector.GPUMetrics) float64 { return m.PowerDraw },
+	"power_limit":               func(m *collector.GPUMetrics) float64 { return m.PowerLimit },
+	"clock_gfx":                 func(m *collector.GPUMetrics) float64 { return float64(m.ClockGfx) },
+	"clock_mem":                 func(m *collector.GPUMetrics) float64 { return float64(m.ClockMem) },
+	"pcie_tx":                   func(m *collector.GPUMetrics) float64 { return float64(m.PCIeTx) },
+	"pcie_rx":                   func(m *collector.GPUMetrics) float64 { return float64(m.PCIeRx) },
+	"pstate":                    func(m *collector.GPUMetrics) float64 { return float64(m.PState) },
+	"encoder_util":              func(m *collector.GPUMetrics) float64 { return m.EncoderUtil },
+	"decoder_util":              func(m *collector.GPUMetrics) float64 { return m.DecoderUtil },
+	"ecc_corrected_volatile":    func(m *collector.GPUMetrics) float64 { return float64(m.ECCCorrVolatile) },
+	"ecc_uncorrected_volatile":  func(m *collector.GPUMetrics) float64 { return float64(m.ECCUncorrVolatile) },
+	"ecc_corrected_aggregate":   func(m *collector.GPUMetrics) float64 { return float64(m.ECCCorrAggregate) },
+	"ecc_uncorrected_aggregate": func(m *collector.GPUMetrics) float64 { return float64(m.ECCUncorrAggregate) },
+	"retired_pages_sbe":         func(m *collector.GPUMetrics) float64 { return float64(m.RetiredPagesSBE) },
+	"retired_pages_dbe":         func(m *collector.GPUMetrics) float64 { return float64(m.RetiredPagesDBE) },
+	"retired_pending":           func(m *collector.GPUMetrics) float64 { return boolValue(m.RetiredPending) },
+	"remapped_rows_corrected":   func(m *collector.GPUMetrics) float64 { return float64(m.RemappedCorr) },
+	"remapped_rows_uncorrected": func(m *collector.GPUMetrics) float64 { return float64(m.RemappedUncorr) },
+	"remap_pending":             func(m *collector.GPUMetrics) float64 { return boolValue(m.RemapPending) },
+	"remap_failed":              func(m *collector.GPUMetrics) float64 { return boolValue(m.RemapFailed) },
+	"power_pct": func(m *collector.GPUMetrics) float64 {
+		if m.PowerLimit == 0 {
+			return 0
+		}
+		return m.PowerDraw / m.PowerLimit * 100
+	},
+	"throttled": func(m *collector.GPUMetrics) float64 {
+		for _, r := range collector.ThrottleReasons {
+			if m.ThrottleReasons&r.Bit != 0 {
+				return 1
+			}
+		}
+		return 0
+	},
+}
+
+// hostMetrics are the host metrics rules can use.
+var hostMetrics = map[string]func(*collector.HostMetrics) float64{
+	"host_cpu_percent":  func(m *collector.HostMetrics) float64 { return m.CPUPercent },
+	"host_mem_used":     func(m *collector.HostMetrics) float64 { return float64(m.MemUsed) },
+	"host_mem_percent":  func(m *collector.HostMetrics) float64 { return percent(m.MemUsed, m.MemTotal) },
+	"host_disk_used":    func(m *collector.HostMetrics) float64 { return float64(m.DiskUsed) },
+	"host_disk_percent": func(m *collector.HostMetrics) float64 { return percent(m.DiskUsed, m.DiskTotal) },
+	"host_net_rx":       func(m *collector.HostMetrics) float64 { return float64(m.NetRx) },
+	"host_net_tx":       func(m *collector.HostMetrics) float64 { return float64(m.NetTx) },
+	"host_load_1m":      func(m *collector.HostMetrics) float64 { return m.Load1m },
+	"host_load_5m":      func(m *collector.HostMetrics) float64 { return m.Load5m },
+	"host_load_15m":     func(m *collector.HostMetrics) float64 { return m.Load15m },
+}
+
+// Metrics returns the names of all metrics rules can use.
+func Metrics() []string {
+	var names []string
+	for n := range gpuMetrics {
+		names = append(names, n)
+	}
+	for n := range hostMetrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KnownMetric reports whether rules can use a metric.
+func KnownMetric(name string) bool {
+	_, gpu := gpuMetrics[name]
+	_, host := hostMetrics[name]
+	return gpu || host
+}
+
+// isHost reports whether the rule is evaluated per host rather than per GPU.
+func (r *Rule) isHost() bool {
+	_, ok := hostMetrics[r.Metric]
+	return ok
+}
+
+// breached reports whether value meets the rule's condition. A firing
+// alert stays breached until the value clears the threshold by the
+// hysteresis.
+func (r *Rule) breached(value, t float64, firing bool) bool {
+	if firing {
+		switch r.Op {
+		case ">", ">=":
+			t -= r.Hysteresis
+		case "<", "<=":
+			t += r.Hysteresis
+		}
+	}
+	switch r.Op {
+	case ">":
+		return value > t
+	case ">=":
+		return value >= t
+	case "<":
+		return value < t
+	case "<=":
+		return value <= t
+	case "==":
+		return value == t
+	case "!=":
+		return value != t
+	}
+	return false
+}
+
+// matches reports whether the rule's selectors match a node and GPU
+// (gpu is -1 for host metrics).
+func (r *Rule) matches(nodeID string, gpu int) bool {
+	if r.Node != "" {
+		if ok, _ := path.Match(r.Node, nodeID); !ok {
+			return false
+		}
+	}
+	return r.GPU == nil || gpu < 0 || *r.GPU == gpu
+}
+
+// Validate checks a rule and fills in defaults.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without name")
+	}
+	if !KnownMetric(r.Metric) {
+		return fmt.Errorf("rule %s: unknown metric %q", r.Name, r.Metric)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("rule %s: unknown operator %q", r.Name, r.Op)
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("rule %s: unknown severity %q", r.Name, r.Severity)
+	}
+	if r.For < 0 || r.Hysteresis < 0 {
+		return fmt.Errorf("rule %s: for and hysteresis must not be negative", r.Name)
+	}
+	if _, err := path.Match(r.Node, ""); err != nil {
+		return fmt.Errorf("rule %s: bad node pattern %q", r.Name, r.Node)
+	}
+	for i, o := range r.Overrides {
+		if o.specificity() == 0 {
+			return fmt.Errorf("rule %s: overrides[%d]: one of uuid, node, labels and model is required", r.Name, i)
+		}
+		if _, err := path.Match(o.Node, ""); err != nil {
+			return fmt.Errorf("rule %s: overrides[%d]: bad node pattern %q", r.Name, i, o.Node)
+		}
+		if _, err := path.Match(o.Model, ""); err != nil {
+			return fmt.Errorf("rule %s: overrides[%d]: bad model pattern %q", r.Name, i, o.Model)
+		}
+		if (o.UUID != "" || o.Model != "") && r.isHost() {
+			return fmt.Errorf("rule %s: overrides[%d]: host metrics cannot be overridden by uuid or model", r.Name, i)
+		}
+	}
+	return nil
+}
+
+// ValidateRules validates a rule set; rule names must be unique.
+func ValidateRules(rules []Rule) error {
+	names := make(map[string]bool)
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("duplicate rule name %q", rules[i].Name)
+		}
+		if isHealthRule(rules[i].Name) {
+			return fmt.Errorf("rule name %q is reserved for a built-in health alert", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return nil
+}
+
+// ValidateSilence checks the matchers of a silence.
+func ValidateSilence(s *storage.Silence) error {
+	if s.NodeID == "" && s.GPUID == nil && s.Metric == "" && s.Rule == "" {
+		return fmt.Errorf("at least one of node_id, gpu_id, metric and rule is required")
+	}
+	if _, err := path.Match(s.NodeID, ""); err != nil {
+		return fmt.Errorf("bad node_id pattern %q", s.NodeID)
+	}
+	if s.Metric != "" && !KnownMetric(s.Metric) {
+		return fmt.Errorf("unknown metric %q", s.Metric)
+	}
+	return nil
+}
+
+// LoadRules reads a JSON array of rules from a file.
+func LoadRules(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	if err := ValidateRules(rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return rules, nil
+}
+
+// LegacyRules converts the --alert-temp, --alert-gpu-util and
+// --alert-mem-util thresholds (0 = disabled) into rules.
+func LegacyRules(tempMax, gpuUtil, memUtil int) []Rule {
+	var rules []Rule
+	for _, l := range []struct {
+		name, metric string
+		thresh       int
+	}{
+		{"gpu_temperature", "temperature", tempMax},
+		{"gpu_utilization", "gpu_util", gpuUtil},
+		{"gpu_memory_utilization", "mem_util", memUtil},
+	} {
+		if l.thresh > 0 {
+			rules = append(rules, Rule{Name: l.name, Metric: l.metric, Op: ">=", Threshold: float64(l.thresh), Severity: SeverityWarning})
+		}
+	}
+	return rules
+}
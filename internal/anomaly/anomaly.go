@@ -0,0 +1,337 @@
+// Package anomaly detects GPUs whose temperature, power draw or fan speed
+// drift away from what is normal for them, catching slow degradations such
+// as a failing fan that static thresholds miss.
+//
+// For every GPU, metric and 10%-wide utilization band the detector keeps an
+// exponentially weighted mean and variance of the 1-minute rollups, so a
+// GPU is compared with itself at the same load. A sample is anomalous when
+// it is Threshold standard deviations from that baseline, or from the
+// median of its peers (GPUs of the same model on the same node in the same
+// band). Baselines are stored, so they survive restarts; on first start
+// they are learned from the rollups of the last week.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// Config configures anomaly detection.
+type Config struct {
+	Threshold float64       // standard deviations that count as an anomaly
+	HalfLife  time.Duration // of the baselines' weights
+}
+
+// Anomaly kinds.
+const (
+	KindBaseline = "baseline" // deviates from the GPU's own history
+	KindPeers    = "peers"    // deviates from GPUs of the same model on the node
+)
+
+// metric is a rollup column the detector watches. Deviations are measured
+// in at least minDev (against the baseline) or minPeerDev (against peers),
+// so that very steady metrics do not flag noise, and GPUs that always run a
+// little hotter than their neighbours are not flagged either.
+type metric struct {
+	name       string
+	unit       string
+	minDev     float64
+	minPeerDev float64
+	value      func(s *storage.RollupSample) float64
+}
+
+var metrics = []metric{
+	{"temperature", "°C", 1, 3, func(s *storage.RollupSample) float64 { return s.Temperature }},
+	{"power_draw", "W", 5, 15, func(s *storage.RollupSample) float64 { return s.PowerDraw }},
+	{"fan_speed", "%", 2, 5, func(s *storage.RollupSample) float64 { return s.FanSpeed }},
+}
+
+const (
+	// warmup is how many samples a baseline needs before it is used.
+	warmup = 60
+	// minPeers is the smallest group, the GPU included, compared as peers.
+	minPeers = 3
+	// rollupDelay is how far behind now the 1-minute rollups are complete.
+	rollupDelay = 4 * time.Minute
+	// bootstrap is how much history baselines are first learned from.
+	bootstrap = 7 * 24 * time.Hour
+	// chunk is how much history is processed at a time.
+	chunk = 6 * time.Hour
+	// Window is how long an anomaly stays current without a new
+	// anomalous sample, e.g. when its GPU stops reporting. Anomalies that
+	// started and ended in older samples (while catching up) are not
+	// logged as events.
+	Window = 10 * time.Minute
+)
+
+type baselineKey struct {
+	nodeID string
+	gpuID  int
+	metric string
+	band   int
+}
+
+type anomalyKey struct {
+	nodeID string
+	gpuID  int
+	metric string
+	kind   string
+}
+
+// start is the event of an anomaly that started.
+type start struct {
+	key   anomalyKey
+	event collector.GPUEvent
+}
+
+// Detector learns baselines from the 1-minute rollups and records the
+// anomalies it finds in storage and the GPU event log.
+type Detector struct {
+	store     *storage.DB
+	cfg       Config
+	alpha     float64 // EWMA weight of a sample
+	baselines map[baselineKey]*storage.AnomalyBaseline
+	active    map[anomalyKey]*storage.Anomaly
+	cursor    int64 // last rollup minute processed
+}
+
+// New creates a detector, loading the stored baselines and anomalies.
+func New(store *storage.DB, cfg Config) (*Detector, error) {
+	if cfg.Threshold <= 0 || cfg.HalfLife < time.Minute {
+		return nil, fmt.Errorf("anomaly threshold must be positive and half-life at least 1m")
+	}
+	d := &Detector{
+		store:     store,
+		cfg:       cfg,
+		alpha:     1 - math.Pow(2, -time.Minute.Seconds()/cfg.HalfLife.Seconds()),
+		baselines: make(map[baselineKey]*storage.AnomalyBaseline),
+		active:    make(map[anomalyKey]*storage.Anomaly),
+	}
+	baselines, err := store.GetAnomalyBaselines()
+	if err != nil {
+		return nil, err
+	}
+	for i := range baselines {
+		b := &baselines[i]
+		d.baselines[baselineKey{b.NodeID, b.GPUID, b.Metric, b.Band}] = b
+		d.cursor = max(d.cursor, b.TS)
+	}
+	anomalies, err := store.GetAnomalies(0, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range anomalies {
+		a := &anomalies[i]
+		d.active[anomalyKey{a.NodeID, a.GPUID, a.Metric, a.Kind}] = a
+	}
+	return d, nil
+}
+
+// Run processes new rollups every minute. Blocks until ctx is cancelled.
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if err := d.process(time.Now().Unix()); err != nil {
+			log.Printf("anomaly: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// process runs the detector over the rollups completed since the last call.
+func (d *Detector) process(now int64) error {
+	to := (now - int64(rollupDelay.Seconds())) / 60 * 60
+	from := max(d.cursor, now-int64(bootstrap.Seconds()))
+	if from >= to {
+		return nil
+	}
+
+	devices, err := d.store.GetGPUDevices("")
+	if err != nil {
+		return fmt.Errorf("get GPU devices: %w", err)
+	}
+	// Peers by model. GPUs in MIG mode report no utilization, so they
+	// have no peers; MIG instances are not among the rollups.
+	models := make(map[storage.GPUKey]string)
+	for _, dev := range devices {
+		if !dev.IsMIG() && !dev.MIGEnabled {
+			models[storage.GPUKey{NodeID: dev.NodeID, GPUID: dev.ID}] = dev.Name
+		}
+	}
+
+	for from < to {
+		end := min(from+int64(chunk.Seconds()), to)
+		samples, err := d.store.GetGPURollups(from, end)
+		if err != nil {
+			return fmt.Errorf("get rollups: %w", err)
+		}
+
+		changed := make(map[baselineKey]bool)
+		var starts []start
+		for i := 0; i < len(samples); {
+			j := i
+			for j < len(samples) && samples[j].TS == samples[i].TS {
+				j++
+			}
+			starts = append(starts, d.minute(samples[i:j], models, changed)...)
+			i = j
+		}
+		for k, a := range d.active {
+			if a.TS < end-int64(Window.Seconds()) {
+				delete(d.active, k)
+			}
+		}
+		var events []collector.GPUEvent
+		for _, st := range starts {
+			if a := d.active[st.key]; st.event.Timestamp >= now-int64(Window.Seconds()) || (a != nil && a.Since == st.event.Timestamp) {
+				events = append(events, st.event)
+			}
+		}
+
+		baselines := make([]storage.AnomalyBaseline, 0, len(changed))
+		for k := range changed {
+			baselines = append(baselines, *d.baselines[k])
+		}
+		anomalies := make([]storage.Anomaly, 0, len(d.active))
+		for _, a := range d.active {
+			anomalies = append(anomalies, *a)
+		}
+		if err := d.store.SaveAnomalyState(baselines, anomalies, events); err != nil {
+			return fmt.Errorf("save: %w", err)
+		}
+		d.cursor = end
+		from = end
+	}
+	return nil
+}
+
+// minute checks the samples of one rollup minute against their baselines
+// and peers, then folds them into the baselines. It returns the anomalies
+// that started.
+func (d *Detector) minute(samples []storage.RollupSample, models map[storage.GPUKey]string, changed map[baselineKey]bool) []start {
+	type peerKey struct {
+		nodeID, model string
+		band          int
+	}
+	peers := make(map[peerKey][]*storage.RollupSample)
+	for i := range samples {
+		s := &samples[i]
+		if model := models[storage.GPUKey{NodeID: s.NodeID, GPUID: s.GPUID}]; model != "" {
+			k := peerKey{s.NodeID, model, band(s.GPUUtil)}
+			peers[k] = append(peers[k], s)
+		}
+	}
+
+	var starts []start
+	for i := range samples {
+		s := &samples[i]
+		group := peers[peerKey{s.NodeID, models[storage.GPUKey{NodeID: s.NodeID, GPUID: s.GPUID}], band(s.GPUUtil)}]
+		for _, m := range metrics {
+			x := m.value(s)
+
+			bk := baselineKey{s.NodeID, s.GPUID, m.name, band(s.GPUUtil)}
+			b := d.baselines[bk]
+			if b == nil {
+				b = &storage.AnomalyBaseline{NodeID: s.NodeID, GPUID: s.GPUID, Metric: m.name, Band: bk.band}
+				d.baselines[bk] = b
+			}
+			if b.N >= warmup {
+				score := (x - b.Mean) / math.Max(math.Sqrt(b.Var), m.minDev)
+				if st := d.check(s, m, KindBaseline, x, b.Mean, score); st != nil {
+					starts = append(starts, *st)
+				}
+			}
+			d.learn(b, x, s.TS)
+			changed[bk] = true
+
+			if len(group) >= minPeers {
+				values := make([]float64, len(group))
+				for j, p := range group {
+					values[j] = m.value(p)
+				}
+				med := median(values)
+				for j := range values {
+					values[j] = math.Abs(values[j] - med)
+				}
+				// 1.4826 × MAD estimates the standard deviation
+				score := (x - med) / math.Max(1.4826*median(values), m.minPeerDev)
+				if st := d.check(s, m, KindPeers, x, med, score); st != nil {
+					starts = append(starts, *st)
+				}
+			}
+		}
+	}
+	return starts
+}
+
+// check updates the anomaly of a sample's metric, returning it if it
+// started.
+func (d *Detector) check(s *storage.RollupSample, m metric, kind string, value, expected, score float64) *start {
+	k := anomalyKey{s.NodeID, s.GPUID, m.name, kind}
+	a := d.active[k]
+	if math.Abs(score) < d.cfg.Threshold {
+		delete(d.active, k)
+		return nil
+	}
+	started := a == nil
+	if started {
+		a = &storage.Anomaly{NodeID: s.NodeID, GPUID: s.GPUID, Metric: m.name, Kind: kind, Since: s.TS}
+		d.active[k] = a
+	}
+	a.TS, a.GPUUtil, a.Value, a.Expected, a.Score = s.TS, s.GPUUtil, value, expected, score
+	if !started {
+		return nil
+	}
+
+	source := "its baseline"
+	if kind == KindPeers {
+		source = "its peers"
+	}
+	return &start{k, collector.GPUEvent{
+		NodeID:    s.NodeID,
+		Timestamp: s.TS,
+		GPUID:     s.GPUID,
+		Type:      "anomaly",
+		Description: fmt.Sprintf("%s %.1f%s at %.0f%% utilization, expected %.1f%s from %s (%+.1fσ)",
+			m.name, value, m.unit, s.GPUUtil, expected, m.unit, source, score),
+	}}
+}
+
+// learn folds a sample into a baseline. Until the EWMA weight dominates,
+// the sample mean and variance are used, so young baselines do not lean on
+// their first samples.
+func (d *Detector) learn(b *storage.AnomalyBaseline, x float64, ts int64) {
+	b.N++
+	alpha := max(d.alpha, 1/float64(b.N))
+	diff := x - b.Mean
+	b.Mean += alpha * diff
+	b.Var = (1 - alpha) * (b.Var + alpha*diff*diff)
+	b.TS = ts
+}
+
+// band returns the 10%-wide utilization band of a utilization.
+func band(util float64) int {
+	return min(max(int(util/10), 0), 9)
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
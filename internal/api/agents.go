@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sergey/cudascope/internal/collector"
+)
+
+// streamTimeout is how long the hub waits for any message (agents send a
+// heartbeat every 15s) before it considers a streaming agent gone.
+const streamTimeout = 45 * time.Second
+
+// agentCommands are the commands an agent accepts over the ingest stream.
+var agentCommands = map[string]bool{
+	"flush":    true, // send buffered samples now
+	"register": true, // re-send node and device info
+}
+
+// agentRegistry tracks agents connected over the ingest stream and the
+// device hashes agents registered with.
+type agentRegistry struct {
+	mu     sync.Mutex
+	conns  map[string]*agentConn
+	gone   map[string]int64  // node -> unix time its stream disconnected
+	hashes map[string]string // node -> registered device hash (cache of nodes.device_hash)
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{
+		conns:  make(map[string]*agentConn),
+		gone:   make(map[string]int64),
+		hashes: make(map[string]string),
+	}
+}
+
+func (ar *agentRegistry) setHash(nodeID, hash string) {
+	ar.mu.Lock()
+	ar.hashes[nodeID] = hash
+	ar.mu.Unlock()
+}
+
+// checkNode verifies that an ingesting node is registered with the given
+// device inventory (hash may be empty to skip that check). If not, it
+// returns 409 Conflict, which tells the agent to register again.
+func (s *Server) checkNode(nodeID, hash string) (int, error) {
+	s.agents.mu.Lock()
+	known, ok := s.agents.hashes[nodeID]
+	s.agents.mu.Unlock()
+
+	if !ok {
+		var err error
+		known, ok, err = s.store.GetNodeDeviceHash(nodeID)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("get node: %w", err)
+		}
+		if !ok {
+			return http.StatusConflict, fmt.Errorf("unknown node %q, register first", nodeID)
+		}
+		s.agents.setHash(nodeID, known)
+	}
+
+	if hash != "" && hash != known {
+		return http.StatusConflict, fmt.Errorf("device inventory of node %q changed, register again", nodeID)
+	}
+	return http.StatusOK, nil
+}
+
+// agentConn is the hub's end of one agent's stream.
+type agentConn struct {
+	nodeID string
+	conn   *websocket.Conn
+	wmu    sync.Mutex // a websocket.Conn supports only one concurrent writer
+}
+
+func (c *agentConn) send(msg collector.StreamMessage) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.conn.WriteJSON(msg)
+}
+
+func (ar *agentRegistry) add(c *agentConn) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if old := ar.conns[c.nodeID]; old != nil {
+		old.conn.Close() // superseded by a reconnect
+	}
+	ar.conns[c.nodeID] = c
+	delete(ar.gone, c.nodeID)
+}
+
+func (ar *agentRegistry) remove(c *agentConn) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.conns[c.nodeID] == c {
+		delete(ar.conns, c.nodeID)
+		ar.gone[c.nodeID] = time.Now().Unix()
+	}
+}
+
+func (ar *agentRegistry) get(nodeID string) *agentConn {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	return ar.conns[nodeID]
+}
+
+// apply overrides the last_seen heuristic with the stream state: a node with
+// an open stream is online, and one whose stream closed is offline until it
+// is heard from again.
+func (ar *agentRegistry) apply(nodes []collector.Node) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for i := range nodes {
+		n := &nodes[i]
+		if _, ok := ar.conns[n.NodeID]; ok {
+			n.Online, n.Streaming = true, true
+		} else if t, ok := ar.gone[n.NodeID]; ok && n.LastSeen <= t {
+			n.Online = false
+		}
+	}
+}
+
+// handleIngestStream serves an agent's ingest stream: registration, sample
+// batches (each acknowledged once stored) and heartbeats from the agent, and
+// commands to it.
+func (s *Server) handleIngestStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("agent stream upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxBatchBytes)
+
+	// The first message identifies the agent
+	conn.SetReadDeadline(time.Now().Add(streamTimeout))
+	var hello collector.StreamMessage
+	if err := conn.ReadJSON(&hello); err != nil || hello.Type != collector.StreamRegister || hello.Register == nil {
+		log.Printf("agent stream from %s: expected register message", r.RemoteAddr)
+		return
+	}
+	ac := &agentConn{nodeID: hello.Register.NodeID, conn: conn}
+	code, err := authorizeNode(r, ac.nodeID)
+	if err == nil {
+		code, err = s.registerAgent(hello.Register)
+	}
+	ac.send(ack(hello.Seq, code, err))
+	if err != nil {
+		return
+	}
+
+	s.agents.add(ac)
+	log.Printf("agent %s connected over stream from %s", ac.nodeID, r.RemoteAddr)
+	defer func() {
+		s.agents.remove(ac)
+		log.Printf("agent %s stream closed", ac.nodeID)
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamTimeout))
+		msg, payload, err := readStreamMessage(conn)
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("agent %s stream: %v", ac.nodeID, err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case collector.StreamHeartbeat:
+			s.store.UpdateNodeSeen(ac.nodeID)
+			code, err = http.StatusOK, nil
+		case collector.StreamRegister:
+			if msg.Register == nil || msg.Register.NodeID != ac.nodeID {
+				code, err = http.StatusBadRequest, fmt.Errorf("node_id does not match stream")
+				break
+			}
+			code, err = s.registerAgent(msg.Register)
+		case collector.StreamBatch:
+			var batch *collector.IngestBatch
+			batch, code, err = decodeBatch(bytes.NewReader(payload), msg.Encoding)
+			if err == nil && batch.NodeID != ac.nodeID {
+				code, err = http.StatusBadRequest, fmt.Errorf("node_id does not match stream")
+			}
+			if err == nil {
+				code, err = s.ingestBatch(batch)
+			}
+			if err != nil {
+				log.Printf("agent %s batch %d rejected: %v", ac.nodeID, msg.Seq, err)
+			}
+		default:
+			code, err = http.StatusBadRequest, fmt.Errorf("unknown message type %q", msg.Type)
+		}
+
+		if msg.Seq != 0 {
+			if err := ac.send(ack(msg.Seq, code, err)); err != nil {
+				log.Printf("agent %s stream: %v", ac.nodeID, err)
+				return
+			}
+		}
+	}
+}
+
+// readStreamMessage reads a frame: a JSON text message, or a binary message
+// holding the JSON message, a newline and a payload.
+func readStreamMessage(conn *websocket.Conn) (collector.StreamMessage, []byte, error) {
+	var msg collector.StreamMessage
+	mt, data, err := conn.ReadMessage()
+	if err != nil {
+		return msg, nil, err
+	}
+	var payload []byte
+	if mt == websocket.BinaryMessage {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return msg, nil, fmt.Errorf("binary frame without header")
+		}
+		data, payload = data[:i], data[i+1:]
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, nil, fmt.Errorf("bad message: %w", err)
+	}
+	return msg, payload, nil
+}
+
+func ack(seq uint64, code int, err error) collector.StreamMessage {
+	msg := collector.StreamMessage{Type: collector.StreamAck, Seq: seq, Status: code}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return msg
+}
+
+// handleNodeCommand sends a command to an agent connected over the stream.
+func (s *Server) handleNodeCommand(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if r.Method != "POST" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !agentCommands[req.Command] {
+		httpError(w, "unknown command", http.StatusBadRequest)
+		return
+	}
+
+	ac := s.agents.get(nodeID)
+	if ac == nil {
+		httpError(w, "agent not connected", http.StatusNotFound)
+		return
+	}
+	if err := ac.send(collector.StreamMessage{Type: collector.StreamCommand, Command: req.Command}); err != nil {
+		httpError(w, "send command: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
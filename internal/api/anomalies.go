@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sergey/cudascope/internal/anomaly"
+)
+
+// handleAnomalies lists the current anomalies (?node= filters), largest
+// deviation first. Past anomalies are in the event log as type "anomaly".
+func (s *Server) handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-2 * anomaly.Window).Unix()
+	anomalies, err := s.store.GetAnomalies(since, r.URL.Query().Get("node"))
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if anomalies == nil {
+		writeJSON(w, []struct{}{})
+		return
+	}
+	writeJSON(w, anomalies)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sergey/cudascope/internal/ingestauth"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// IngestAuth configures authentication of agent ingest requests. With no
+// method configured, ingest is open (trusted network only), though API
+// tokens presented by agents are still checked.
+type IngestAuth struct {
+	Secret    string         // HMAC secret the agents' node keys are derived from
+	ClientCAs *x509.CertPool // CAs of agent client certificates; the certificate CN is the node ID
+	Tokens    bool           // require node-scoped agent API tokens
+}
+
+func (a IngestAuth) enabled() bool {
+	return a.Secret != "" || a.ClientCAs != nil || a.Tokens
+}
+
+type ingestNodeKey struct{}
+
+// authenticateIngest establishes which node an ingest request comes from:
+// the CN of its verified client certificate, the node of its HMAC signature
+// or the node an API token is scoped to. The node is stored in the returned
+// request's context. Tokens not scoped to a node cannot ingest, since
+// nothing would stop them from writing as any node.
+func (s *Server) authenticateIngest(w http.ResponseWriter, r *http.Request) (*http.Request, int, error) {
+	var node string
+	secret := credential(r)
+	switch {
+	case s.ingestAuth.ClientCAs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+		node = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if node == "" {
+			return nil, http.StatusUnauthorized, fmt.Errorf("client certificate has no CN")
+		}
+
+	case s.ingestAuth.Secret != "" && ingestauth.Signed(r):
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("read body: %w", err)
+		}
+		node, err = ingestauth.Verify(s.ingestAuth.Secret, r, body)
+		if err != nil {
+			return nil, http.StatusUnauthorized, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+	case secret != "":
+		t, err := s.store.LookupToken(secret)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("look up token: %w", err)
+		}
+		if t == nil {
+			return nil, http.StatusUnauthorized, fmt.Errorf("invalid or expired token")
+		}
+		if t.Role != storage.RoleAgent && t.Role != storage.RoleAdmin {
+			return nil, http.StatusForbidden, fmt.Errorf("%s tokens cannot ingest", t.Role)
+		}
+		if t.NodeID == "" {
+			return nil, http.StatusForbidden, fmt.Errorf("token %q is not scoped to a node and cannot ingest", t.Name)
+		}
+		node = t.NodeID
+
+	case !s.ingestAuth.enabled():
+		return r, http.StatusOK, nil
+
+	default:
+		return nil, http.StatusUnauthorized, fmt.Errorf("ingest requires a client certificate, a signed request or an agent token")
+	}
+	return r.WithContext(context.WithValue(r.Context(), ingestNodeKey{}, node)), http.StatusOK, nil
+}
+
+// ingestNode returns the node an ingest request authenticated as, if any.
+func ingestNode(r *http.Request) (string, bool) {
+	node, ok := r.Context().Value(ingestNodeKey{}).(string)
+	return node, ok
+}
+
+// authorizeNode checks that an authenticated agent writes only as its own node.
+func authorizeNode(r *http.Request, nodeID string) (int, error) {
+	if node, ok := ingestNode(r); ok && node != nodeID {
+		return http.StatusForbidden, fmt.Errorf("authenticated as node %q, cannot write as %q", node, nodeID)
+	}
+	return http.StatusOK, nil
+}
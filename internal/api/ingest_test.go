@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sergey/cudascope/internal/agent"
+	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// newIngestServer starts a hub without ingest authentication.
+func newIngestServer(t *testing.T) (*storage.DB, string) {
+	t.Helper()
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := NewServer(db, NewHub(), nil, false, "", "", nil, nil, storage.WasteConfig{}, IngestAuth{}, SSOConfig{})
+	ts := httptest.NewServer(s.middleware(s.mux))
+	t.Cleanup(ts.Close)
+	return db, ts.URL
+}
+
+func TestIngestBatchEncodings(t *testing.T) {
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			db, hubURL := newIngestServer(t)
+			a := agent.New(hubURL, "node-1", nil, 0, encoding, nil, nil, "", "")
+			devices := []collector.GPUDevice{{ID: 0, UUID: "GPU-0", Name: "H100"}, {ID: 1, UUID: "GPU-1", Name: "H100"}}
+			if err := a.Register(context.Background(), devices); err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			now := time.Now().Unix()
+			if err := a.WriteGPUMetrics([]collector.GPUMetrics{{Timestamp: now, GPUID: 0, GPUUtil: 42}, {Timestamp: now, GPUID: 1, GPUUtil: 7}}); err != nil {
+				t.Fatalf("WriteGPUMetrics: %v", err)
+			}
+
+			metrics, err := db.GetLatestGPUMetrics()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(metrics) != 2 || metrics[0].NodeID != "node-1" || metrics[0].GPUUtil != 42 || metrics[1].GPUUtil != 7 {
+				t.Errorf("stored metrics %+v", metrics)
+			}
+		})
+	}
+}
+
+func TestIngestBatchUncompressed(t *testing.T) {
+	db, hubURL := newIngestServer(t)
+	post := func(path, encoding string, payload any) int {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", hubURL+path, bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	reg := collector.Registration{NodeID: "node-1", Devices: []collector.GPUDevice{{ID: 0, UUID: "GPU-0"}}}
+	if code := post("/api/v1/ingest/register", "", reg); code != http.StatusOK {
+		t.Fatalf("register: %d", code)
+	}
+	batch := collector.IngestBatch{
+		Version:   collector.IngestBatchVersion,
+		NodeID:    "node-1",
+		Snapshots: []collector.Snapshot{{Type: "gpu_metrics", GPUs: []collector.GPUMetrics{{Timestamp: time.Now().Unix(), GPUID: 0, GPUUtil: 42}}}},
+	}
+	for _, encoding := range []string{"", "identity"} {
+		if code := post("/api/v1/ingest/batch", encoding, batch); code != http.StatusOK {
+			t.Errorf("Content-Encoding %q: %d", encoding, code)
+		}
+	}
+	if code := post("/api/v1/ingest/batch", "br", batch); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Content-Encoding br: %d, want 415", code)
+	}
+	if code := post("/api/v1/ingest/batch", "zstd", batch); code != http.StatusBadRequest {
+		t.Errorf("uncompressed body sent as zstd: %d, want 400", code)
+	}
+
+	metrics, err := db.GetLatestGPUMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].GPUUtil != 42 {
+		t.Errorf("stored metrics %+v", metrics)
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"io"
+	"net/http"
+)
+
+// maxRulesFileSize limits the rules files accepted for validation.
+const maxRulesFileSize = 1 << 20
+
+// handleRulesValidate checks a rules file as the server would load it, with
+// the rules and channels configured on the command line:
+// POST /api/v1/rules/validate with the YAML file as the body.
+func (s *Server) handleRulesValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRulesFileSize))
+	if err != nil {
+		httpError(w, "read body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := s.rules.Parse(data)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	channels := 0
+	if cfg.Notify != nil {
+		channels = len(cfg.Notify.Channels)
+	}
+	writeJSON(w, map[string]any{
+		"valid":    true,
+		"rules":    len(cfg.Rules),
+		"channels": channels,
+		"silences": len(cfg.Silences),
+	})
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
@@ -12,26 +16,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/sergey/cudascope/internal/alert"
 	"github.com/sergey/cudascope/internal/collector"
+	"github.com/sergey/cudascope/internal/oidc"
+	"github.com/sergey/cudascope/internal/rulesfile"
 	"github.com/sergey/cudascope/internal/storage"
 )
 
-// AlertConfig holds configurable alert thresholds.
-type AlertConfig struct {
-	TempMax int // °C, 0 = disabled
-	GPUUtil int // %, 0 = disabled
-	MemUtil int // %, 0 = disabled
-}
-
-// Alert represents an active alert.
-type Alert struct {
-	NodeID  string `json:"node_id"`
-	GPUID   int    `json:"gpu_id"`
-	Metric  string `json:"metric"`  // "temperature", "gpu_util", "mem_util"
-	Value   float64 `json:"value"`
-	Thresh  float64 `json:"threshold"`
-}
-
 // Server is the HTTP API server.
 type Server struct {
 	store    *storage.DB
@@ -42,22 +34,34 @@ type Server struct {
 	uiDir    string
 	authUser string // basic auth (empty = disabled)
 	authPass string
-	alerts   AlertConfig
+	alerts   *alert.Engine
+	rules    *rulesfile.Loader
+	waste    storage.WasteConfig // zero disables the waste report
+
+	agents     *agentRegistry // agents connected over the ingest stream
+	ingestAuth IngestAuth
 
-	alertsMu     sync.RWMutex
-	activeAlerts []Alert
+	sso    SSOConfig
+	ssoKey []byte // signs session and login cookies
+	ssoMu  sync.Mutex
+	oidc   *oidc.Provider // discovered on first login
 }
 
 // NewServer creates a new API server.
-func NewServer(store *storage.DB, hub *Hub, uiFS fs.FS, devMode bool, uiDir string, auth string, alertCfg AlertConfig) *Server {
+func NewServer(store *storage.DB, hub *Hub, uiFS fs.FS, devMode bool, uiDir string, auth string, alerts *alert.Engine, rules *rulesfile.Loader, waste storage.WasteConfig, ingestAuth IngestAuth, sso SSOConfig) *Server {
 	s := &Server{
-		store:   store,
-		hub:     hub,
-		mux:     http.NewServeMux(),
-		uiFS:    uiFS,
-		devMode: devMode,
-		uiDir:   uiDir,
-		alerts:  alertCfg,
+		store:      store,
+		hub:        hub,
+		mux:        http.NewServeMux(),
+		uiFS:       uiFS,
+		devMode:    devMode,
+		uiDir:      uiDir,
+		alerts:     alerts,
+		rules:      rules,
+		waste:      waste,
+		agents:     newAgentRegistry(),
+		ingestAuth: ingestAuth,
+		sso:        sso,
 	}
 	if auth != "" {
 		if parts := strings.SplitN(auth, ":", 2); len(parts) == 2 {
@@ -66,6 +70,18 @@ func NewServer(store *storage.DB, hub *Hub, uiFS fs.FS, devMode bool, uiDir stri
 			log.Printf("basic auth enabled for user %q", s.authUser)
 		}
 	}
+	if sso.enabled() {
+		if sso.SessionSecret != "" {
+			s.ssoKey = []byte(sso.SessionSecret)
+		} else {
+			s.ssoKey = []byte(oidc.RandomString(32))
+		}
+		log.Printf("SSO enabled (issuer %s)", sso.OIDC.Issuer)
+	}
+	if ingestAuth.enabled() {
+		log.Printf("ingest authentication enabled (client certificates: %t, signed requests: %t, tokens: %t)",
+			ingestAuth.ClientCAs != nil, ingestAuth.Secret != "", ingestAuth.Tokens)
+	}
 	s.routes()
 	return s
 }
@@ -74,10 +90,28 @@ func (s *Server) routes() {
 	// Read endpoints
 	s.mux.HandleFunc("/api/v1/status", s.handleStatus)
 	s.mux.HandleFunc("/api/v1/nodes", s.handleNodes)
+	s.mux.HandleFunc("/api/v1/nodes/", s.handleNodeRoute)
 	s.mux.HandleFunc("/api/v1/gpus", s.handleGPUs)
 	s.mux.HandleFunc("/api/v1/gpus/", s.handleGPURoute)
 	s.mux.HandleFunc("/api/v1/host/metrics", s.handleHostMetrics)
 	s.mux.HandleFunc("/api/v1/alerts", s.handleAlerts)
+	s.mux.HandleFunc("/api/v1/alerts/history", s.handleAlertHistory)
+	s.mux.HandleFunc("/api/v1/silences", s.handleSilences)
+	s.mux.HandleFunc("/api/v1/silences/", s.handleSilence)
+	s.mux.HandleFunc("/api/v1/rules/validate", s.handleRulesValidate)
+	s.mux.HandleFunc("/api/v1/events", s.handleEvents)
+	s.mux.HandleFunc("/api/v1/jobs", s.handleJobs)
+	s.mux.HandleFunc("/api/v1/jobs/", s.handleJob)
+	s.mux.HandleFunc("/api/v1/waste", s.handleWaste)
+	s.mux.HandleFunc("/api/v1/anomalies", s.handleAnomalies)
+	s.mux.HandleFunc("/api/v1/auth/me", s.handleMe)
+	if s.sso.enabled() {
+		s.mux.HandleFunc("/auth/login", s.handleLogin)
+		s.mux.HandleFunc("/auth/callback", s.handleCallback)
+		s.mux.HandleFunc("/auth/logout", s.handleLogout)
+	}
+	s.mux.HandleFunc("/api/v1/tokens", s.handleTokens)
+	s.mux.HandleFunc("/api/v1/tokens/", s.handleToken)
 	s.mux.HandleFunc("/api/v1/ws", s.hub.HandleWS)
 	s.mux.HandleFunc("/api/v1/healthz", s.handleHealthz)
 	s.mux.HandleFunc("/metrics", s.handlePrometheus)
@@ -87,6 +121,10 @@ func (s *Server) routes() {
 	s.mux.HandleFunc("/api/v1/ingest/gpu-metrics", s.handleIngestGPUMetrics)
 	s.mux.HandleFunc("/api/v1/ingest/host-metrics", s.handleIngestHostMetrics)
 	s.mux.HandleFunc("/api/v1/ingest/gpu-processes", s.handleIngestGPUProcesses)
+	s.mux.HandleFunc("/api/v1/ingest/gpu-events", s.handleIngestGPUEvents)
+	s.mux.HandleFunc("/api/v1/ingest/nvlink", s.handleIngestNVLink)
+	s.mux.HandleFunc("/api/v1/ingest/batch", s.handleIngestBatch)
+	s.mux.HandleFunc("/api/v1/ingest/stream", s.handleIngestStream)
 
 	// Serve UI
 	if s.devMode {
@@ -122,39 +160,74 @@ func (s *Server) spaHandler() http.Handler {
 }
 
 // HTTPServer creates the configured *http.Server (caller starts and shuts it down).
+// When agent client certificates are verified, the server must be started
+// with ListenAndServeTLS; certificates are requested but optional, so that
+// browsers are not prompted and other ingest methods keep working.
 func (s *Server) HTTPServer(port int) *http.Server {
 	addr := fmt.Sprintf(":%d", port)
-	return &http.Server{
+	srv := &http.Server{
 		Addr:         addr,
 		Handler:      s.middleware(s.mux),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	if s.ingestAuth.ClientCAs != nil {
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  s.ingestAuth.ClientCAs,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+	return srv
 }
 
 func (s *Server) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// CORS
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
-		// Basic auth (skip healthz and ingest endpoints)
-		if s.authUser != "" {
-			path := r.URL.Path
-			if path != "/api/v1/healthz" && !strings.HasPrefix(path, "/api/v1/ingest/") {
-				user, pass, ok := r.BasicAuth()
-				if !ok || user != s.authUser || pass != s.authPass {
+		// Agent ingest authenticates separately from users
+		if strings.HasPrefix(r.URL.Path, "/api/v1/ingest/") {
+			var code int
+			var err error
+			if r, code, err = s.authenticateIngest(w, r); err != nil {
+				httpError(w, err.Error(), code)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Basic auth, API tokens or SSO session (skip healthz and the login flow)
+		if r.URL.Path != "/api/v1/healthz" && !strings.HasPrefix(r.URL.Path, "/auth/") {
+			p, code, err := s.authenticateUser(r)
+			if err == nil {
+				code, err = authorizeUser(p.Role, r)
+			}
+			if code == http.StatusUnauthorized {
+				if s.sso.enabled() {
+					// Browsers are sent to log in; API clients get a plain 401
+					if r.Method == "GET" && !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+						loginRedirect(w, r)
+						return
+					}
+				} else {
 					w.Header().Set("WWW-Authenticate", `Basic realm="CudaScope"`)
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
 				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
 			}
+			if err != nil {
+				httpError(w, err.Error(), code)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
 		}
 
 		next.ServeHTTP(w, r)
@@ -173,6 +246,7 @@ func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "get nodes: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.agents.apply(nodes)
 	if nodes == nil {
 		writeJSON(w, []struct{}{})
 		return
@@ -205,6 +279,7 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	procs, _ := s.store.GetAllGPUProcesses()
 
 	nodes, _ := s.store.GetNodes()
+	s.agents.apply(nodes)
 
 	// Filter by node if specified
 	if nodeFilter != "" {
@@ -213,25 +288,19 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		procs = filterProcByNode(procs, nodeFilter)
 	}
 
-	// Check alerts against latest GPU metrics
-	s.checkAlerts(gpus)
-
-	s.alertsMu.RLock()
-	alerts := s.activeAlerts
-	s.alertsMu.RUnlock()
+	alerts := s.alerts.Alerts(storage.AlertFiring)
+	if nodeFilter != "" {
+		alerts = filterAlertsByNode(alerts, nodeFilter)
+	}
 
-	resp := map[string]any{
+	writeJSON(w, map[string]any{
 		"nodes":     nodes,
 		"devices":   devices,
 		"gpus":      gpus,
 		"hosts":     hosts,
 		"processes": procs,
 		"alerts":    alerts,
-	}
-	if alerts == nil {
-		resp["alerts"] = []struct{}{}
-	}
-	writeJSON(w, resp)
+	})
 }
 
 // handleGPUs lists GPU devices.
@@ -251,33 +320,100 @@ func (s *Server) handleGPUs(w http.ResponseWriter, r *http.Request) {
 
 // handleGPURoute dispatches /api/v1/gpus/:id/... routes.
 func (s *Server) handleGPURoute(w http.ResponseWriter, r *http.Request) {
-	// Parse: /api/v1/gpus/{id}/metrics or /api/v1/gpus/{id}/processes
+	// Parse: /api/v1/gpus/{id|uuid}/metrics, /processes, /throttle or /nvlink
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	// api / v1 / gpus / {id} / {action}
+	// api / v1 / gpus / {id|uuid} / {action}
 	if len(parts) < 5 {
 		httpError(w, "invalid path", http.StatusBadRequest)
 		return
 	}
 
+	// A GPU is addressed by index (with ?node=) or by GPU/MIG UUID
+	nodeID := r.URL.Query().Get("node")
 	gpuID, err := strconv.Atoi(parts[3])
 	if err != nil {
-		httpError(w, "invalid gpu id", http.StatusBadRequest)
-		return
+		dev, err := s.store.GetGPUDeviceByUUID(parts[3])
+		if err != nil {
+			httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if dev == nil {
+			httpError(w, "unknown gpu", http.StatusNotFound)
+			return
+		}
+		gpuID, nodeID = dev.ID, dev.NodeID
 	}
 
 	switch parts[4] {
 	case "metrics":
-		s.handleGPUMetrics(w, r, gpuID)
+		s.handleGPUMetrics(w, r, gpuID, nodeID)
 	case "processes":
-		s.handleGPUProcesses(w, r, gpuID)
+		s.handleGPUProcesses(w, r, gpuID, nodeID)
+	case "throttle":
+		s.handleGPUThrottle(w, r, gpuID, nodeID)
+	case "nvlink":
+		s.handleGPUNVLink(w, r, gpuID, nodeID)
 	default:
 		httpError(w, "unknown action", http.StatusNotFound)
 	}
 }
 
-func (s *Server) handleGPUMetrics(w http.ResponseWriter, r *http.Request, gpuID int) {
+// handleGPUNVLink returns raw NVLink samples of a GPU over time.
+func (s *Server) handleGPUNVLink(w http.ResponseWriter, r *http.Request, gpuID int, nodeID string) {
+	from, to := parseTimeRange(r)
+
+	links, err := s.store.GetNVLinkMetrics(storage.GPUMetricsQuery{
+		GPUID:  gpuID,
+		NodeID: nodeID,
+		From:   from,
+		To:     to,
+	})
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if links == nil {
+		writeJSON(w, []struct{}{})
+		return
+	}
+	writeJSON(w, links)
+}
+
+// handleNodeRoute dispatches /api/v1/nodes/:node/... routes.
+func (s *Server) handleNodeRoute(w http.ResponseWriter, r *http.Request) {
+	// Parse: /api/v1/nodes/{node}/{topology,commands,maintenance}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// api / v1 / nodes / {node} / {action}
+	if len(parts) < 5 || parts[3] == "" {
+		httpError(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	switch parts[4] {
+	case "topology":
+		s.handleNodeTopology(w, r, parts[3])
+	case "commands":
+		s.handleNodeCommand(w, r, parts[3])
+	case "maintenance":
+		s.handleNodeMaintenance(w, r, parts[3])
+	default:
+		httpError(w, "unknown action", http.StatusNotFound)
+	}
+}
+
+// handleNodeTopology returns the NVLink topology of a node from its latest
+// link samples: direct GPU peers and NVSwitch attachments.
+func (s *Server) handleNodeTopology(w http.ResponseWriter, r *http.Request, nodeID string) {
+	links, err := s.store.GetLatestNVLinks(nodeID)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, collector.BuildNVLinkTopology(nodeID, links))
+}
+
+func (s *Server) handleGPUMetrics(w http.ResponseWriter, r *http.Request, gpuID int, nodeID string) {
 	from, to := parseTimeRange(r)
-	nodeID := r.URL.Query().Get("node")
 
 	metrics, err := s.store.GetGPUMetrics(storage.GPUMetricsQuery{
 		GPUID:  gpuID,
@@ -296,13 +432,16 @@ func (s *Server) handleGPUMetrics(w http.ResponseWriter, r *http.Request, gpuID
 	writeJSON(w, metrics)
 }
 
-func (s *Server) handleGPUProcesses(w http.ResponseWriter, r *http.Request, gpuID int) {
-	nodeID := r.URL.Query().Get("node")
+// handleGPUProcesses returns the current processes of a GPU, optionally
+// filtered by Kubernetes namespace (?namespace=) and container name or ID
+// prefix (?container=).
+func (s *Server) handleGPUProcesses(w http.ResponseWriter, r *http.Request, gpuID int, nodeID string) {
 	procs, err := s.store.GetGPUProcesses(gpuID, nodeID)
 	if err != nil {
 		httpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	procs = filterProcByOwner(procs, r.URL.Query().Get("namespace"), r.URL.Query().Get("container"))
 	if procs == nil {
 		writeJSON(w, []struct{}{})
 		return
@@ -310,6 +449,112 @@ func (s *Server) handleGPUProcesses(w http.ResponseWriter, r *http.Request, gpuI
 	writeJSON(w, procs)
 }
 
+// handleGPUThrottle returns seconds spent throttled per reason, bucketed over
+// time, plus totals for the whole range.
+func (s *Server) handleGPUThrottle(w http.ResponseWriter, r *http.Request, gpuID int, nodeID string) {
+	from, to := parseTimeRange(r)
+
+	buckets, err := s.store.GetThrottleSeconds(storage.GPUMetricsQuery{
+		GPUID:  gpuID,
+		NodeID: nodeID,
+		From:   from,
+		To:     to,
+	})
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	total := make(map[string]float64, len(collector.ThrottleReasons))
+	for _, reason := range collector.ThrottleReasons {
+		total[reason.Name] = 0
+	}
+	for _, b := range buckets {
+		for name, sec := range b.Seconds {
+			total[name] += sec
+		}
+	}
+
+	resp := map[string]any{
+		"buckets": buckets,
+		"total":   total,
+	}
+	if buckets == nil {
+		resp["buckets"] = []struct{}{}
+	}
+	writeJSON(w, resp)
+}
+
+// handleJobs lists Slurm jobs seen within the time range (?user=, ?node=).
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	from, to := parseTimeRange(r)
+	jobs, err := s.store.GetJobs(storage.JobsQuery{
+		From:   from,
+		To:     to,
+		User:   r.URL.Query().Get("user"),
+		NodeID: r.URL.Query().Get("node"),
+	})
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		writeJSON(w, []struct{}{})
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+// jobSeries is the metric history of one GPU while a job ran on it.
+type jobSeries struct {
+	NodeID  string                 `json:"node_id"`
+	GPUID   int                    `json:"gpu_id"`
+	Metrics []collector.GPUMetrics `json:"metrics"`
+}
+
+// handleJob returns a job with the GPU time series of every GPU it used,
+// each covering the span the job was seen on that GPU.
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/jobs/")
+	if id == "" || strings.Contains(id, "/") {
+		httpError(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.store.GetJob(id)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		httpError(w, "unknown job", http.StatusNotFound)
+		return
+	}
+
+	series := make([]jobSeries, 0, len(job.GPUs))
+	for _, g := range job.GPUs {
+		metrics, err := s.store.GetGPUMetrics(storage.GPUMetricsQuery{
+			GPUID:  g.GPUID,
+			NodeID: g.NodeID,
+			From:   g.FirstSeen,
+			To:     g.LastSeen,
+		})
+		if err != nil {
+			httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if metrics == nil {
+			metrics = []collector.GPUMetrics{}
+		}
+		series = append(series, jobSeries{NodeID: g.NodeID, GPUID: g.GPUID, Metrics: metrics})
+	}
+
+	writeJSON(w, map[string]any{
+		"job":    job,
+		"series": series,
+	})
+}
+
 func (s *Server) handleHostMetrics(w http.ResponseWriter, r *http.Request) {
 	from, to := parseTimeRange(r)
 	nodeID := r.URL.Query().Get("node")
@@ -326,6 +571,37 @@ func (s *Server) handleHostMetrics(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, metrics)
 }
 
+// handleEvents returns the GPU event log (?from=&to=&node=&gpu=&type=).
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	from, to := parseTimeRange(r)
+	q := storage.GPUEventsQuery{
+		NodeID: r.URL.Query().Get("node"),
+		Type:   r.URL.Query().Get("type"),
+		GPUID:  -1,
+		From:   from,
+		To:     to,
+	}
+	if v := r.URL.Query().Get("gpu"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			httpError(w, "invalid gpu id", http.StatusBadRequest)
+			return
+		}
+		q.GPUID = id
+	}
+
+	events, err := s.store.GetGPUEvents(q)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		writeJSON(w, []struct{}{})
+		return
+	}
+	writeJSON(w, events)
+}
+
 // --- Ingest endpoints (agent -> hub) ---
 
 func (s *Server) handleIngestRegister(w http.ResponseWriter, r *http.Request) {
@@ -334,35 +610,44 @@ func (s *Server) handleIngestRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var payload struct {
-		NodeID   string              `json:"node_id"`
-		Hostname string              `json:"hostname"`
-		Devices  []collector.GPUDevice `json:"devices"`
-	}
+	var payload collector.Registration
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if payload.NodeID == "" {
-		httpError(w, "node_id required", http.StatusBadRequest)
+	if code, err := authorizeNode(r, payload.NodeID); err != nil {
+		httpError(w, err.Error(), code)
 		return
 	}
 
-	// Register node
-	if err := s.store.RegisterNode(payload.NodeID, payload.Hostname, len(payload.Devices)); err != nil {
-		httpError(w, "register node: "+err.Error(), http.StatusInternalServerError)
+	if code, err := s.registerAgent(&payload); err != nil {
+		httpError(w, err.Error(), code)
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// registerAgent records an agent's node and GPU devices. On failure it
+// returns the HTTP status to report.
+func (s *Server) registerAgent(reg *collector.Registration) (int, error) {
+	if reg.NodeID == "" {
+		return http.StatusBadRequest, fmt.Errorf("node_id required")
+	}
 
-	// Register devices
-	if err := s.store.RegisterGPUDevices(payload.NodeID, payload.Devices); err != nil {
-		httpError(w, "register devices: "+err.Error(), http.StatusInternalServerError)
-		return
+	// Register devices first: the node's hash marks the inventory as known
+	if err := s.store.RegisterGPUDevices(reg.NodeID, reg.Devices); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("register devices: %w", err)
 	}
 
-	log.Printf("agent registered: node=%s gpus=%d", payload.NodeID, len(payload.Devices))
-	w.WriteHeader(http.StatusOK)
+	// Register node
+	hash := collector.DeviceHash(reg.Devices)
+	if err := s.store.RegisterNode(reg.NodeID, reg.Hostname, len(reg.Devices), hash, reg.Labels); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("register node: %w", err)
+	}
+	s.agents.setHash(reg.NodeID, hash)
+
+	log.Printf("agent registered: node=%s gpus=%d", reg.NodeID, len(reg.Devices))
+	return http.StatusOK, nil
 }
 
 func (s *Server) handleIngestGPUMetrics(w http.ResponseWriter, r *http.Request) {
@@ -376,17 +661,28 @@ func (s *Server) handleIngestGPUMetrics(w http.ResponseWriter, r *http.Request)
 		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if node, ok := ingestNode(r); ok {
+		for i := range metrics {
+			metrics[i].NodeID = node
+		}
+	}
+
+	if len(metrics) > 0 {
+		if code, err := s.checkNode(metrics[0].NodeID, ""); err != nil {
+			httpError(w, err.Error(), code)
+			return
+		}
+	}
 
 	if err := s.store.WriteGPUMetrics(metrics); err != nil {
 		httpError(w, "write gpu metrics: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Update node last_seen, check alerts, and broadcast to WebSocket clients
+	// Update node last_seen and broadcast to WebSocket clients
 	if len(metrics) > 0 {
 		nodeID := metrics[0].NodeID
 		s.store.UpdateNodeSeen(nodeID)
-		s.checkAlerts(metrics)
 
 		s.hub.Broadcast(collector.Snapshot{
 			Type:      "gpu_metrics",
@@ -410,6 +706,14 @@ func (s *Server) handleIngestHostMetrics(w http.ResponseWriter, r *http.Request)
 		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if node, ok := ingestNode(r); ok {
+		m.NodeID = node
+	}
+
+	if code, err := s.checkNode(m.NodeID, ""); err != nil {
+		httpError(w, err.Error(), code)
+		return
+	}
 
 	if err := s.store.WriteHostMetrics(&m); err != nil {
 		httpError(w, "write host metrics: "+err.Error(), http.StatusInternalServerError)
@@ -439,6 +743,18 @@ func (s *Server) handleIngestGPUProcesses(w http.ResponseWriter, r *http.Request
 		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if node, ok := ingestNode(r); ok {
+		for i := range procs {
+			procs[i].NodeID = node
+		}
+	}
+
+	if len(procs) > 0 {
+		if code, err := s.checkNode(procs[0].NodeID, ""); err != nil {
+			httpError(w, err.Error(), code)
+			return
+		}
+	}
 
 	if err := s.store.WriteGPUProcesses(procs); err != nil {
 		httpError(w, "write gpu processes: "+err.Error(), http.StatusInternalServerError)
@@ -460,18 +776,226 @@ func (s *Server) handleIngestGPUProcesses(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) handleIngestGPUEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var events []collector.GPUEvent
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if node, ok := ingestNode(r); ok {
+		for i := range events {
+			events[i].NodeID = node
+		}
+	}
+
+	if len(events) > 0 {
+		if code, err := s.checkNode(events[0].NodeID, ""); err != nil {
+			httpError(w, err.Error(), code)
+			return
+		}
+	}
+
+	if err := s.store.WriteGPUEvents(events); err != nil {
+		httpError(w, "write gpu events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(events) > 0 {
+		nodeID := events[0].NodeID
+		s.store.UpdateNodeSeen(nodeID)
+		for _, e := range events {
+			log.Printf("GPU event from %s: gpu=%d %s %s", e.NodeID, e.GPUID, e.Type, e.Description)
+		}
+
+		s.hub.Broadcast(collector.Snapshot{
+			Type:      "gpu_events",
+			NodeID:    nodeID,
+			Timestamp: time.Now().Unix(),
+			Events:    events,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleIngestNVLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var links []collector.NVLinkMetrics
+	if err := json.NewDecoder(r.Body).Decode(&links); err != nil {
+		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if node, ok := ingestNode(r); ok {
+		for i := range links {
+			links[i].NodeID = node
+		}
+	}
+
+	if len(links) > 0 {
+		if code, err := s.checkNode(links[0].NodeID, ""); err != nil {
+			httpError(w, err.Error(), code)
+			return
+		}
+	}
+
+	if err := s.store.WriteNVLinkMetrics(links); err != nil {
+		httpError(w, "write nvlink metrics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(links) > 0 {
+		nodeID := links[0].NodeID
+		s.store.UpdateNodeSeen(nodeID)
+
+		s.hub.Broadcast(collector.Snapshot{
+			Type:      "nvlink",
+			NodeID:    nodeID,
+			Timestamp: time.Now().Unix(),
+			NVLinks:   links,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// maxBatchBytes bounds the decompressed size of an ingest batch.
+const maxBatchBytes = 256 << 20
+
+// handleIngestBatch accepts a versioned envelope of snapshots of any type,
+// optionally gzip- or zstd-compressed, and stores it in one transaction.
+func (s *Server) handleIngestBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batch, code, err := decodeBatch(r.Body, r.Header.Get("Content-Encoding"))
+	if err == nil {
+		code, err = authorizeNode(r, batch.NodeID)
+	}
+	if err == nil {
+		code, err = s.ingestBatch(batch)
+	}
+	if err != nil {
+		httpError(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// decodeBatch reads an IngestBatch with the given Content-Encoding. On
+// failure it returns the HTTP status to report.
+func decodeBatch(r io.Reader, encoding string) (*collector.IngestBatch, int, error) {
+	switch encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxBatchBytes))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("bad request: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding")
+	}
+
+	var batch collector.IngestBatch
+	if err := json.NewDecoder(io.LimitReader(r, maxBatchBytes)).Decode(&batch); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("bad request: %w", err)
+	}
+	if batch.Version != collector.IngestBatchVersion {
+		return nil, http.StatusBadRequest, fmt.Errorf("unsupported batch version %d", batch.Version)
+	}
+	if batch.NodeID == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("node_id required")
+	}
+	return &batch, http.StatusOK, nil
+}
+
+// ingestBatch stores a batch in one transaction and publishes its snapshots.
+// On failure it returns the HTTP status to report.
+func (s *Server) ingestBatch(batch *collector.IngestBatch) (int, error) {
+	if code, err := s.checkNode(batch.NodeID, batch.DeviceHash); err != nil {
+		return code, err
+	}
+
+	// The envelope is authoritative for the node of every sample
+	for i := range batch.Snapshots {
+		switch batch.Snapshots[i].Type {
+		case "gpu_metrics", "gpu_processes", "gpu_events", "nvlink", "host_metrics":
+		default:
+			return http.StatusBadRequest, fmt.Errorf("unknown snapshot type %q", batch.Snapshots[i].Type)
+		}
+		setSnapshotNode(&batch.Snapshots[i], batch.NodeID)
+	}
+
+	if err := s.store.WriteBatch(batch.Snapshots); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("write batch: %w", err)
+	}
+
+	if len(batch.Snapshots) > 0 {
+		s.store.UpdateNodeSeen(batch.NodeID)
+	}
+	for _, snap := range batch.Snapshots {
+		if snap.Type == "gpu_events" {
+			for _, e := range snap.Events {
+				log.Printf("GPU event from %s: gpu=%d %s %s", e.NodeID, e.GPUID, e.Type, e.Description)
+			}
+		}
+		s.hub.Broadcast(snap)
+	}
+	return http.StatusOK, nil
+}
+
+// setSnapshotNode sets the node of a snapshot and all samples in it.
+func setSnapshotNode(snap *collector.Snapshot, nodeID string) {
+	snap.NodeID = nodeID
+	for i := range snap.GPUs {
+		snap.GPUs[i].NodeID = nodeID
+	}
+	for i := range snap.Processes {
+		snap.Processes[i].NodeID = nodeID
+	}
+	for i := range snap.Events {
+		snap.Events[i].NodeID = nodeID
+	}
+	for i := range snap.NVLinks {
+		snap.NVLinks[i].NodeID = nodeID
+	}
+	if snap.Host != nil {
+		snap.Host.NodeID = nodeID
+	}
+}
+
 // --- Prometheus ---
 
 func (s *Server) handlePrometheus(w http.ResponseWriter, r *http.Request) {
 	gpus, _ := s.store.GetLatestGPUMetrics()
 	devices, _ := s.store.GetGPUDevices("")
 	hosts, _ := s.store.GetLatestHostMetrics()
+	procs, _ := s.store.GetAllGPUProcesses()
 
-	// Build device name lookup
-	nameMap := make(map[string]string)
+	// Build device lookup
+	deviceMap := make(map[string]collector.GPUDevice)
 	for _, d := range devices {
 		key := fmt.Sprintf("%s:%d", d.NodeID, d.ID)
-		nameMap[key] = d.Name
+		deviceMap[key] = d
 	}
 
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
@@ -482,11 +1006,21 @@ func (s *Server) handlePrometheus(w http.ResponseWriter, r *http.Request) {
 			node = "local"
 		}
 		id := strconv.Itoa(g.GPUID)
-		name := nameMap[fmt.Sprintf("%s:%d", node, g.GPUID)]
-		labels := fmt.Sprintf(`node_id="%s",gpu_id="%s",gpu_name="%s"`, node, id, name)
+		dev := deviceMap[fmt.Sprintf("%s:%d", node, g.GPUID)]
+		labels := fmt.Sprintf(`node_id="%s",gpu_id="%s",gpu_name="%s",uuid="%s"`, node, id, dev.Name, dev.UUID)
+
+		// NVML only reports memory for MIG instances; the rest is per physical GPU
+		if dev.IsMIG() {
+			labels += fmt.Sprintf(`,parent_gpu_id="%d",parent_uuid="%s",mig_profile="%s"`, dev.ParentID, dev.ParentUUID, dev.MIGProfile)
+			fmt.Fprintf(w, "cudascope_gpu_memory_used_mib{%s} %d\n", labels, g.MemUsed)
+			fmt.Fprintf(w, "cudascope_gpu_memory_total_mib{%s} %d\n", labels, dev.MemTotal)
+			continue
+		}
 
 		fmt.Fprintf(w, "cudascope_gpu_utilization_percent{%s} %.1f\n", labels, g.GPUUtil)
 		fmt.Fprintf(w, "cudascope_gpu_memory_used_mib{%s} %d\n", labels, g.MemUsed)
+		fmt.Fprintf(w, "cudascope_gpu_memory_total_mib{%s} %d\n", labels, dev.MemTotal)
+		fmt.Fprintf(w, "cudascope_gpu_mig_enabled{%s} %d\n", labels, boolToInt(dev.MIGEnabled))
 		fmt.Fprintf(w, "cudascope_gpu_memory_util_percent{%s} %.1f\n", labels, g.MemUtil)
 		fmt.Fprintf(w, "cudascope_gpu_temperature_celsius{%s} %d\n", labels, g.Temperature)
 		fmt.Fprintf(w, "cudascope_gpu_fan_speed_percent{%s} %d\n", labels, g.FanSpeed)
@@ -499,6 +1033,37 @@ func (s *Server) handlePrometheus(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "cudascope_gpu_pstate{%s} %d\n", labels, g.PState)
 		fmt.Fprintf(w, "cudascope_gpu_encoder_util_percent{%s} %.1f\n", labels, g.EncoderUtil)
 		fmt.Fprintf(w, "cudascope_gpu_decoder_util_percent{%s} %.1f\n", labels, g.DecoderUtil)
+		fmt.Fprintf(w, "cudascope_gpu_clock_graphics_max_mhz{%s} %d\n", labels, dev.ClockGfxMax)
+		fmt.Fprintf(w, "cudascope_gpu_clock_memory_max_mhz{%s} %d\n", labels, dev.ClockMemMax)
+		fmt.Fprintf(w, "cudascope_gpu_clock_graphics_app_mhz{%s} %d\n", labels, g.ClockGfxApp)
+		fmt.Fprintf(w, "cudascope_gpu_clock_memory_app_mhz{%s} %d\n", labels, g.ClockMemApp)
+		fmt.Fprintf(w, "cudascope_gpu_temperature_slowdown_celsius{%s} %d\n", labels, dev.TempSlowdown)
+		fmt.Fprintf(w, "cudascope_gpu_temperature_shutdown_celsius{%s} %d\n", labels, dev.TempShutdown)
+		fmt.Fprintf(w, "cudascope_gpu_throttle_reasons{%s} %d\n", labels, g.ThrottleReasons)
+		for _, reason := range collector.ThrottleReasons {
+			fmt.Fprintf(w, "cudascope_gpu_throttle_active{%s,reason=\"%s\"} %d\n", labels, reason.Name, boolToInt(g.ThrottleReasons&reason.Bit != 0))
+		}
+		fmt.Fprintf(w, "cudascope_gpu_ecc_enabled{%s} %d\n", labels, boolToInt(g.ECCEnabled))
+		fmt.Fprintf(w, "cudascope_gpu_ecc_errors{%s,error_type=\"corrected\",counter=\"volatile\"} %d\n", labels, g.ECCCorrVolatile)
+		fmt.Fprintf(w, "cudascope_gpu_ecc_errors{%s,error_type=\"uncorrected\",counter=\"volatile\"} %d\n", labels, g.ECCUncorrVolatile)
+		fmt.Fprintf(w, "cudascope_gpu_ecc_errors{%s,error_type=\"corrected\",counter=\"aggregate\"} %d\n", labels, g.ECCCorrAggregate)
+		fmt.Fprintf(w, "cudascope_gpu_ecc_errors{%s,error_type=\"uncorrected\",counter=\"aggregate\"} %d\n", labels, g.ECCUncorrAggregate)
+		fmt.Fprintf(w, "cudascope_gpu_retired_pages{%s,cause=\"sbe\"} %d\n", labels, g.RetiredPagesSBE)
+		fmt.Fprintf(w, "cudascope_gpu_retired_pages{%s,cause=\"dbe\"} %d\n", labels, g.RetiredPagesDBE)
+		fmt.Fprintf(w, "cudascope_gpu_retired_pages_pending{%s} %d\n", labels, boolToInt(g.RetiredPending))
+		fmt.Fprintf(w, "cudascope_gpu_remapped_rows{%s,cause=\"correctable\"} %d\n", labels, g.RemappedCorr)
+		fmt.Fprintf(w, "cudascope_gpu_remapped_rows{%s,cause=\"uncorrectable\"} %d\n", labels, g.RemappedUncorr)
+		fmt.Fprintf(w, "cudascope_gpu_remap_pending{%s} %d\n", labels, boolToInt(g.RemapPending))
+		fmt.Fprintf(w, "cudascope_gpu_remap_failed{%s} %d\n", labels, boolToInt(g.RemapFailed))
+	}
+
+	for _, p := range procs {
+		labels := fmt.Sprintf(`node_id="%s",gpu_id="%d",pid="%d",name="%s",user="%s",container="%s",namespace="%s",pod="%s"`,
+			p.NodeID, p.GPUID, p.PID, promLabel(p.Name), promLabel(p.User),
+			promLabel(p.ContainerName), promLabel(p.Namespace), promLabel(p.Pod))
+		fmt.Fprintf(w, "cudascope_process_gpu_memory_used_mib{%s} %d\n", labels, p.GPUMem)
+		fmt.Fprintf(w, "cudascope_process_sm_util_percent{%s} %.1f\n", labels, p.SMUtil)
+		fmt.Fprintf(w, "cudascope_process_mem_util_percent{%s} %.1f\n", labels, p.MemUtil)
 	}
 
 	for _, h := range hosts {
@@ -518,51 +1083,37 @@ func (s *Server) handlePrometheus(w http.ResponseWriter, r *http.Request) {
 
 // --- Alerts ---
 
+// handleAlerts returns the alert rules and the current firing and pending alerts.
 func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
-	s.alertsMu.RLock()
-	alerts := s.activeAlerts
-	s.alertsMu.RUnlock()
-
-	resp := map[string]any{
-		"config": map[string]int{
-			"temp_max": s.alerts.TempMax,
-			"gpu_util": s.alerts.GPUUtil,
-			"mem_util": s.alerts.MemUtil,
-		},
-		"alerts": alerts,
-	}
-	if alerts == nil {
-		resp["alerts"] = []struct{}{}
-	}
-	writeJSON(w, resp)
+	writeJSON(w, map[string]any{
+		"rules":   s.alerts.Rules(),
+		"alerts":  s.alerts.Alerts(storage.AlertFiring),
+		"pending": s.alerts.Alerts(storage.AlertPending),
+	})
 }
 
-// checkAlerts evaluates current GPU metrics against thresholds.
-func (s *Server) checkAlerts(gpus []collector.GPUMetrics) {
-	if s.alerts.TempMax == 0 && s.alerts.GPUUtil == 0 && s.alerts.MemUtil == 0 {
+// handleAlertHistory returns the alerts active in a time range, newest
+// first, optionally filtered by node, rule, severity and state.
+func (s *Server) handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	from, to := parseTimeRange(r)
+	q := r.URL.Query()
+	alerts, err := s.store.GetAlertHistory(storage.AlertsQuery{
+		From:     from,
+		To:       to,
+		NodeID:   q.Get("node"),
+		Rule:     q.Get("rule"),
+		Severity: q.Get("severity"),
+		State:    q.Get("state"),
+	})
+	if err != nil {
+		httpError(w, "get alert history: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	var alerts []Alert
-	for _, g := range gpus {
-		node := g.NodeID
-		if node == "" {
-			node = "local"
-		}
-		if s.alerts.TempMax > 0 && g.Temperature >= s.alerts.TempMax {
-			alerts = append(alerts, Alert{NodeID: node, GPUID: g.GPUID, Metric: "temperature", Value: float64(g.Temperature), Thresh: float64(s.alerts.TempMax)})
-		}
-		if s.alerts.GPUUtil > 0 && g.GPUUtil >= float64(s.alerts.GPUUtil) {
-			alerts = append(alerts, Alert{NodeID: node, GPUID: g.GPUID, Metric: "gpu_util", Value: g.GPUUtil, Thresh: float64(s.alerts.GPUUtil)})
-		}
-		if s.alerts.MemUtil > 0 && g.MemUtil >= float64(s.alerts.MemUtil) {
-			alerts = append(alerts, Alert{NodeID: node, GPUID: g.GPUID, Metric: "mem_util", Value: g.MemUtil, Thresh: float64(s.alerts.MemUtil)})
-		}
+	if alerts == nil {
+		writeJSON(w, []struct{}{})
+		return
 	}
-
-	s.alertsMu.Lock()
-	s.activeAlerts = alerts
-	s.alertsMu.Unlock()
+	writeJSON(w, alerts)
 }
 
 // --- Helpers ---
@@ -614,6 +1165,16 @@ func filterHostByNode(metrics []collector.HostMetrics, nodeID string) []collecto
 	return filtered
 }
 
+func filterAlertsByNode(alerts []storage.Alert, nodeID string) []storage.Alert {
+	filtered := []storage.Alert{}
+	for _, a := range alerts {
+		if a.NodeID == nodeID {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
 func filterProcByNode(procs []collector.GPUProcess, nodeID string) []collector.GPUProcess {
 	var filtered []collector.GPUProcess
 	for _, p := range procs {
@@ -624,6 +1185,38 @@ func filterProcByNode(procs []collector.GPUProcess, nodeID string) []collector.G
 	return filtered
 }
 
+// filterProcByOwner keeps processes in the given namespace and container
+// (matched by name or ID prefix). Empty filters match everything.
+func filterProcByOwner(procs []collector.GPUProcess, namespace, container string) []collector.GPUProcess {
+	if namespace == "" && container == "" {
+		return procs
+	}
+	var filtered []collector.GPUProcess
+	for _, p := range procs {
+		if namespace != "" && p.Namespace != namespace {
+			continue
+		}
+		if container != "" && p.ContainerName != container &&
+			(p.ContainerID == "" || !strings.HasPrefix(p.ContainerID, container)) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered
+}
+
+// promLabel escapes a Prometheus label value.
+func promLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(v); err != nil {
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sergey/cudascope/internal/alert"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// handleSilences lists silences (GET, ?expired=true to include ended ones)
+// or creates one (POST). Silences from the rules file are listed with ID 0.
+func (s *Server) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		now := time.Now().Unix()
+		expired := r.URL.Query().Get("expired") == "true"
+		silences, err := s.store.GetSilences(now, expired)
+		if err != nil {
+			httpError(w, "get silences: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, sil := range s.alerts.FileSilences() {
+			if expired || sil.EndsAt == 0 || sil.EndsAt > now {
+				silences = append(silences, sil)
+			}
+		}
+		if silences == nil {
+			writeJSON(w, []struct{}{})
+			return
+		}
+		writeJSON(w, silences)
+
+	case "POST":
+		var req struct {
+			storage.Silence
+			Duration string `json:"duration"` // alternative to ends_at, e.g. "2h"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sil := req.Silence
+		if err := alert.ValidateSilence(&sil); err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().Unix()
+		if sil.StartsAt == 0 {
+			sil.StartsAt = now
+		}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				httpError(w, "bad duration", http.StatusBadRequest)
+				return
+			}
+			sil.EndsAt = sil.StartsAt + int64(d.Seconds())
+		}
+		if sil.EndsAt <= sil.StartsAt || sil.EndsAt <= now {
+			httpError(w, "ends_at (or duration) must be in the future and after starts_at", http.StatusBadRequest)
+			return
+		}
+		p, _ := r.Context().Value(principalKey{}).(principal)
+		sil.ID = 0
+		sil.CreatedBy = p.User
+		sil.CreatedAt = now
+
+		if err := s.store.CreateSilence(&sil); err != nil {
+			httpError(w, "create silence: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, sil)
+
+	default:
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSilence removes a silence: DELETE /api/v1/silences/{id}.
+func (s *Server) handleSilence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/v1/silences/"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid silence id", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.store.DeleteSilence(id)
+	if err != nil {
+		httpError(w, "delete silence: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "silence not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleNodeMaintenance turns maintenance mode of a node on or off:
+// POST /api/v1/nodes/{node}/maintenance {"maintenance": true}.
+func (s *Server) handleNodeMaintenance(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if r.Method != "POST" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Maintenance bool `json:"maintenance"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.store.SetNodeMaintenance(nodeID, req.Maintenance)
+	if err != nil {
+		httpError(w, "set maintenance: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "node not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
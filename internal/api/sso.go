@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sergey/cudascope/internal/oidc"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// SSOConfig configures OpenID Connect login for the web UI. Logged-in
+// users get a signed session cookie, which authenticates the SPA, REST and
+// WebSocket routes like a token of the role their groups map to.
+type SSOConfig struct {
+	OIDC          oidc.Config
+	GroupsClaim   string       // ID token claim listing the user's groups (default "groups")
+	AdminGroups   []string     // members get the admin role
+	ViewerGroups  []string     // members get the viewer role; empty = every user the provider authenticates
+	SessionSecret string       // signs cookies; empty = random, so sessions end when the server restarts
+	HTTPClient    *http.Client // talks to the provider (nil = default client)
+}
+
+func (c SSOConfig) enabled() bool {
+	return c.OIDC.Issuer != ""
+}
+
+const (
+	sessionCookie = "cudascope_session"
+	loginCookie   = "cudascope_login"
+	sessionTTL    = 12 * time.Hour
+	loginTTL      = 10 * time.Minute
+)
+
+// session is the content of the session cookie.
+type session struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+	Exp  int64  `json:"exp"`
+}
+
+// loginState is the content of the cookie that carries a login attempt from
+// /auth/login to /auth/callback.
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+	Exp      int64  `json:"exp"`
+}
+
+// provider returns the OpenID provider, discovering it on first use so the
+// server starts even while the provider is unreachable.
+func (s *Server) provider(ctx context.Context) (*oidc.Provider, error) {
+	s.ssoMu.Lock()
+	defer s.ssoMu.Unlock()
+	if s.oidc == nil {
+		p, err := oidc.Discover(ctx, s.sso.OIDC, s.sso.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		s.oidc = p
+	}
+	return s.oidc, nil
+}
+
+// handleLogin starts the authorization code flow with PKCE.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	p, err := s.provider(r.Context())
+	if err != nil {
+		log.Printf("sso: %v", err)
+		httpError(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	verifier, challenge := oidc.NewPKCE()
+	st := loginState{
+		State:    oidc.RandomString(16),
+		Nonce:    oidc.RandomString(16),
+		Verifier: verifier,
+		Next:     safeRedirect(r.URL.Query().Get("next")),
+		Exp:      time.Now().Add(loginTTL).Unix(),
+	}
+	s.setCookie(w, loginCookie, s.sign(st), loginTTL)
+	http.Redirect(w, r, p.AuthCodeURL(st.State, st.Nonce, challenge), http.StatusFound)
+}
+
+// handleCallback completes a login: it redeems the code, verifies the ID
+// token and maps the user's groups to a role for the session.
+func (s *Server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		httpError(w, "login failed: "+e+" "+q.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	var st loginState
+	c, err := r.Cookie(loginCookie)
+	if err != nil || !s.verify(c.Value, &st) || st.Exp < time.Now().Unix() {
+		httpError(w, "login expired, try again", http.StatusBadRequest)
+		return
+	}
+	s.setCookie(w, loginCookie, "", -1)
+	if q.Get("state") != st.State {
+		httpError(w, "login state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	p, err := s.provider(r.Context())
+	if err != nil {
+		log.Printf("sso: %v", err)
+		httpError(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	raw, err := p.Exchange(r.Context(), q.Get("code"), st.Verifier)
+	if err != nil {
+		log.Printf("sso: %v", err)
+		httpError(w, "login failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := p.Verify(r.Context(), raw, st.Nonce)
+	if err != nil {
+		log.Printf("sso: %v", err)
+		httpError(w, "login failed: invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	user := claimUser(claims)
+	role, ok := s.ssoRole(claims)
+	if !ok {
+		log.Printf("sso: %s is not in an allowed group", user)
+		httpError(w, "not in an allowed group", http.StatusForbidden)
+		return
+	}
+	log.Printf("sso: %s logged in as %s", user, role)
+	s.setCookie(w, sessionCookie, s.sign(session{User: user, Role: role, Exp: time.Now().Add(sessionTTL).Unix()}), sessionTTL)
+	http.Redirect(w, r, st.Next, http.StatusFound)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	s.setCookie(w, sessionCookie, "", -1)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// sessionUser returns the user of a valid session cookie.
+func (s *Server) sessionUser(r *http.Request) (session, bool) {
+	var sess session
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || !s.verify(c.Value, &sess) || sess.Exp < time.Now().Unix() {
+		return session{}, false
+	}
+	return sess, true
+}
+
+// ssoRole maps the groups in an ID token to a role.
+func (s *Server) ssoRole(claims map[string]any) (string, bool) {
+	claim := s.sso.GroupsClaim
+	if claim == "" {
+		claim = "groups"
+	}
+	var groups []string
+	switch g := claims[claim].(type) {
+	case []any:
+		for _, v := range g {
+			if name, ok := v.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	case string:
+		groups = strings.Fields(strings.ReplaceAll(g, ",", " "))
+	}
+
+	switch {
+	case intersects(groups, s.sso.AdminGroups):
+		return storage.RoleAdmin, true
+	case len(s.sso.ViewerGroups) == 0 || intersects(groups, s.sso.ViewerGroups):
+		return storage.RoleViewer, true
+	}
+	return "", false
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimUser picks a display name for the user from the ID token.
+func claimUser(claims map[string]any) string {
+	for _, c := range []string{"preferred_username", "email", "name", "sub"} {
+		if v, ok := claims[c].(string); ok && v != "" {
+			return v
+		}
+	}
+	return "unknown"
+}
+
+// safeRedirect only allows redirects to paths on this server.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.sso.OIDC.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl < 0 {
+		c.MaxAge = -1
+	} else {
+		c.MaxAge = int(ttl.Seconds())
+	}
+	http.SetCookie(w, c)
+}
+
+// sign encodes v as base64url JSON followed by its HMAC.
+func (s *Server) sign(v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.mac(payload)
+}
+
+// verify decodes a value produced by sign, reporting whether it is authentic.
+func (s *Server) verify(signed string, v any) bool {
+	payload, mac, ok := strings.Cut(signed, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(payload))) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+func (s *Server) mac(payload string) string {
+	m := hmac.New(sha256.New, s.ssoKey)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// loginRedirect sends a browser without a session to the login page.
+func loginRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+}
+
+// handleMe returns who the request is authenticated as.
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	p, _ := r.Context().Value(principalKey{}).(principal)
+	writeJSON(w, struct {
+		principal
+		SSO bool `json:"sso"` // whether /auth/login and /auth/logout are available
+	}{p, s.sso.enabled()})
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sergey/cudascope/internal/oidc/oidctest"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// newSSOServer starts a server that logs users in at the stand-in provider
+// op, and returns its URL and a client with a cookie jar that does not
+// follow redirects.
+func newSSOServer(t *testing.T, op *oidctest.Provider, sso SSOConfig) (string, *http.Client) {
+	t.Helper()
+	db, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var handler http.Handler
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handler.ServeHTTP(w, r) }))
+	t.Cleanup(ts.Close)
+
+	sso.OIDC.Issuer = op.Issuer
+	sso.OIDC.ClientID = op.ClientID
+	sso.OIDC.ClientSecret = op.ClientSecret
+	sso.OIDC.RedirectURL = ts.URL + "/auth/callback"
+	s := NewServer(db, NewHub(), nil, false, "", "", nil, nil, storage.WasteConfig{}, IngestAuth{}, sso)
+	handler = s.middleware(s.mux)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	return ts.URL, client
+}
+
+// get requests a URL without following redirects, returning the status and
+// the redirect target.
+func get(t *testing.T, client *http.Client, u string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatalf("GET %s: %v", u, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Location")
+}
+
+// startLogin follows /auth/login to the provider and returns the callback
+// URL the provider redirects back to.
+func startLogin(t *testing.T, base string, client *http.Client) *url.URL {
+	t.Helper()
+	code, loc := get(t, client, base+"/auth/login?next=/api/v1/auth/me")
+	if code != http.StatusFound {
+		t.Fatalf("/auth/login: status %d", code)
+	}
+	code, loc = get(t, client, loc)
+	if code != http.StatusFound {
+		t.Fatalf("authorize: status %d", code)
+	}
+	callback, err := url.Parse(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback
+}
+
+// me returns who the client is logged in as.
+func me(t *testing.T, base string, client *http.Client) (principal, int) {
+	t.Helper()
+	resp, err := client.Get(base + "/api/v1/auth/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var p principal
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p, resp.StatusCode
+}
+
+func TestSSOLogin(t *testing.T) {
+	op := oidctest.NewProvider("cudascope", "secret")
+	defer op.Close()
+	op.SetClaims(map[string]any{"groups": []string{"gpu-admins"}})
+	base, client := newSSOServer(t, op, SSOConfig{AdminGroups: []string{"gpu-admins"}})
+
+	if _, code := me(t, base, client); code != http.StatusUnauthorized {
+		t.Fatalf("before login: status %d, want 401", code)
+	}
+	if code, loc := get(t, client, base+"/nodes"); code != http.StatusFound || loc != "/auth/login?next=%2Fnodes" {
+		t.Errorf("browser without a session: status %d to %q, want a redirect to the login", code, loc)
+	}
+
+	code, loc := get(t, client, startLogin(t, base, client).String())
+	if code != http.StatusFound || loc != "/api/v1/auth/me" {
+		t.Fatalf("callback: status %d to %q, want a redirect to next", code, loc)
+	}
+	p, code := me(t, base, client)
+	if code != http.StatusOK || p != (principal{User: "alice", Role: storage.RoleAdmin, Method: "sso"}) {
+		t.Errorf("after login: status %d, %+v", code, p)
+	}
+
+	get(t, client, base+"/auth/logout")
+	if _, code := me(t, base, client); code != http.StatusUnauthorized {
+		t.Errorf("after logout: status %d, want 401", code)
+	}
+}
+
+func TestSSOCallbackRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		modify func(q url.Values)
+		want   int
+	}{
+		{"bad state", nil, func(q url.Values) { q.Set("state", "forged") }, http.StatusBadRequest},
+		{"bad code", nil, func(q url.Values) { q.Set("code", "forged") }, http.StatusBadGateway},
+		{"provider error", nil, func(q url.Values) { q.Set("error", "access_denied") }, http.StatusUnauthorized},
+		{"bad nonce", map[string]any{"nonce": "replayed"}, nil, http.StatusUnauthorized},
+		{"expired ID token", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}, nil, http.StatusUnauthorized},
+		{"ID token for another client", map[string]any{"aud": "grafana"}, nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := oidctest.NewProvider("cudascope", "secret")
+			defer op.Close()
+			op.SetClaims(tt.claims)
+			base, client := newSSOServer(t, op, SSOConfig{})
+
+			callback := startLogin(t, base, client)
+			if tt.modify != nil {
+				q := callback.Query()
+				tt.modify(q)
+				callback.RawQuery = q.Encode()
+			}
+			if code, _ := get(t, client, callback.String()); code != tt.want {
+				t.Errorf("callback: status %d, want %d", code, tt.want)
+			}
+			if _, code := me(t, base, client); code != http.StatusUnauthorized {
+				t.Errorf("after a failed login: status %d, want 401", code)
+			}
+		})
+	}
+}
+
+func TestSSOCallbackWithoutLogin(t *testing.T) {
+	op := oidctest.NewProvider("cudascope", "secret")
+	defer op.Close()
+	base, client := newSSOServer(t, op, SSOConfig{})
+
+	// A callback for a login this browser did not start
+	callback := startLogin(t, base, client)
+	jar, _ := cookiejar.New(nil)
+	client.Jar = jar
+	if code, _ := get(t, client, callback.String()); code != http.StatusBadRequest {
+		t.Errorf("callback: status %d, want 400", code)
+	}
+}
+
+func TestSSORole(t *testing.T) {
+	tests := []struct {
+		name   string
+		sso    SSOConfig
+		claims map[string]any
+		role   string // empty = refused
+	}{
+		{"admin group", SSOConfig{AdminGroups: []string{"gpu-admins"}, ViewerGroups: []string{"ml"}},
+			map[string]any{"groups": []any{"ml", "gpu-admins"}}, storage.RoleAdmin},
+		{"viewer group", SSOConfig{AdminGroups: []string{"gpu-admins"}, ViewerGroups: []string{"ml", "infra"}},
+			map[string]any{"groups": []any{"infra"}}, storage.RoleViewer},
+		{"in no group", SSOConfig{AdminGroups: []string{"gpu-admins"}, ViewerGroups: []string{"ml"}},
+			map[string]any{"groups": []any{"finance"}}, ""},
+		{"no groups claim", SSOConfig{ViewerGroups: []string{"ml"}}, map[string]any{}, ""},
+		{"everyone is a viewer without viewer groups", SSOConfig{AdminGroups: []string{"gpu-admins"}},
+			map[string]any{}, storage.RoleViewer},
+		{"custom claim", SSOConfig{GroupsClaim: "roles", AdminGroups: []string{"admin"}},
+			map[string]any{"groups": []any{"ml"}, "roles": []any{"admin"}}, storage.RoleAdmin},
+		{"comma-separated string claim", SSOConfig{AdminGroups: []string{"gpu-admins"}, ViewerGroups: []string{"ml"}},
+			map[string]any{"groups": "ml, gpu-admins"}, storage.RoleAdmin},
+		{"non-string groups are ignored", SSOConfig{ViewerGroups: []string{"7"}},
+			map[string]any{"groups": []any{7.0}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{sso: tt.sso}
+			role, ok := s.ssoRole(tt.claims)
+			if role != tt.role || ok != (tt.role != "") {
+				t.Errorf("ssoRole = %q, %t; want %q", role, ok, tt.role)
+			}
+		})
+	}
+}
+
+func TestSSOLoginRole(t *testing.T) {
+	tests := []struct {
+		groups []string
+		want   int
+		role   string
+	}{
+		{[]string{"gpu-admins"}, http.StatusFound, storage.RoleAdmin},
+		{[]string{"ml"}, http.StatusFound, storage.RoleViewer},
+		{[]string{"finance"}, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		op := oidctest.NewProvider("cudascope", "secret")
+		defer op.Close()
+		op.SetClaims(map[string]any{"groups": tt.groups})
+		base, client := newSSOServer(t, op, SSOConfig{AdminGroups: []string{"gpu-admins"}, ViewerGroups: []string{"ml"}})
+
+		if code, _ := get(t, client, startLogin(t, base, client).String()); code != tt.want {
+			t.Errorf("groups %v: callback status %d, want %d", tt.groups, code, tt.want)
+			continue
+		}
+		if p, _ := me(t, base, client); p.Role != tt.role {
+			t.Errorf("groups %v: role %q, want %q", tt.groups, p.Role, tt.role)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// credential returns the API token presented with a request: a bearer
+// token, an access_token query parameter (for WebSocket clients that cannot
+// set headers) or the password of basic auth (for browsers and scrapers
+// that only speak basic auth).
+func credential(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if t := r.URL.Query().Get("access_token"); t != "" {
+		return t
+	}
+	if _, pass, ok := r.BasicAuth(); ok {
+		return pass
+	}
+	return ""
+}
+
+// principal is who an API request is authenticated as.
+type principal struct {
+	User   string `json:"user"`
+	Role   string `json:"role"`
+	Method string `json:"method"` // "none", "basic", "token" or "sso"
+}
+
+type principalKey struct{}
+
+// authenticateUser resolves who makes a (non-ingest) API request. Auth is
+// enforced once basic auth or SSO is configured or any token exists; until
+// then every request is admin.
+func (s *Server) authenticateUser(r *http.Request) (principal, int, error) {
+	hasTokens, err := s.store.HasTokens()
+	if err != nil {
+		return principal{}, http.StatusInternalServerError, fmt.Errorf("check tokens: %w", err)
+	}
+	if s.authUser == "" && !hasTokens && !s.sso.enabled() {
+		return principal{Role: storage.RoleAdmin, Method: "none"}, http.StatusOK, nil
+	}
+
+	if s.sso.enabled() {
+		if sess, ok := s.sessionUser(r); ok {
+			return principal{User: sess.User, Role: sess.Role, Method: "sso"}, http.StatusOK, nil
+		}
+	}
+	if user, pass, ok := r.BasicAuth(); ok && s.authUser != "" &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.authUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.authPass)) == 1 {
+		return principal{User: user, Role: storage.RoleAdmin, Method: "basic"}, http.StatusOK, nil
+	}
+	if secret := credential(r); secret != "" && hasTokens {
+		t, err := s.store.LookupToken(secret)
+		if err != nil {
+			return principal{}, http.StatusInternalServerError, fmt.Errorf("look up token: %w", err)
+		}
+		if t != nil {
+			return principal{User: t.Name, Role: t.Role, Method: "token"}, http.StatusOK, nil
+		}
+	}
+	return principal{}, http.StatusUnauthorized, fmt.Errorf("unauthorized")
+}
+
+// authorizeUser checks that a role may make a request: viewers only read,
+// token management is for admins, and agent tokens are for ingest only.
+func authorizeUser(role string, r *http.Request) (int, error) {
+	switch role {
+	case storage.RoleAdmin:
+		return http.StatusOK, nil
+	case storage.RoleViewer:
+		if strings.HasPrefix(r.URL.Path, "/api/v1/tokens") {
+			return http.StatusForbidden, fmt.Errorf("token management requires the admin role")
+		}
+		// Validating rules changes nothing, so CI can use a viewer token
+		if r.Method != "GET" && r.Method != "HEAD" && r.URL.Path != "/api/v1/rules/validate" {
+			return http.StatusForbidden, fmt.Errorf("viewer tokens are read-only")
+		}
+		return http.StatusOK, nil
+	default:
+		return http.StatusForbidden, fmt.Errorf("%s tokens may only be used for ingest", role)
+	}
+}
+
+// handleTokens lists tokens (GET) or creates one (POST). The secret of a new
+// token is returned once, in the response to its creation.
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		tokens, err := s.store.GetTokens()
+		if err != nil {
+			httpError(w, "get tokens: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tokens == nil {
+			writeJSON(w, []struct{}{})
+			return
+		}
+		writeJSON(w, tokens)
+
+	case "POST":
+		var req struct {
+			Name      string `json:"name"`
+			Role      string `json:"role"`
+			NodeID    string `json:"node_id"`
+			ExpiresIn string `json:"expires_in"` // Go duration, e.g. "720h"; empty = never
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			httpError(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if !storage.ValidRole(req.Role) {
+			httpError(w, "role must be viewer, admin or agent", http.StatusBadRequest)
+			return
+		}
+		if (req.NodeID != "") != (req.Role == storage.RoleAgent) {
+			httpError(w, "agent tokens require node_id, and node_id only applies to agent tokens", http.StatusBadRequest)
+			return
+		}
+		var expiresAt int64
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				httpError(w, "bad expires_in", http.StatusBadRequest)
+				return
+			}
+			expiresAt = time.Now().Add(d).Unix()
+		}
+
+		secret, t, err := s.store.CreateToken(req.Name, req.Role, req.NodeID, expiresAt)
+		if err != nil {
+			httpError(w, "create token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, struct {
+			Secret string `json:"token"`
+			*storage.Token
+		}{secret, t})
+
+	default:
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleToken revokes a token: DELETE /api/v1/tokens/{id}.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/v1/tokens/"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.store.RevokeToken(id)
+	if err != nil {
+		httpError(w, "revoke token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "token not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
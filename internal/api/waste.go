@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// handleWaste lists the GPUs that have held memory while idle for at least
+// the configured duration (?min_idle= overrides it, ?node= filters), with
+// the processes holding the memory and the cluster's idle GPU-hours over
+// the last day and week.
+func (s *Server) handleWaste(w http.ResponseWriter, r *http.Request) {
+	cfg := s.waste
+	if cfg.MaxUtil <= 0 {
+		httpError(w, "waste report disabled: invalid --waste-* flags", http.StatusServiceUnavailable)
+		return
+	}
+	if v := r.URL.Query().Get("min_idle"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			httpError(w, "invalid min_idle", http.StatusBadRequest)
+			return
+		}
+		cfg.MinIdle = d
+	}
+
+	gpus, err := s.store.GetIdleGPUs(cfg, r.URL.Query().Get("node"))
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if gpus == nil {
+		gpus = []storage.IdleGPU{}
+	}
+
+	idle := make(map[string]float64)
+	allocated := make(map[string]float64)
+	now := time.Now().Unix()
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{{"24h", 24 * time.Hour}, {"7d", 7 * 24 * time.Hour}} {
+		i, a, err := s.store.GetIdleGPUHours(cfg, now-int64(p.d.Seconds()), now)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		idle[p.name], allocated[p.name] = i, a
+	}
+
+	writeJSON(w, map[string]any{
+		"gpus":                gpus,
+		"idle_gpu_hours":      idle,
+		"allocated_gpu_hours": allocated,
+		"config": map[string]any{
+			"max_util":         cfg.MaxUtil,
+			"min_mem":          cfg.MinMem,
+			"min_idle_seconds": int64(cfg.MinIdle.Seconds()),
+		},
+	})
+}
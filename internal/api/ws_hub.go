@@ -56,9 +56,11 @@ func (h *Hub) HandleWS(w http.ResponseWriter, r *http.Request) {
 }
 
 // Broadcast sends a snapshot to all connected clients.
+// Writes are serialized: a websocket.Conn supports only one concurrent writer,
+// and snapshots arrive from several goroutines (collector, events, ingest).
 func (h *Hub) Broadcast(snap collector.Snapshot) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
 	if len(h.clients) == 0 {
 		return
@@ -3,6 +3,7 @@ package collector
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -11,6 +12,8 @@ type MetricSink interface {
 	WriteGPUMetrics(metrics []GPUMetrics) error
 	WriteHostMetrics(m *HostMetrics) error
 	WriteGPUProcesses(procs []GPUProcess) error
+	WriteGPUEvents(events []GPUEvent) error
+	WriteNVLinkMetrics(links []NVLinkMetrics) error
 }
 
 // BroadcastSink receives snapshots for real-time push.
@@ -18,36 +21,100 @@ type BroadcastSink interface {
 	Broadcast(snap Snapshot)
 }
 
+// GPUSource is a GPU backend (NVML, simulated, ...) that enumerates devices
+// and reads their metrics.
+type GPUSource interface {
+	Devices() []GPUDevice
+	Collect() []GPUMetrics
+	CollectProcesses() []GPUProcess
+	Shutdown()
+}
+
+// SnapshotSource is implemented by GPU sources that emit complete snapshots on
+// their own schedule (e.g. trace replay) rather than being polled.
+type SnapshotSource interface {
+	Snapshots(ctx context.Context) <-chan Snapshot
+}
+
+// EventWatcher is implemented by GPU sources that can report discrete events
+// (XID errors, ECC errors, ...). Events are streamed until ctx is cancelled.
+type EventWatcher interface {
+	WatchEvents(ctx context.Context) <-chan GPUEvent
+}
+
+// ErrorSource is implemented by GPU sources that report failed queries as
+// nvml_error events, collected after each Collect.
+type ErrorSource interface {
+	CollectErrors() []GPUEvent
+}
+
+// NVLinkSource is implemented by GPU sources that report NVLink state and counters.
+type NVLinkSource interface {
+	CollectNVLink() []NVLinkMetrics
+}
+
 // Collector orchestrates GPU and host metric collection.
 type Collector struct {
-	gpu       *GPUCollector
+	gpu       GPUSource
 	host      *HostCollector
 	storage   MetricSink
 	broadcast BroadcastSink
 
-	gpuInterval  time.Duration
-	hostInterval time.Duration
+	gpuInterval    time.Duration
+	hostInterval   time.Duration
+	nvlinkInterval time.Duration
 }
 
 // New creates a new Collector.
-func New(gpu *GPUCollector, host *HostCollector, storage MetricSink, broadcast BroadcastSink, gpuInterval, hostInterval time.Duration) *Collector {
+// A zero nvlinkInterval disables NVLink collection.
+func New(gpu GPUSource, host *HostCollector, storage MetricSink, broadcast BroadcastSink, gpuInterval, hostInterval, nvlinkInterval time.Duration) *Collector {
 	return &Collector{
-		gpu:          gpu,
-		host:         host,
-		storage:      storage,
-		broadcast:    broadcast,
-		gpuInterval:  gpuInterval,
-		hostInterval: hostInterval,
+		gpu:            gpu,
+		host:           host,
+		storage:        storage,
+		broadcast:      broadcast,
+		gpuInterval:    gpuInterval,
+		hostInterval:   hostInterval,
+		nvlinkInterval: nvlinkInterval,
 	}
 }
 
-// Run starts collection loops. Blocks until ctx is cancelled.
+// Run starts collection loops. Blocks until ctx is cancelled and nothing
+// more will be published, so the sinks can be closed when it returns.
+// Sources that produce their own snapshots (see SnapshotSource) are drained
+// instead of being polled.
 func (c *Collector) Run(ctx context.Context) {
+	if ew, ok := c.gpu.(EventWatcher); ok {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.watchEvents(ctx, ew)
+		}()
+		defer wg.Wait()
+	}
+
+	if ss, ok := c.gpu.(SnapshotSource); ok {
+		for snap := range ss.Snapshots(ctx) {
+			c.publish(snap)
+		}
+		return
+	}
+
 	gpuTicker := time.NewTicker(c.gpuInterval)
 	hostTicker := time.NewTicker(c.hostInterval)
 	defer gpuTicker.Stop()
 	defer hostTicker.Stop()
 
+	// NVLink counters change slowly and are voluminous; sample them separately
+	var nvlinkTick <-chan time.Time
+	nvlinkSrc, ok := c.gpu.(NVLinkSource)
+	if ok && c.nvlinkInterval > 0 {
+		nvlinkTicker := time.NewTicker(c.nvlinkInterval)
+		defer nvlinkTicker.Stop()
+		nvlinkTick = nvlinkTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -58,38 +125,49 @@ func (c *Collector) Run(ctx context.Context) {
 
 		case <-hostTicker.C:
 			c.collectHost()
+
+		case <-nvlinkTick:
+			c.collectNVLink(nvlinkSrc)
 		}
 	}
 }
 
-func (c *Collector) collectGPU() {
-	metrics := c.gpu.Collect()
-
-	if err := c.storage.WriteGPUMetrics(metrics); err != nil {
-		log.Printf("error writing GPU metrics: %v", err)
+// watchEvents publishes GPU events as they arrive.
+func (c *Collector) watchEvents(ctx context.Context, ew EventWatcher) {
+	for ev := range ew.WatchEvents(ctx) {
+		log.Printf("GPU %d event: %s %s", ev.GPUID, ev.Type, ev.Description)
+		c.publish(Snapshot{
+			Type:      "gpu_events",
+			Timestamp: ev.Timestamp,
+			Events:    []GPUEvent{ev},
+		})
 	}
+}
 
-	if c.broadcast != nil {
-		c.broadcast.Broadcast(Snapshot{
-			Type:      "gpu_metrics",
-			Timestamp: time.Now().Unix(),
-			GPUs:      metrics,
-		})
+func (c *Collector) collectGPU() {
+	c.publish(Snapshot{
+		Type:      "gpu_metrics",
+		Timestamp: time.Now().Unix(),
+		GPUs:      c.gpu.Collect(),
+	})
+	if es, ok := c.gpu.(ErrorSource); ok {
+		if errs := es.CollectErrors(); len(errs) > 0 {
+			c.publish(Snapshot{
+				Type:      "gpu_events",
+				Timestamp: time.Now().Unix(),
+				Events:    errs,
+			})
+		}
 	}
 
 	// Collect processes alongside GPU metrics (less frequent internally)
 	procs := c.gpu.CollectProcesses()
 	if len(procs) > 0 {
-		if err := c.storage.WriteGPUProcesses(procs); err != nil {
-			log.Printf("error writing GPU processes: %v", err)
-		}
-		if c.broadcast != nil {
-			c.broadcast.Broadcast(Snapshot{
-				Type:      "gpu_processes",
-				Timestamp: time.Now().Unix(),
-				Processes: procs,
-			})
-		}
+		c.publish(Snapshot{
+			Type:      "gpu_processes",
+			Timestamp: time.Now().Unix(),
+			Processes: procs,
+		})
 	}
 }
 
@@ -100,15 +178,51 @@ func (c *Collector) collectHost() {
 		return
 	}
 
-	if err := c.storage.WriteHostMetrics(m); err != nil {
-		log.Printf("error writing host metrics: %v", err)
+	c.publish(Snapshot{
+		Type:      "host_metrics",
+		Timestamp: time.Now().Unix(),
+		Host:      m,
+	})
+}
+
+func (c *Collector) collectNVLink(src NVLinkSource) {
+	links := src.CollectNVLink()
+	if len(links) == 0 {
+		return
+	}
+
+	c.publish(Snapshot{
+		Type:      "nvlink",
+		Timestamp: time.Now().Unix(),
+		NVLinks:   links,
+	})
+}
+
+// publish writes a snapshot to storage and pushes it to the broadcast sink.
+// Either sink may be nil.
+func (c *Collector) publish(snap Snapshot) {
+	if c.storage != nil {
+		var err error
+		switch snap.Type {
+		case "gpu_metrics":
+			err = c.storage.WriteGPUMetrics(snap.GPUs)
+		case "gpu_processes":
+			err = c.storage.WriteGPUProcesses(snap.Processes)
+		case "gpu_events":
+			err = c.storage.WriteGPUEvents(snap.Events)
+		case "nvlink":
+			err = c.storage.WriteNVLinkMetrics(snap.NVLinks)
+		case "host_metrics":
+			if snap.Host != nil {
+				err = c.storage.WriteHostMetrics(snap.Host)
+			}
+		}
+		if err != nil {
+			log.Printf("error writing %s: %v", snap.Type, err)
+		}
 	}
 
 	if c.broadcast != nil {
-		c.broadcast.Broadcast(Snapshot{
-			Type:      "host_metrics",
-			Timestamp: time.Now().Unix(),
-			Host:      m,
-		})
+		c.broadcast.Broadcast(snap)
 	}
 }
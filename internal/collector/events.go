@@ -0,0 +1,36 @@
+package collector
+
+import "fmt"
+
+// xidDescriptions covers the XID codes most relevant to datacenter operations.
+// See https://docs.nvidia.com/deploy/xid-errors/ for the full catalogue.
+var xidDescriptions = map[uint64]string{
+	13:  "graphics engine exception",
+	31:  "GPU memory page fault",
+	32:  "invalid or corrupted push buffer stream",
+	38:  "driver firmware error",
+	43:  "GPU stopped processing",
+	45:  "preemptive cleanup due to previous errors",
+	48:  "double bit ECC error",
+	61:  "internal micro-controller breakpoint/warning",
+	62:  "internal micro-controller halt",
+	63:  "ECC page retirement or row remapping recording event",
+	64:  "ECC page retirement or row remapper recording failure",
+	68:  "video processor exception",
+	69:  "graphics engine class error",
+	74:  "NVLink error",
+	79:  "GPU has fallen off the bus",
+	92:  "high single-bit ECC error rate",
+	94:  "contained ECC error",
+	95:  "uncontained ECC error",
+	119: "GSP RPC timeout",
+	120: "GSP error",
+}
+
+// XIDDescription returns a human-readable description for an XID code.
+func XIDDescription(xid uint64) string {
+	if d, ok := xidDescriptions[xid]; ok {
+		return fmt.Sprintf("XID %d: %s", xid, d)
+	}
+	return fmt.Sprintf("XID %d", xid)
+}
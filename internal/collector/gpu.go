@@ -1,22 +1,33 @@
 package collector
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"strings"
+	"log"
 	"time"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
 )
 
-// GPUCollector reads metrics from NVIDIA GPUs via NVML.
+// GPUCollector reads metrics from NVIDIA GPUs via NVML. It is the default GPUSource.
 type GPUCollector struct {
-	devices []nvml.Device
-	info    []GPUDevice
+	devices []nvml.Device // physical GPUs, by index
+	info    []GPUDevice   // physical GPUs followed by MIG instances
+	migs    []migInstance
+	procs   *ProcReader
+
+	procUtilSeen []uint64 // per GPU: timestamp of the newest process utilization sample read
+
+	pciBusIDs  []string
+	nvlinkPrev map[[2]int]nvlinkCounter // (gpu, link) -> last throughput reading
+
+	errs    []GPUEvent        // failed queries since the last CollectErrors
+	lastErr map[int]time.Time // per GPU: when the last error was recorded
 }
 
 // NewGPUCollector initializes NVML and enumerates GPU devices.
-func NewGPUCollector() (*GPUCollector, error) {
+// Processes are attributed to users and containers through procs.
+func NewGPUCollector(procs *ProcReader) (*GPUCollector, error) {
 	ret := nvml.Init()
 	if ret != nvml.SUCCESS {
 		return nil, fmt.Errorf("nvml.Init failed: %v", nvml.ErrorString(ret))
@@ -30,8 +41,13 @@ func NewGPUCollector() (*GPUCollector, error) {
 	driverVer, _ := nvml.SystemGetDriverVersion()
 
 	gc := &GPUCollector{
-		devices: make([]nvml.Device, count),
-		info:    make([]GPUDevice, count),
+		devices:      make([]nvml.Device, count),
+		procs:        procs,
+		info:         make([]GPUDevice, count),
+		procUtilSeen: make([]uint64, count),
+		pciBusIDs:    make([]string, count),
+		nvlinkPrev:   make(map[[2]int]nvlinkCounter),
+		lastErr:      make(map[int]time.Time),
 	}
 
 	for i := 0; i < count; i++ {
@@ -51,6 +67,30 @@ func NewGPUCollector() (*GPUCollector, error) {
 			Name:      name,
 			MemTotal:  memInfo.Total / (1024 * 1024),
 			DriverVer: driverVer,
+			ParentID:  -1,
+		}
+		if pci, ret := dev.GetPciInfo(); ret == nvml.SUCCESS {
+			gc.pciBusIDs[i] = pciBusID(pci)
+		}
+		if clock, ret := dev.GetMaxClockInfo(nvml.CLOCK_GRAPHICS); ret == nvml.SUCCESS {
+			gc.info[i].ClockGfxMax = int(clock)
+		}
+		if clock, ret := dev.GetMaxClockInfo(nvml.CLOCK_MEM); ret == nvml.SUCCESS {
+			gc.info[i].ClockMemMax = int(clock)
+		}
+		if temp, ret := dev.GetTemperatureThreshold(nvml.TEMPERATURE_THRESHOLD_SLOWDOWN); ret == nvml.SUCCESS {
+			gc.info[i].TempSlowdown = int(temp)
+		}
+		if temp, ret := dev.GetTemperatureThreshold(nvml.TEMPERATURE_THRESHOLD_SHUTDOWN); ret == nvml.SUCCESS {
+			gc.info[i].TempShutdown = int(temp)
+		}
+	}
+
+	// MIG instances are appended after all physical GPUs
+	for i, dev := range gc.devices {
+		if cur, _, ret := dev.GetMigMode(); ret == nvml.SUCCESS && cur == nvml.DEVICE_MIG_ENABLE {
+			gc.info[i].MIGEnabled = true
+			gc.enumerateMIG(i, dev)
 		}
 	}
 
@@ -62,10 +102,13 @@ func (gc *GPUCollector) Devices() []GPUDevice {
 	return gc.info
 }
 
-// Collect reads current metrics from all GPUs.
+// Collect reads current metrics from all GPUs. GPUs that are lost (fell
+// off the bus) or gone report no metrics, nor do their MIG instances, so
+// that they show up as missing rather than as idle.
 func (gc *GPUCollector) Collect() []GPUMetrics {
 	now := time.Now().Unix()
-	metrics := make([]GPUMetrics, len(gc.devices))
+	metrics := make([]GPUMetrics, 0, len(gc.devices))
+	lost := make(map[int]bool)
 
 	for i, dev := range gc.devices {
 		m := GPUMetrics{
@@ -76,14 +119,32 @@ func (gc *GPUCollector) Collect() []GPUMetrics {
 		if util, ret := dev.GetUtilizationRates(); ret == nvml.SUCCESS {
 			m.GPUUtil = float64(util.Gpu)
 			m.MemUtil = float64(util.Memory)
+		} else {
+			gc.queryError(i, "GetUtilizationRates", ret)
+			if gpuLost(ret) {
+				lost[i] = true
+				continue
+			}
 		}
 
 		if memInfo, ret := dev.GetMemoryInfo(); ret == nvml.SUCCESS {
 			m.MemUsed = memInfo.Used / (1024 * 1024)
+		} else {
+			gc.queryError(i, "GetMemoryInfo", ret)
+			if gpuLost(ret) {
+				lost[i] = true
+				continue
+			}
 		}
 
 		if temp, ret := dev.GetTemperature(nvml.TEMPERATURE_GPU); ret == nvml.SUCCESS {
 			m.Temperature = int(temp)
+		} else {
+			gc.queryError(i, "GetTemperature", ret)
+			if gpuLost(ret) {
+				lost[i] = true
+				continue
+			}
 		}
 
 		if fan, ret := dev.GetFanSpeed(); ret == nvml.SUCCESS {
@@ -126,13 +187,74 @@ func (gc *GPUCollector) Collect() []GPUMetrics {
 			m.DecoderUtil = float64(util)
 		}
 
-		metrics[i] = m
+		if reasons, ret := dev.GetCurrentClocksThrottleReasons(); ret == nvml.SUCCESS {
+			m.ThrottleReasons = reasons
+		}
+
+		if clock, ret := dev.GetApplicationsClock(nvml.CLOCK_GRAPHICS); ret == nvml.SUCCESS {
+			m.ClockGfxApp = int(clock)
+		}
+
+		if clock, ret := dev.GetApplicationsClock(nvml.CLOCK_MEM); ret == nvml.SUCCESS {
+			m.ClockMemApp = int(clock)
+		}
+
+		collectMemoryHealth(dev, &m)
+
+		metrics = append(metrics, m)
+	}
+
+	return append(metrics, gc.collectMIG(now, lost)...)
+}
+
+// gpuLost reports whether a query failed because the GPU is no longer
+// usable: it fell off the bus or was removed.
+func gpuLost(ret nvml.Return) bool {
+	return ret == nvml.ERROR_GPU_IS_LOST || ret == nvml.ERROR_NOT_FOUND
+}
+
+// collectMemoryHealth reads ECC counters, retired pages and row remapping state.
+// Pre-Ampere cards retire pages; Ampere and later remap rows instead, so one of
+// the two groups is typically NOT_SUPPORTED.
+func collectMemoryHealth(dev nvml.Device, m *GPUMetrics) {
+	if cur, _, ret := dev.GetEccMode(); ret != nvml.SUCCESS || cur != nvml.FEATURE_ENABLED {
+		return
+	}
+	m.ECCEnabled = true
+
+	if n, ret := dev.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC); ret == nvml.SUCCESS {
+		m.ECCCorrVolatile = n
+	}
+	if n, ret := dev.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC); ret == nvml.SUCCESS {
+		m.ECCUncorrVolatile = n
+	}
+	if n, ret := dev.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.AGGREGATE_ECC); ret == nvml.SUCCESS {
+		m.ECCCorrAggregate = n
+	}
+	if n, ret := dev.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.AGGREGATE_ECC); ret == nvml.SUCCESS {
+		m.ECCUncorrAggregate = n
+	}
+
+	if pages, ret := dev.GetRetiredPages(nvml.PAGE_RETIREMENT_CAUSE_MULTIPLE_SINGLE_BIT_ECC_ERRORS); ret == nvml.SUCCESS {
+		m.RetiredPagesSBE = len(pages)
+	}
+	if pages, ret := dev.GetRetiredPages(nvml.PAGE_RETIREMENT_CAUSE_DOUBLE_BIT_ECC_ERROR); ret == nvml.SUCCESS {
+		m.RetiredPagesDBE = len(pages)
+	}
+	if pending, ret := dev.GetRetiredPagesPendingStatus(); ret == nvml.SUCCESS {
+		m.RetiredPending = pending == nvml.FEATURE_ENABLED
 	}
 
-	return metrics
+	if corr, uncorr, pending, failed, ret := dev.GetRemappedRows(); ret == nvml.SUCCESS {
+		m.RemappedCorr = corr
+		m.RemappedUncorr = uncorr
+		m.RemapPending = pending
+		m.RemapFailed = failed
+	}
 }
 
-// CollectProcesses returns GPU processes for all devices.
+// CollectProcesses returns GPU processes for all devices. Processes on a
+// partitioned GPU are attributed to their MIG instance.
 func (gc *GPUCollector) CollectProcesses() []GPUProcess {
 	now := time.Now().Unix()
 	var procs []GPUProcess
@@ -142,57 +264,200 @@ func (gc *GPUCollector) CollectProcesses() []GPUProcess {
 		if ret != nvml.SUCCESS {
 			continue
 		}
+
+		// Also check graphics processes
+		if gfxInfos, ret := dev.GetGraphicsRunningProcesses(); ret == nvml.SUCCESS {
+			infos = append(infos, gfxInfos...)
+		}
+
+		util := gc.processUtilization(i, dev)
+		seen := make(map[[2]int]bool)
 		for _, info := range infos {
-			name := readProcessName(info.Pid)
-			procs = append(procs, GPUProcess{
+			gpuID := gc.processDeviceID(i, info)
+			// Deduplicate processes that are both compute and graphics
+			key := [2]int{gpuID, int(info.Pid)}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			p := GPUProcess{
 				Timestamp: now,
-				GPUID:     i,
+				GPUID:     gpuID,
 				PID:       info.Pid,
-				Name:      name,
 				GPUMem:    info.UsedGpuMemory / (1024 * 1024),
-			})
+			}
+			gc.procs.Enrich(&p)
+			if u, ok := util[info.Pid]; ok {
+				p.SMUtil, p.MemUtil, p.EncUtil, p.DecUtil = u.sm, u.mem, u.enc, u.dec
+			}
+			procs = append(procs, p)
 		}
+	}
 
-		// Also check graphics processes
-		gfxInfos, ret := dev.GetGraphicsRunningProcesses()
+	return procs
+}
+
+// procUtil is the average utilization of a process over the samples read.
+type procUtil struct {
+	sm, mem, enc, dec float64
+}
+
+// processUtilization averages the per-process utilization samples NVML has
+// buffered for a GPU since the previous call, keyed by PID. NVML samples
+// processes on its own schedule (roughly every 1/6 s), so several samples
+// per process are common; none are returned if nothing ran in between.
+func (gc *GPUCollector) processUtilization(i int, dev nvml.Device) map[uint32]procUtil {
+	samples, ret := dev.GetProcessUtilization(gc.procUtilSeen[i])
+	if ret != nvml.SUCCESS || len(samples) == 0 {
+		return nil
+	}
+
+	sums := make(map[uint32]procUtil)
+	counts := make(map[uint32]int)
+	for _, smp := range samples {
+		u := sums[smp.Pid]
+		u.sm += float64(smp.SmUtil)
+		u.mem += float64(smp.MemUtil)
+		u.enc += float64(smp.EncUtil)
+		u.dec += float64(smp.DecUtil)
+		sums[smp.Pid] = u
+		counts[smp.Pid]++
+		if smp.TimeStamp > gc.procUtilSeen[i] {
+			gc.procUtilSeen[i] = smp.TimeStamp
+		}
+	}
+
+	for pid, u := range sums {
+		n := float64(counts[pid])
+		sums[pid] = procUtil{sm: u.sm / n, mem: u.mem / n, enc: u.enc / n, dec: u.dec / n}
+	}
+	return sums
+}
+
+// watchedEvents is the NVML event mask registered by WatchEvents.
+const watchedEvents = uint64(nvml.EventTypeXidCriticalError | nvml.EventTypeDoubleBitEccError |
+	nvml.EventTypePowerSourceChange | nvml.EventTypeClock)
+
+// nvmlErrorInterval limits nvml_error events to one per GPU per interval;
+// a lost GPU fails every query of every collection.
+const nvmlErrorInterval = time.Minute
+
+// queryError records a failed query of a metric every GPU supports as an
+// nvml_error event (e.g. GPU_IS_LOST after the GPU fell off the bus).
+func (gc *GPUCollector) queryError(gpu int, query string, ret nvml.Return) {
+	if ret == nvml.ERROR_NOT_SUPPORTED {
+		return
+	}
+	now := time.Now()
+	if now.Sub(gc.lastErr[gpu]) < nvmlErrorInterval {
+		return
+	}
+	gc.lastErr[gpu] = now
+	desc := fmt.Sprintf("%s: %s", query, nvml.ErrorString(ret))
+	log.Printf("GPU %d: %s", gpu, desc)
+	gc.errs = append(gc.errs, GPUEvent{Timestamp: now.Unix(), GPUID: gpu, Type: "nvml_error", Description: desc})
+}
+
+// CollectErrors returns the NVML errors recorded since the last call.
+func (gc *GPUCollector) CollectErrors() []GPUEvent {
+	errs := gc.errs
+	gc.errs = nil
+	return errs
+}
+
+// clockEventInterval suppresses repeated clock-change events per GPU;
+// boost/throttle transitions can otherwise fire several times a second.
+const clockEventInterval = 10 * time.Second
+
+// WatchEvents registers an NVML event set for XID, double-bit ECC, power
+// source and clock change events on all devices and streams occurrences.
+func (gc *GPUCollector) WatchEvents(ctx context.Context) <-chan GPUEvent {
+	ch := make(chan GPUEvent, 16)
+	go func() {
+		defer close(ch)
+
+		set, ret := nvml.EventSetCreate()
 		if ret != nvml.SUCCESS {
-			continue
+			log.Printf("EventSetCreate: %v (GPU events disabled)", nvml.ErrorString(ret))
+			return
 		}
-		for _, info := range gfxInfos {
-			// Deduplicate with compute processes
-			found := false
-			for _, p := range procs {
-				if p.PID == info.Pid && p.GPUID == i {
-					found = true
-					break
-				}
+		defer set.Free()
+
+		registered := 0
+		for i, dev := range gc.devices {
+			supported, ret := dev.GetSupportedEventTypes()
+			if ret != nvml.SUCCESS || supported&watchedEvents == 0 {
+				continue
 			}
-			if found {
+			if ret := dev.RegisterEvents(supported&watchedEvents, set); ret != nvml.SUCCESS {
+				log.Printf("GPU %d: RegisterEvents: %v", i, nvml.ErrorString(ret))
 				continue
 			}
-			name := readProcessName(info.Pid)
-			procs = append(procs, GPUProcess{
-				Timestamp: now,
-				GPUID:     i,
-				PID:       info.Pid,
-				Name:      name,
-				GPUMem:    info.UsedGpuMemory / (1024 * 1024),
-			})
+			registered++
+		}
+		if registered == 0 {
+			log.Println("no GPU supports event reporting (GPU events disabled)")
+			return
 		}
-	}
 
-	return procs
+		lastClock := make(map[int]time.Time)
+		for ctx.Err() == nil {
+			data, ret := set.Wait(1000)
+			if ret == nvml.ERROR_TIMEOUT {
+				continue
+			}
+			if ret != nvml.SUCCESS {
+				log.Printf("EventSetWait: %v", nvml.ErrorString(ret))
+				time.Sleep(time.Second)
+				continue
+			}
+
+			now := time.Now()
+			ev := GPUEvent{Timestamp: now.Unix(), GPUID: gc.deviceIndex(data.Device)}
+			switch data.EventType {
+			case nvml.EventTypeXidCriticalError:
+				ev.Type = "xid"
+				ev.XID = data.EventData
+				ev.Description = XIDDescription(data.EventData)
+			case nvml.EventTypeDoubleBitEccError:
+				ev.Type = "double_bit_ecc"
+				ev.Description = "double bit ECC error"
+			case nvml.EventTypePowerSourceChange:
+				ev.Type = "power_source"
+				ev.Description = fmt.Sprintf("power source changed (source %d)", data.EventData)
+			case nvml.EventTypeClock:
+				if now.Sub(lastClock[ev.GPUID]) < clockEventInterval {
+					continue
+				}
+				lastClock[ev.GPUID] = now
+				ev.Type = "clock_change"
+				ev.Description = "clocks changed"
+			default:
+				continue
+			}
+
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// deviceIndex maps an NVML handle back to its index, or -1 if unknown.
+func (gc *GPUCollector) deviceIndex(dev nvml.Device) int {
+	for i, d := range gc.devices {
+		if d == dev {
+			return i
+		}
+	}
+	return -1
 }
 
 // Shutdown cleans up NVML.
 func (gc *GPUCollector) Shutdown() {
 	nvml.Shutdown()
 }
-
-func readProcessName(pid uint32) string {
-	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
-	if err != nil {
-		return fmt.Sprintf("pid-%d", pid)
-	}
-	return strings.TrimSpace(string(data))
-}
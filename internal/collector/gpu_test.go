@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// fakeDevice answers the queries Collect makes. Queries return ret unless
+// a query is listed in fail; the ones it does not implement panic.
+type fakeDevice struct {
+	nvml.Device
+	ret  nvml.Return
+	fail map[string]nvml.Return
+	mem  uint64 // MiB used
+}
+
+func (d *fakeDevice) result(query string) nvml.Return {
+	if ret, ok := d.fail[query]; ok {
+		return ret
+	}
+	return d.ret
+}
+
+func (d *fakeDevice) GetUtilizationRates() (nvml.Utilization, nvml.Return) {
+	return nvml.Utilization{Gpu: 42, Memory: 17}, d.result("GetUtilizationRates")
+}
+
+func (d *fakeDevice) GetMemoryInfo() (nvml.Memory, nvml.Return) {
+	return nvml.Memory{Used: d.mem << 20, Total: 80 << 30}, d.result("GetMemoryInfo")
+}
+
+func (d *fakeDevice) GetTemperature(nvml.TemperatureSensors) (uint32, nvml.Return) {
+	return 55, d.result("GetTemperature")
+}
+
+func (d *fakeDevice) GetFanSpeed() (uint32, nvml.Return)           { return 30, d.ret }
+func (d *fakeDevice) GetPowerUsage() (uint32, nvml.Return)         { return 250000, d.ret }
+func (d *fakeDevice) GetEnforcedPowerLimit() (uint32, nvml.Return) { return 400000, d.ret }
+func (d *fakeDevice) GetClockInfo(nvml.ClockType) (uint32, nvml.Return) {
+	return 1410, d.ret
+}
+func (d *fakeDevice) GetPcieThroughput(nvml.PcieUtilCounter) (uint32, nvml.Return) {
+	return 100, d.ret
+}
+func (d *fakeDevice) GetPerformanceState() (nvml.Pstates, nvml.Return) {
+	return nvml.PSTATE_0, d.ret
+}
+func (d *fakeDevice) GetEncoderUtilization() (uint32, uint32, nvml.Return) { return 0, 0, d.ret }
+func (d *fakeDevice) GetDecoderUtilization() (uint32, uint32, nvml.Return) { return 0, 0, d.ret }
+func (d *fakeDevice) GetCurrentClocksThrottleReasons() (uint64, nvml.Return) {
+	return 0, d.ret
+}
+func (d *fakeDevice) GetApplicationsClock(nvml.ClockType) (uint32, nvml.Return) {
+	return 1410, d.ret
+}
+func (d *fakeDevice) GetEccMode() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+	return nvml.FEATURE_DISABLED, nvml.FEATURE_DISABLED, d.ret
+}
+
+func TestCollectSkipsLostGPUs(t *testing.T) {
+	ok := &fakeDevice{ret: nvml.SUCCESS, mem: 1024}
+	lost := &fakeDevice{ret: nvml.ERROR_GPU_IS_LOST}
+	// Removed while being collected: the first query still succeeded
+	gone := &fakeDevice{ret: nvml.SUCCESS, fail: map[string]nvml.Return{"GetMemoryInfo": nvml.ERROR_NOT_FOUND, "GetTemperature": nvml.ERROR_NOT_FOUND}}
+	// Other errors are reported, but the GPU is still there
+	flaky := &fakeDevice{ret: nvml.SUCCESS, fail: map[string]nvml.Return{"GetTemperature": nvml.ERROR_UNKNOWN}}
+
+	gc := &GPUCollector{
+		devices: []nvml.Device{ok, lost, gone, flaky},
+		migs: []migInstance{
+			{id: MIGDeviceID(0, 1, 0), parent: 0, gi: 1, dev: &fakeDevice{ret: nvml.SUCCESS, mem: 512}},
+			{id: MIGDeviceID(1, 1, 0), parent: 1, gi: 1, dev: &fakeDevice{ret: nvml.SUCCESS, mem: 512}},
+			// The parent answered, but the instance is gone
+			{id: MIGDeviceID(3, 1, 0), parent: 3, gi: 1, dev: &fakeDevice{ret: nvml.ERROR_GPU_IS_LOST}},
+		},
+		lastErr: make(map[int]time.Time),
+	}
+
+	for round := 0; round < 2; round++ {
+		metrics := gc.Collect()
+		var ids []int
+		for _, m := range metrics {
+			ids = append(ids, m.GPUID)
+		}
+		want := []int{0, 3, MIGDeviceID(0, 1, 0)}
+		if len(ids) != len(want) || ids[0] != want[0] || ids[1] != want[1] || ids[2] != want[2] {
+			t.Fatalf("round %d: rows for GPUs %v, want %v", round, ids, want)
+		}
+		if m := metrics[0]; m.GPUUtil != 42 || m.MemUsed != 1024 || m.Temperature != 55 || m.PowerDraw != 250 {
+			t.Errorf("round %d: GPU 0 = %+v", round, m)
+		}
+		if m := metrics[1]; m.Temperature != 0 || m.GPUUtil != 42 {
+			t.Errorf("round %d: GPU 3 = %+v, want all but its temperature", round, m)
+		}
+		if m := metrics[2]; m.MemUsed != 512 {
+			t.Errorf("round %d: MIG instance = %+v", round, m)
+		}
+
+		// Errors are reported as events once per interval
+		errs := gc.CollectErrors()
+		if round > 0 {
+			if len(errs) != 0 {
+				t.Errorf("round %d: repeated errors %+v", round, errs)
+			}
+			continue
+		}
+		want = []int{1, 2, 3}
+		if len(errs) != len(want) {
+			t.Fatalf("errors = %+v, want one for each of GPUs %v", errs, want)
+		}
+		for i, e := range errs {
+			if e.GPUID != want[i] || e.Type != "nvml_error" {
+				t.Errorf("error %d = %+v, want an nvml_error for GPU %d", i, e, want[i])
+			}
+		}
+	}
+}
+
+func TestGPULost(t *testing.T) {
+	for _, tt := range []struct {
+		ret  nvml.Return
+		want bool
+	}{
+		{nvml.ERROR_GPU_IS_LOST, true},
+		{nvml.ERROR_NOT_FOUND, true},
+		{nvml.ERROR_UNKNOWN, false},
+		{nvml.ERROR_NOT_SUPPORTED, false},
+		{nvml.ERROR_TIMEOUT, false},
+	} {
+		if got := gpuLost(tt.ret); got != tt.want {
+			t.Errorf("gpuLost(%v) = %t, want %t", tt.ret, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"log"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// migInstance is an enumerated MIG compute instance of a physical GPU.
+type migInstance struct {
+	id     int // MIGDeviceID
+	parent int // physical GPU index
+	gi, ci int
+	dev    nvml.Device
+}
+
+// enumerateMIG discovers the MIG instances of a physical GPU in MIG mode and
+// appends them to gc.migs and gc.info. The MIG layout is read once at startup;
+// repartitioning requires restarting the collector.
+func (gc *GPUCollector) enumerateMIG(parent int, dev nvml.Device) {
+	slots, ret := dev.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		log.Printf("GPU %d: GetMaxMigDeviceCount: %v", parent, nvml.ErrorString(ret))
+		return
+	}
+
+	for j := 0; j < slots; j++ {
+		mig, ret := dev.GetMigDeviceHandleByIndex(j)
+		if ret != nvml.SUCCESS {
+			continue // slot not populated
+		}
+		gi, ret := mig.GetGpuInstanceId()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+		ci, ret := mig.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+
+		uuid, _ := mig.GetUUID()
+		name, _ := mig.GetName()
+		memInfo, _ := mig.GetMemoryInfo()
+
+		mi := migInstance{id: MIGDeviceID(parent, gi, ci), parent: parent, gi: gi, ci: ci, dev: mig}
+		gc.migs = append(gc.migs, mi)
+
+		info := gc.info[parent]
+		gc.info = append(gc.info, GPUDevice{
+			ID:         mi.id,
+			UUID:       uuid,
+			Name:       name,
+			MemTotal:   memInfo.Total / (1024 * 1024),
+			DriverVer:  info.DriverVer,
+			ParentUUID: info.UUID,
+			ParentID:   parent,
+			MIGProfile: migProfile(name),
+			GIID:       gi,
+			CIID:       ci,
+		})
+	}
+}
+
+// collectMIG reads per-instance metrics. NVML only reports memory for MIG
+// devices; utilization, clocks and power belong to the parent GPU.
+// Instances of lost parents are skipped.
+func (gc *GPUCollector) collectMIG(now int64, lost map[int]bool) []GPUMetrics {
+	metrics := make([]GPUMetrics, 0, len(gc.migs))
+	for _, mi := range gc.migs {
+		if lost[mi.parent] {
+			continue
+		}
+		m := GPUMetrics{
+			Timestamp: now,
+			GPUID:     mi.id,
+		}
+		if memInfo, ret := mi.dev.GetMemoryInfo(); ret == nvml.SUCCESS {
+			m.MemUsed = memInfo.Used / (1024 * 1024)
+		} else if gpuLost(ret) {
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// processDeviceID returns the device a process runs on: the MIG instance
+// identified by the process's GPU/compute instance IDs if the parent is
+// partitioned, the physical GPU otherwise.
+func (gc *GPUCollector) processDeviceID(parent int, info nvml.ProcessInfo) int {
+	for _, mi := range gc.migs {
+		if mi.parent == parent && uint32(mi.gi) == info.GpuInstanceId && uint32(mi.ci) == info.ComputeInstanceId {
+			return mi.id
+		}
+	}
+	return parent
+}
+
+// migProfile extracts the profile from a MIG device name such as
+// "NVIDIA A100-SXM4-80GB MIG 3g.40gb".
+func migProfile(name string) string {
+	if i := strings.LastIndex(name, "MIG "); i >= 0 {
+		return name[i+len("MIG "):]
+	}
+	return ""
+}
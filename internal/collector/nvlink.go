@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// nvlinkCounter is the previous cumulative throughput reading of a link.
+type nvlinkCounter struct {
+	tx, rx uint64 // KiB
+	at     time.Time
+}
+
+// CollectNVLink reads state, peer and counters of every NVLink of every GPU.
+// Links whose state cannot be read (absent or unsupported) are skipped.
+func (gc *GPUCollector) CollectNVLink() []NVLinkMetrics {
+	now := time.Now()
+	var links []NVLinkMetrics
+
+	for i, dev := range gc.devices {
+		for l := 0; l < nvml.NVLINK_MAX_LINKS; l++ {
+			state, ret := dev.GetNvLinkState(l)
+			if ret != nvml.SUCCESS {
+				continue
+			}
+
+			m := NVLinkMetrics{
+				Timestamp:   now.Unix(),
+				GPUID:       i,
+				Link:        l,
+				Active:      state == nvml.FEATURE_ENABLED,
+				RemoteType:  "unknown",
+				RemoteGPUID: -1,
+			}
+
+			if t, ret := dev.GetNvLinkRemoteDeviceType(l); ret == nvml.SUCCESS {
+				m.RemoteType = nvlinkDeviceType(t)
+			}
+			if pci, ret := dev.GetNvLinkRemotePciInfo(l); ret == nvml.SUCCESS {
+				m.RemotePCI = pciBusID(pci)
+				if m.RemoteType == "gpu" {
+					m.RemoteGPUID = gc.indexByPCI(m.RemotePCI)
+				}
+			}
+
+			if n, ret := dev.GetNvLinkErrorCounter(l, nvml.NVLINK_ERROR_DL_CRC_FLIT); ret == nvml.SUCCESS {
+				m.CRCErrors = n
+			}
+			if n, ret := dev.GetNvLinkErrorCounter(l, nvml.NVLINK_ERROR_DL_REPLAY); ret == nvml.SUCCESS {
+				m.ReplayErrors = n
+			}
+			if n, ret := dev.GetNvLinkErrorCounter(l, nvml.NVLINK_ERROR_DL_RECOVERY); ret == nvml.SUCCESS {
+				m.RecoveryErrors = n
+			}
+
+			gc.nvlinkThroughput(dev, i, l, now, &m)
+			links = append(links, m)
+		}
+	}
+
+	return links
+}
+
+// nvlinkThroughput derives KiB/s from the cumulative data throughput fields.
+func (gc *GPUCollector) nvlinkThroughput(dev nvml.Device, gpu, link int, now time.Time, m *NVLinkMetrics) {
+	values := []nvml.FieldValue{
+		{FieldId: nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_TX, ScopeId: uint32(link)},
+		{FieldId: nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_RX, ScopeId: uint32(link)},
+	}
+	if ret := dev.GetFieldValues(values); ret != nvml.SUCCESS {
+		return
+	}
+	if values[0].NvmlReturn != uint32(nvml.SUCCESS) || values[1].NvmlReturn != uint32(nvml.SUCCESS) {
+		return
+	}
+	cur := nvlinkCounter{
+		tx: binary.LittleEndian.Uint64(values[0].Value[:]),
+		rx: binary.LittleEndian.Uint64(values[1].Value[:]),
+		at: now,
+	}
+
+	key := [2]int{gpu, link}
+	if prev, ok := gc.nvlinkPrev[key]; ok && cur.tx >= prev.tx && cur.rx >= prev.rx {
+		if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+			m.TxKBps = uint64(float64(cur.tx-prev.tx) / elapsed)
+			m.RxKBps = uint64(float64(cur.rx-prev.rx) / elapsed)
+		}
+	}
+	gc.nvlinkPrev[key] = cur
+}
+
+// indexByPCI returns the index of the local GPU with the given PCI bus ID, or -1.
+func (gc *GPUCollector) indexByPCI(busID string) int {
+	for i, id := range gc.pciBusIDs {
+		if id != "" && id == busID {
+			return i
+		}
+	}
+	return -1
+}
+
+func nvlinkDeviceType(t nvml.IntNvLinkDeviceType) string {
+	switch t {
+	case nvml.NVLINK_DEVICE_TYPE_GPU:
+		return "gpu"
+	case nvml.NVLINK_DEVICE_TYPE_SWITCH:
+		return "switch"
+	case nvml.NVLINK_DEVICE_TYPE_IBMNPU:
+		return "ibmnpu"
+	default:
+		return "unknown"
+	}
+}
+
+func pciBusID(pci nvml.PciInfo) string {
+	b := make([]byte, 0, len(pci.BusId))
+	for _, c := range pci.BusId {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// BuildNVLinkTopology summarizes the latest link samples of a node (ordered by
+// GPU and link) into direct GPU peers and NVSwitch attachments.
+func BuildNVLinkTopology(nodeID string, links []NVLinkMetrics) NVLinkTopology {
+	topo := NVLinkTopology{
+		NodeID:   nodeID,
+		Links:    links,
+		Peers:    []NVLinkPeer{},
+		Switches: []NVSwitchLinks{},
+	}
+	if topo.Links == nil {
+		topo.Links = []NVLinkMetrics{}
+	}
+
+	peers := make(map[[2]int]*NVLinkPeer)
+	switches := make(map[string]*NVSwitchLinks)
+	for _, l := range links {
+		switch {
+		case l.RemoteType == "gpu" && l.RemoteGPUID >= 0:
+			// Each physical link is reported by both ends; count it once
+			if l.GPUID > l.RemoteGPUID {
+				continue
+			}
+			key := [2]int{l.GPUID, l.RemoteGPUID}
+			p, ok := peers[key]
+			if !ok {
+				p = &NVLinkPeer{GPUID: l.GPUID, PeerGPUID: l.RemoteGPUID}
+				peers[key] = p
+			}
+			p.Links++
+			if l.Active {
+				p.ActiveLinks++
+			}
+		case l.RemoteType == "switch":
+			sw, ok := switches[l.RemotePCI]
+			if !ok {
+				sw = &NVSwitchLinks{PCI: l.RemotePCI}
+				switches[l.RemotePCI] = sw
+			}
+			if len(sw.GPUs) == 0 || sw.GPUs[len(sw.GPUs)-1] != l.GPUID {
+				sw.GPUs = append(sw.GPUs, l.GPUID)
+			}
+			sw.Links++
+			if l.Active {
+				sw.ActiveLinks++
+			}
+		}
+	}
+
+	for _, p := range peers {
+		topo.Peers = append(topo.Peers, *p)
+	}
+	sort.Slice(topo.Peers, func(i, j int) bool {
+		if topo.Peers[i].GPUID != topo.Peers[j].GPUID {
+			return topo.Peers[i].GPUID < topo.Peers[j].GPUID
+		}
+		return topo.Peers[i].PeerGPUID < topo.Peers[j].PeerGPUID
+	})
+	for _, sw := range switches {
+		topo.Switches = append(topo.Switches, *sw)
+	}
+	sort.Slice(topo.Switches, func(i, j int) bool { return topo.Switches[i].PCI < topo.Switches[j].PCI })
+
+	return topo
+}
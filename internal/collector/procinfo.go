@@ -0,0 +1,346 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcReader enriches GPU processes with details from procfs and container
+// runtime state. All paths are resolved under a host root ("/" when running
+// on the host or in a container sharing the host PID namespace with the host
+// filesystem mounted at that root), which also allows pointing it at a fake
+// tree.
+type ProcReader struct {
+	root string
+
+	mu         sync.Mutex
+	users      map[int]string // uid -> name from <root>/etc/passwd
+	usersRead  time.Time
+	containers map[string]containerMeta // container ID -> runtime metadata
+	misses     map[string]time.Time     // container ID -> when it was not found
+}
+
+// containerMeta is what the container runtime knows about a container.
+type containerMeta struct {
+	name      string // Docker name, or Kubernetes container name
+	namespace string // Kubernetes pod namespace
+	pod       string // Kubernetes pod name
+}
+
+// usersRefresh bounds how often /etc/passwd is re-read on an unknown UID.
+const usersRefresh = time.Minute
+
+// maxContainerCache bounds the container metadata cache; it is simply
+// dropped when full, since lookups are cheap to redo.
+const maxContainerCache = 1024
+
+// containerRetry bounds how often a container that was not found in the
+// runtime state (not written yet, or the state directories not mounted) is
+// looked up again.
+const containerRetry = time.Minute
+
+// NewProcReader creates a ProcReader for the host filesystem mounted at root.
+func NewProcReader(root string) *ProcReader {
+	if root == "" {
+		root = "/"
+	}
+	return &ProcReader{
+		root:       root,
+		containers: make(map[string]containerMeta),
+		misses:     make(map[string]time.Time),
+	}
+}
+
+// Enrich fills in the name, command line, owner, cgroup, container/pod and
+// Slurm job attribution of p from its PID. Details that cannot be read (the process
+// exited, or lives in another PID namespace) are left empty.
+func (pr *ProcReader) Enrich(p *GPUProcess) {
+	p.Name = pr.name(p.PID)
+	p.Cmdline = pr.cmdline(p.PID)
+	p.UID = -1
+	if uid, ok := pr.uid(p.PID); ok {
+		p.UID = uid
+		p.User = pr.userName(uid)
+	}
+
+	p.Cgroup = pr.cgroup(p.PID)
+	ref := parseCgroup(p.Cgroup)
+	p.ContainerID = ref.id
+	if ref.id != "" {
+		meta := pr.containerMeta(ref.id)
+		p.ContainerName = meta.name
+		p.Namespace = meta.namespace
+		p.Pod = meta.pod
+	}
+
+	env := pr.environ(p.PID)
+	if ref.kubernetes && p.Pod == "" {
+		// Without runtime metadata, the pod hostname is the best guess at its name
+		p.Pod = env["HOSTNAME"]
+	}
+
+	// Slurm exports the job to every task; the environment is only readable
+	// with privileges, so fall back to the job cgroup created by slurmd
+	p.JobID, p.JobUser, p.JobName = env["SLURM_JOB_ID"], env["SLURM_JOB_USER"], env["SLURM_JOB_NAME"]
+	if p.JobID == "" {
+		p.JobID = slurmJobFromCgroup(p.Cgroup)
+	}
+	if p.JobID != "" && p.JobUser == "" {
+		p.JobUser = p.User
+	}
+}
+
+var slurmJobRe = regexp.MustCompile(`/job_(\d+)(/|$)`)
+
+// slurmJobFromCgroup extracts the job ID from Slurm cgroup paths such as
+// /slurm/uid_1000/job_1234/step_0/task_0 (v1) or
+// /system.slice/slurmstepd.scope/job_1234/step_batch/user/task_0 (v2).
+func slurmJobFromCgroup(path string) string {
+	if !strings.Contains(path, "slurm") {
+		return ""
+	}
+	if m := slurmJobRe.FindStringSubmatch(path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func (pr *ProcReader) procPath(pid uint32, file string) string {
+	return filepath.Join(pr.root, "proc", strconv.FormatUint(uint64(pid), 10), file)
+}
+
+func (pr *ProcReader) name(pid uint32) string {
+	data, err := os.ReadFile(pr.procPath(pid, "comm"))
+	if err != nil {
+		return fmt.Sprintf("pid-%d", pid)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (pr *ProcReader) cmdline(pid uint32) string {
+	data, err := os.ReadFile(pr.procPath(pid, "cmdline"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes.ReplaceAll(data, []byte{0}, []byte{' '})))
+}
+
+// uid returns the real UID from /proc/<pid>/status.
+func (pr *ProcReader) uid(pid uint32) (int, bool) {
+	f, err := os.Open(pr.procPath(pid, "status"))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Uid:	real	effective	saved	filesystem
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "Uid:" {
+			uid, err := strconv.Atoi(fields[1])
+			return uid, err == nil
+		}
+	}
+	return 0, false
+}
+
+// environ returns the environment of a process, or nil if it cannot be read
+// (reading another user's environment requires privileges).
+func (pr *ProcReader) environ(pid uint32) map[string]string {
+	data, err := os.ReadFile(pr.procPath(pid, "environ"))
+	if err != nil {
+		return nil
+	}
+	env := make(map[string]string)
+	for _, kv := range bytes.Split(data, []byte{0}) {
+		if k, v, ok := strings.Cut(string(kv), "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// cgroup returns the process's cgroup path: the unified (v2) hierarchy if
+// present, otherwise the v1 memory controller's, otherwise the first listed.
+func (pr *ProcReader) cgroup(pid uint32) string {
+	data, err := os.ReadFile(pr.procPath(pid, "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	var first, memory string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// hierarchy-ID:controller-list:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+		if first == "" {
+			first = parts[2]
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			if c == "memory" {
+				memory = parts[2]
+			}
+		}
+	}
+	if memory != "" {
+		return memory
+	}
+	return first
+}
+
+// userName resolves a UID from <root>/etc/passwd, falling back to the number.
+func (pr *ProcReader) userName(uid int) string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	name, ok := pr.users[uid]
+	if !ok && time.Since(pr.usersRead) > usersRefresh {
+		pr.users = readPasswd(filepath.Join(pr.root, "etc", "passwd"))
+		pr.usersRead = time.Now()
+		name, ok = pr.users[uid]
+	}
+	if !ok {
+		return strconv.Itoa(uid)
+	}
+	return name
+}
+
+func readPasswd(path string) map[int]string {
+	users := make(map[int]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return users
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if uid, err := strconv.Atoi(fields[2]); err == nil {
+			users[uid] = fields[0]
+		}
+	}
+	return users
+}
+
+// cgroupRef is what a cgroup path reveals about the process's container.
+type cgroupRef struct {
+	id         string // 64-hex container ID
+	kubernetes bool   // cgroup lives under a kubepods hierarchy
+}
+
+var containerIDRe = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// parseCgroup extracts the container ID from cgroup paths written by Docker
+// (/docker/<id>, docker-<id>.scope), containerd (cri-containerd-<id>.scope)
+// and CRI-O (crio-<id>.scope), including their Kubernetes pod hierarchies
+// (/kubepods/burstable/pod<uid>/<id>, kubepods-besteffort-pod<uid>.slice/...).
+func parseCgroup(path string) cgroupRef {
+	ids := containerIDRe.FindAllString(path, -1)
+	ref := cgroupRef{kubernetes: strings.Contains(path, "kubepods")}
+	if len(ids) > 0 {
+		ref.id = ids[len(ids)-1] // the innermost one, past any sandbox ID
+	}
+	return ref
+}
+
+// containerMeta looks up a container in the runtime state directories.
+func (pr *ProcReader) containerMeta(id string) containerMeta {
+	pr.mu.Lock()
+	meta, ok := pr.containers[id]
+	missed, recent := pr.misses[id]
+	recent = recent && time.Since(missed) < containerRetry
+	pr.mu.Unlock()
+	if ok || recent {
+		return meta
+	}
+
+	meta = pr.readContainerMeta(id)
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if meta == (containerMeta{}) {
+		if len(pr.misses) >= maxContainerCache {
+			pr.misses = make(map[string]time.Time)
+		}
+		pr.misses[id] = time.Now()
+		return meta
+	}
+	if len(pr.containers) >= maxContainerCache {
+		pr.containers = make(map[string]containerMeta)
+	}
+	pr.containers[id] = meta
+	delete(pr.misses, id)
+	return meta
+}
+
+// runtimeConfig covers the fields used from Docker's config.v2.json and the
+// OCI config.json written by containerd and CRI-O.
+type runtimeConfig struct {
+	Name   string `json:"Name"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// containerStatePaths are the per-container config files of known runtimes,
+// relative to the host root.
+var containerStatePaths = []string{
+	"var/lib/docker/containers/%s/config.v2.json",
+	"run/containerd/io.containerd.runtime.v2.task/k8s.io/%s/config.json",
+	"run/containerd/io.containerd.runtime.v2.task/moby/%s/config.json",
+	"run/containers/storage/overlay-containers/%s/userdata/config.json",
+}
+
+func (pr *ProcReader) readContainerMeta(id string) containerMeta {
+	for _, p := range containerStatePaths {
+		data, err := os.ReadFile(filepath.Join(pr.root, fmt.Sprintf(p, id)))
+		if err != nil {
+			continue
+		}
+		var cfg runtimeConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			continue
+		}
+
+		// Kubernetes metadata: dockershim labels, containerd CRI and CRI-O annotations
+		get := func(keys ...string) string {
+			for _, k := range keys {
+				if v := cfg.Config.Labels[k]; v != "" {
+					return v
+				}
+				if v := cfg.Annotations[k]; v != "" {
+					return v
+				}
+			}
+			return ""
+		}
+		meta := containerMeta{
+			name:      get("io.kubernetes.container.name", "io.kubernetes.cri.container-name"),
+			namespace: get("io.kubernetes.pod.namespace", "io.kubernetes.cri.sandbox-namespace"),
+			pod:       get("io.kubernetes.pod.name", "io.kubernetes.cri.sandbox-name"),
+		}
+		if meta.name == "" {
+			meta.name = strings.TrimPrefix(cfg.Name, "/")
+		}
+		return meta
+	}
+	return containerMeta{}
+}
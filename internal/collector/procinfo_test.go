@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testContainerID = "3f4e2a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+	testSandboxID   = "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b"
+	testPodUID      = "6f0b9c3e-2d1a-4b5c-8e7f-1a2b3c4d5e6f"
+)
+
+// fakeRoot builds a host root from a map of relative paths to contents.
+func fakeRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// procFiles returns the procfs files of a process.
+func procFiles(pid, comm, uid, cgroup, environ string) map[string]string {
+	dir := "proc/" + pid + "/"
+	files := map[string]string{
+		dir + "comm":    comm + "\n",
+		dir + "cmdline": comm + "\x00--epochs\x0010\x00",
+		dir + "status":  "Name:\t" + comm + "\nUmask:\t0022\nState:\tS (sleeping)\nUid:\t" + uid + "\t" + uid + "\t" + uid + "\t" + uid + "\nGid:\t100\t100\t100\t100\n",
+		dir + "cgroup":  cgroup,
+	}
+	if environ != "" {
+		files[dir+"environ"] = environ
+	}
+	return files
+}
+
+func merge(maps ...map[string]string) map[string]string {
+	out := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+const testPasswd = "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000:Alice:/home/alice:/bin/bash\n"
+
+func TestEnrich(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		pid   uint32
+		want  GPUProcess
+	}{
+		{
+			name: "uid to user name",
+			files: merge(
+				map[string]string{"etc/passwd": testPasswd},
+				procFiles("100", "python", "1000", "0::/user.slice/user-1000.slice/session-3.scope\n", ""),
+			),
+			pid:  100,
+			want: GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 1000, User: "alice", Cgroup: "/user.slice/user-1000.slice/session-3.scope"},
+		},
+		{
+			name:  "unknown uid falls back to the number",
+			files: merge(map[string]string{"etc/passwd": testPasswd}, procFiles("101", "python", "4242", "0::/\n", "")),
+			pid:   101,
+			want:  GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 4242, User: "4242", Cgroup: "/"},
+		},
+		{
+			name: "docker cgroup v1",
+			files: merge(
+				map[string]string{"var/lib/docker/containers/" + testContainerID + "/config.v2.json": `{"Name":"/trainer","Config":{"Labels":{}}}`},
+				procFiles("102", "python", "0", "12:pids:/docker/"+testContainerID+"\n9:memory:/docker/"+testContainerID+"\n1:name=systemd:/docker/"+testContainerID+"\n", ""),
+			),
+			pid: 102,
+			want: GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 0, User: "0",
+				Cgroup: "/docker/" + testContainerID, ContainerID: testContainerID, ContainerName: "trainer"},
+		},
+		{
+			name: "docker cgroup v2 with systemd driver",
+			files: merge(
+				map[string]string{"var/lib/docker/containers/" + testContainerID + "/config.v2.json": `{"Name":"/infer"}`},
+				procFiles("103", "tritonserver", "0", "0::/system.slice/docker-"+testContainerID+".scope\n", ""),
+			),
+			pid: 103,
+			want: GPUProcess{Name: "tritonserver", Cmdline: "tritonserver --epochs 10", UID: 0, User: "0",
+				Cgroup: "/system.slice/docker-" + testContainerID + ".scope", ContainerID: testContainerID, ContainerName: "infer"},
+		},
+		{
+			name: "containerd kubepods cgroup v2",
+			files: merge(
+				map[string]string{"run/containerd/io.containerd.runtime.v2.task/k8s.io/" + testContainerID + "/config.json": `{"annotations":{
+					"io.kubernetes.cri.container-name":"worker",
+					"io.kubernetes.cri.sandbox-namespace":"ml",
+					"io.kubernetes.cri.sandbox-name":"train-0"}}`},
+				procFiles("104", "python", "1000", "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod"+
+					strings.ReplaceAll(testPodUID, "-", "_")+".slice/cri-containerd-"+testContainerID+".scope\n", ""),
+			),
+			pid: 104,
+			want: GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 1000, User: "1000",
+				Cgroup:      "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" + strings.ReplaceAll(testPodUID, "-", "_") + ".slice/cri-containerd-" + testContainerID + ".scope",
+				ContainerID: testContainerID, ContainerName: "worker", Namespace: "ml", Pod: "train-0"},
+		},
+		{
+			name: "kubepods cgroup v1 without runtime metadata uses the pod hostname",
+			files: procFiles("105", "python", "1000", "11:memory:/kubepods/burstable/pod"+testPodUID+"/"+testContainerID+"\n",
+				"PATH=/usr/bin\x00HOSTNAME=train-1\x00"),
+			pid: 105,
+			want: GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 1000, User: "1000",
+				Cgroup: "/kubepods/burstable/pod" + testPodUID + "/" + testContainerID, ContainerID: testContainerID, Pod: "train-1"},
+		},
+		{
+			name: "slurm job from environment",
+			files: merge(
+				map[string]string{"etc/passwd": testPasswd},
+				procFiles("106", "python", "1000", "0::/system.slice/slurmstepd.scope/job_77/step_0/user/task_0\n",
+					"SLURM_JOB_ID=78\x00SLURM_JOB_USER=bob\x00SLURM_JOB_NAME=sweep\x00"),
+			),
+			pid: 106,
+			want: GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 1000, User: "alice",
+				Cgroup: "/system.slice/slurmstepd.scope/job_77/step_0/user/task_0", JobID: "78", JobUser: "bob", JobName: "sweep"},
+		},
+		{
+			name: "slurm job from cgroup",
+			files: merge(
+				map[string]string{"etc/passwd": testPasswd},
+				procFiles("107", "python", "1000", "4:memory:/slurm/uid_1000/job_1234/step_0/task_0\n", ""),
+			),
+			pid: 107,
+			want: GPUProcess{Name: "python", Cmdline: "python --epochs 10", UID: 1000, User: "alice",
+				Cgroup: "/slurm/uid_1000/job_1234/step_0/task_0", JobID: "1234", JobUser: "alice"},
+		},
+		{
+			name:  "missing pid",
+			files: map[string]string{"etc/passwd": testPasswd},
+			pid:   200,
+			want:  GPUProcess{Name: "pid-200", UID: -1},
+		},
+		{
+			name:  "process exited while being read",
+			files: map[string]string{"proc/201/comm": "python\n"},
+			pid:   201,
+			want:  GPUProcess{Name: "python", UID: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := NewProcReader(fakeRoot(t, tt.files))
+			got := GPUProcess{PID: tt.pid}
+			pr.Enrich(&got)
+			tt.want.PID = tt.pid
+			if got != tt.want {
+				t.Errorf("Enrich:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	tests := []struct {
+		path string
+		want cgroupRef
+	}{
+		{"/", cgroupRef{}},
+		{"/user.slice/user-1000.slice/session-3.scope", cgroupRef{}},
+		{"/docker/" + testContainerID, cgroupRef{id: testContainerID}},
+		{"/system.slice/docker-" + testContainerID + ".scope", cgroupRef{id: testContainerID}},
+		{"/system.slice/crio-" + testContainerID + ".scope", cgroupRef{id: testContainerID}},
+		{"/kubepods/besteffort/pod" + testPodUID + "/" + testContainerID, cgroupRef{id: testContainerID, kubernetes: true}},
+		// The pod UID is not mistaken for a container ID
+		{"/kubepods/burstable/pod" + testPodUID, cgroupRef{kubernetes: true}},
+		// The container, not the sandbox it runs in
+		{"/kubepods/pod" + testPodUID + "/" + testSandboxID + "/" + testContainerID, cgroupRef{id: testContainerID, kubernetes: true}},
+	}
+	for _, tt := range tests {
+		if got := parseCgroup(tt.path); got != tt.want {
+			t.Errorf("parseCgroup(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProcReaderCachesContainers(t *testing.T) {
+	root := fakeRoot(t, map[string]string{
+		"var/lib/docker/containers/" + testContainerID + "/config.v2.json": `{"Name":"/trainer"}`,
+	})
+	pr := NewProcReader(root)
+	if got := pr.containerMeta(testContainerID).name; got != "trainer" {
+		t.Fatalf("name = %q, want trainer", got)
+	}
+	// The container is gone from the runtime, but its metadata is cached
+	if err := os.RemoveAll(filepath.Join(root, "var")); err != nil {
+		t.Fatal(err)
+	}
+	if got := pr.containerMeta(testContainerID).name; got != "trainer" {
+		t.Errorf("cached name = %q, want trainer", got)
+	}
+}
+
+func TestProcReaderRetriesMissingContainers(t *testing.T) {
+	root := t.TempDir()
+	pr := NewProcReader(root)
+	if got := pr.containerMeta(testContainerID); got != (containerMeta{}) {
+		t.Fatalf("meta = %+v before the runtime wrote it", got)
+	}
+
+	// The runtime writes its config: the miss is remembered for a while,
+	// then looked up again
+	path := filepath.Join(root, "var/lib/docker/containers", testContainerID, "config.v2.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"Name":"/trainer"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := pr.containerMeta(testContainerID).name; got != "" {
+		t.Errorf("name = %q right after a miss, want it not looked up yet", got)
+	}
+	pr.misses[testContainerID] = time.Now().Add(-containerRetry)
+	if got := pr.containerMeta(testContainerID).name; got != "trainer" {
+		t.Errorf("name = %q after the retry interval, want trainer", got)
+	}
+	if _, ok := pr.misses[testContainerID]; ok {
+		t.Error("miss still recorded after the container was found")
+	}
+}
@@ -0,0 +1,503 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// simModel describes the static characteristics of a simulated GPU model.
+type simModel struct {
+	name      string
+	memTotal  uint64  // MiB
+	powerIdle float64 // W
+	powerMax  float64 // W
+	clockGfx  int     // max graphics clock, MHz
+	clockMem  int     // MHz
+	tempIdle  float64 // °C at idle
+	tempLoad  float64 // °C at sustained full load
+	ecc       bool
+	nvlinks   int  // links per GPU, all attached to NVSwitches
+	mig       bool // supports the simMIGLayout partitioning
+}
+
+var simModels = []simModel{
+	{name: "NVIDIA A100-SXM4-80GB", memTotal: 81920, powerIdle: 60, powerMax: 400, clockGfx: 1410, clockMem: 1593, tempIdle: 32, tempLoad: 68, ecc: true, nvlinks: 12, mig: true},
+	{name: "NVIDIA H100 80GB HBM3", memTotal: 81559, powerIdle: 70, powerMax: 700, clockGfx: 1980, clockMem: 2619, tempIdle: 30, tempLoad: 72, ecc: true, nvlinks: 18, mig: true},
+	{name: "NVIDIA GeForce RTX 4090", memTotal: 24564, powerIdle: 20, powerMax: 450, clockGfx: 2520, clockMem: 10501, tempIdle: 38, tempLoad: 80},
+	{name: "NVIDIA L40S", memTotal: 46068, powerIdle: 35, powerMax: 350, clockGfx: 2520, clockMem: 9001, tempIdle: 34, tempLoad: 75, ecc: true},
+}
+
+// simMIGProfile is one MIG instance of a simulated partitioned GPU.
+type simMIGProfile struct {
+	name     string
+	gi       int    // GPU instance ID
+	slices   int    // compute slices out of 7
+	memTotal uint64 // MiB
+}
+
+// simMIGLayout is a common mixed partitioning of an 80GB A100/H100.
+var simMIGLayout = []simMIGProfile{
+	{name: "3g.40gb", gi: 2, slices: 3, memTotal: 40192},
+	{name: "2g.20gb", gi: 3, slices: 2, memTotal: 19968},
+	{name: "1g.10gb", gi: 9, slices: 1, memTotal: 9728},
+	{name: "1g.10gb", gi: 10, slices: 1, memTotal: 9728},
+}
+
+// simMIG is a simulated MIG instance with its own workload.
+type simMIG struct {
+	id      int // MIGDeviceID
+	parent  int
+	profile simMIGProfile
+	state   simGPU
+}
+
+// simSwitches are the PCI bus IDs of the simulated NVSwitches.
+var simSwitches = []string{"00000000:C1:00.0", "00000000:C2:00.0", "00000000:C3:00.0", "00000000:C4:00.0"}
+
+var simProcNames = []string{"python", "python3", "torchrun", "tritonserver", "ollama"}
+
+// simTenant is a team the simulator attributes processes to. Tenants with a
+// namespace run in Kubernetes pods, the others as Slurm jobs on the host.
+type simTenant struct {
+	user      string
+	uid       int
+	namespace string
+}
+
+var simTenants = []simTenant{
+	{user: "mlops", uid: 1001, namespace: "ml-research"},
+	{user: "triton", uid: 2000, namespace: "inference"},
+	{user: "nlp", uid: 1002, namespace: "nlp-team"},
+	{user: "jdoe", uid: 1003},
+	{user: "asmith", uid: 1004},
+}
+
+// simGPU is the evolving state of one simulated GPU.
+type simGPU struct {
+	util      float64
+	target    float64 // utilization the current workload phase settles at
+	phaseLeft float64 // seconds until the workload changes
+	temp      float64
+	memUsed   float64 // MiB
+	memTarget float64
+	pid       uint32
+	proc      GPUProcess // identity and attribution of the running process
+	eccCorr   uint64     // correctable ECC errors so far
+	nvlinkCRC uint64     // NVLink CRC errors so far (all links)
+}
+
+// SimGPUSource is a GPUSource that generates synthetic but plausible metrics
+// for a configurable number of GPUs. Workloads alternate between idle,
+// training and bursty inference phases; power follows utilization and
+// temperature lags behind power. Output is reproducible for a given seed.
+type SimGPUSource struct {
+	mu    sync.Mutex
+	rng   *rand.Rand
+	model simModel
+	gpus  []simGPU
+	info  []GPUDevice
+	last  time.Time
+
+	migs     []simMIG
+	lastJob  GPUProcess // most recent Slurm job, which new processes may join
+	downLink [2]int     // (gpu, link) of a degraded NVLink, or (-1, -1)
+}
+
+// NewSimGPUSource creates a simulated backend with count GPUs of a single
+// model. The first migGPUs GPUs are partitioned into MIG instances when the
+// model supports MIG; each instance then runs its own workload.
+func NewSimGPUSource(count int, seed int64, migGPUs int) *SimGPUSource {
+	rng := rand.New(rand.NewSource(seed))
+	model := simModels[rng.Intn(len(simModels))]
+
+	s := &SimGPUSource{
+		rng:   rng,
+		model: model,
+		gpus:  make([]simGPU, count),
+		info:  make([]GPUDevice, count),
+	}
+
+	for i := 0; i < count; i++ {
+		s.info[i] = GPUDevice{
+			ID:        i,
+			UUID:      s.uuid(),
+			Name:      model.name,
+			MemTotal:  model.memTotal,
+			DriverVer: "550.54.15-sim",
+			ParentID:  -1,
+
+			ClockGfxMax:  model.clockGfx,
+			ClockMemMax:  model.clockMem,
+			TempSlowdown: int(model.tempLoad) + 15,
+			TempShutdown: int(model.tempLoad) + 25,
+		}
+		s.gpus[i] = simGPU{temp: model.tempIdle}
+		s.nextPhase(&s.gpus[i], float64(model.memTotal))
+	}
+
+	if model.mig {
+		for i := 0; i < migGPUs && i < count; i++ {
+			s.partition(i)
+		}
+	}
+
+	// Some seeds get a node with one degraded NVLink to diagnose
+	s.downLink = [2]int{-1, -1}
+	if model.nvlinks > 0 && count > 0 && rng.Float64() < 0.25 {
+		s.downLink = [2]int{rng.Intn(count), rng.Intn(model.nvlinks)}
+	}
+
+	return s
+}
+
+// Devices returns static device info.
+func (s *SimGPUSource) Devices() []GPUDevice {
+	return s.info
+}
+
+// Collect advances the simulation to now and returns the current metrics.
+func (s *SimGPUSource) Collect() []GPUMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	dt := 1.0
+	if !s.last.IsZero() {
+		dt = math.Min(math.Max(now.Sub(s.last).Seconds(), 0), 60)
+	}
+	s.last = now
+
+	m := s.model
+	for i := range s.migs {
+		s.step(&s.migs[i].state, dt, float64(s.migs[i].profile.memTotal))
+	}
+
+	metrics := make([]GPUMetrics, len(s.gpus))
+	for i := range s.gpus {
+		g := &s.gpus[i]
+		if s.info[i].MIGEnabled {
+			// A partitioned GPU runs whatever its instances run
+			s.aggregateMIG(i, g)
+			s.stepThermal(g, dt)
+		} else {
+			s.step(g, dt, float64(m.memTotal))
+		}
+
+		load := g.util / 100
+		power := m.powerIdle + (m.powerMax-m.powerIdle)*math.Pow(load, 0.9) + s.rng.NormFloat64()*2
+		power = clamp(power, m.powerIdle*0.8, m.powerMax)
+
+		clockGfx := 210
+		pstate := 8
+		throttle := ThrottleGPUIdle
+		if g.util > 5 {
+			clockGfx = m.clockGfx - s.rng.Intn(60)
+			pstate = 0
+			throttle = 0
+			// Shed clocks as the card approaches its thermal ceiling
+			if g.temp > m.tempLoad-3 {
+				clockGfx -= int((g.temp - (m.tempLoad - 3)) * 40)
+				throttle |= ThrottleSWThermal
+			}
+			if power >= m.powerMax*0.97 {
+				throttle |= ThrottleSWPowerCap
+			}
+		}
+
+		metrics[i] = GPUMetrics{
+			Timestamp:   now.Unix(),
+			GPUID:       i,
+			GPUUtil:     math.Round(g.util),
+			MemUtil:     math.Round(g.util * 0.6),
+			MemUsed:     uint64(g.memUsed),
+			Temperature: int(math.Round(g.temp)),
+			FanSpeed:    int(clamp(30+(g.temp-40)*1.8, 30, 100)),
+			PowerDraw:   math.Round(power*10) / 10,
+			PowerLimit:  m.powerMax,
+			ClockGfx:    clockGfx,
+			ClockMem:    m.clockMem,
+			PCIeTx:      int(load * 12000 * (0.5 + s.rng.Float64())),
+			PCIeRx:      int(load * 24000 * (0.5 + s.rng.Float64())),
+			PState:      pstate,
+
+			ThrottleReasons: throttle,
+			ClockGfxApp:     m.clockGfx,
+			ClockMemApp:     m.clockMem,
+		}
+		if m.ecc {
+			// Rare correctable errors, more likely under load
+			if s.rng.Float64() < 0.0005*dt*(1+load) {
+				g.eccCorr++
+			}
+			metrics[i].ECCEnabled = true
+			metrics[i].ECCCorrVolatile = g.eccCorr
+			metrics[i].ECCCorrAggregate = g.eccCorr
+		}
+	}
+
+	for _, mi := range s.migs {
+		metrics = append(metrics, GPUMetrics{
+			Timestamp: now.Unix(),
+			GPUID:     mi.id,
+			MemUsed:   uint64(mi.state.memUsed),
+		})
+	}
+
+	return metrics
+}
+
+// CollectProcesses returns the simulated process holding each GPU, if any.
+func (s *SimGPUSource) CollectProcesses() []GPUProcess {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	var procs []GPUProcess
+	for i, g := range s.gpus {
+		if g.pid == 0 {
+			continue
+		}
+		p := g.proc
+		p.Timestamp, p.GPUID, p.GPUMem = now, i, uint64(g.memUsed)
+		p.SMUtil, p.MemUtil = math.Round(g.util), math.Round(g.util*0.6)
+		procs = append(procs, p)
+	}
+	for _, mi := range s.migs {
+		if mi.state.pid == 0 {
+			continue
+		}
+		p := mi.state.proc
+		p.Timestamp, p.GPUID, p.GPUMem = now, mi.id, uint64(mi.state.memUsed)
+		procs = append(procs, p)
+	}
+	return procs
+}
+
+// CollectNVLink reports the simulated NVSwitch links of each GPU. Traffic
+// follows utilization; links are spread round-robin over four switches.
+func (s *SimGPUSource) CollectNVLink() []NVLinkMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	var links []NVLinkMetrics
+	for i := range s.gpus {
+		g := &s.gpus[i]
+		if s.rng.Float64() < 0.01 {
+			g.nvlinkCRC++
+		}
+		for l := 0; l < s.model.nvlinks; l++ {
+			m := NVLinkMetrics{
+				Timestamp:   now,
+				GPUID:       i,
+				Link:        l,
+				Active:      s.downLink != [2]int{i, l},
+				RemoteType:  "switch",
+				RemotePCI:   simSwitches[l%len(simSwitches)],
+				RemoteGPUID: -1,
+			}
+			if m.Active {
+				// ~25 GB/s per link at full load
+				m.TxKBps = uint64(g.util / 100 * 24e6 * (0.8 + 0.4*s.rng.Float64()))
+				m.RxKBps = uint64(g.util / 100 * 24e6 * (0.8 + 0.4*s.rng.Float64()))
+			}
+			if l == 0 {
+				m.CRCErrors = g.nvlinkCRC
+			}
+			links = append(links, m)
+		}
+	}
+	return links
+}
+
+// simXIDs are the XID codes the simulator occasionally raises.
+var simXIDs = []uint64{13, 31, 43, 48, 63, 79, 94}
+
+// WatchEvents emits rare synthetic XID events (about one per GPU per two hours).
+func (s *SimGPUSource) WatchEvents(ctx context.Context) <-chan GPUEvent {
+	ch := make(chan GPUEvent)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			var events []GPUEvent
+			s.mu.Lock()
+			for i := range s.gpus {
+				if s.rng.Float64() < 1.0/7200 {
+					xid := simXIDs[s.rng.Intn(len(simXIDs))]
+					events = append(events, GPUEvent{
+						Timestamp:   time.Now().Unix(),
+						GPUID:       i,
+						Type:        "xid",
+						XID:         xid,
+						Description: XIDDescription(xid),
+					})
+				}
+			}
+			s.mu.Unlock()
+
+			for _, ev := range events {
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// Shutdown is a no-op for the simulated backend.
+func (s *SimGPUSource) Shutdown() {}
+
+// step advances a GPU's (or MIG instance's) state by dt seconds.
+// mem is the memory available to its workloads, MiB.
+func (s *SimGPUSource) step(g *simGPU, dt, mem float64) {
+	g.phaseLeft -= dt
+	if g.phaseLeft <= 0 {
+		s.nextPhase(g, mem)
+	}
+
+	// First-order lag towards the workload target, plus jitter
+	g.util += (g.target - g.util) * (1 - math.Exp(-dt/3))
+	g.util = clamp(g.util+s.rng.NormFloat64()*2, 0, 100)
+	g.memUsed += (g.memTarget - g.memUsed) * (1 - math.Exp(-dt/5))
+
+	s.stepThermal(g, dt)
+}
+
+// stepThermal moves the temperature towards the steady state for the current load.
+func (s *SimGPUSource) stepThermal(g *simGPU, dt float64) {
+	m := s.model
+	tempTarget := m.tempIdle + (m.tempLoad-m.tempIdle)*g.util/100
+	g.temp += (tempTarget - g.temp) * (1 - math.Exp(-dt/40))
+}
+
+// partition switches a GPU to MIG mode with the simMIGLayout instances.
+func (s *SimGPUSource) partition(parent int) {
+	s.info[parent].MIGEnabled = true
+	s.gpus[parent].pid = 0
+	s.gpus[parent].memTarget = 0
+
+	for _, p := range simMIGLayout {
+		mi := simMIG{id: MIGDeviceID(parent, p.gi, 0), parent: parent, profile: p}
+		s.nextPhase(&mi.state, float64(p.memTotal))
+		s.migs = append(s.migs, mi)
+		s.info = append(s.info, GPUDevice{
+			ID:         mi.id,
+			UUID:       "MIG-" + s.uuid()[len("GPU-"):],
+			Name:       s.model.name + " MIG " + p.name,
+			MemTotal:   p.memTotal,
+			DriverVer:  s.info[parent].DriverVer,
+			ParentUUID: s.info[parent].UUID,
+			ParentID:   parent,
+			MIGProfile: p.name,
+			GIID:       p.gi,
+		})
+	}
+}
+
+// aggregateMIG sets a partitioned GPU's load to the slice-weighted sum of its
+// instances' utilization and its memory to the sum of theirs.
+func (s *SimGPUSource) aggregateMIG(parent int, g *simGPU) {
+	g.util, g.memUsed = 0, 0
+	for _, mi := range s.migs {
+		if mi.parent != parent {
+			continue
+		}
+		g.util += mi.state.util * float64(mi.profile.slices) / 7
+		g.memUsed += mi.state.memUsed
+	}
+}
+
+// nextPhase picks the next workload for a GPU with mem MiB available.
+func (s *SimGPUSource) nextPhase(g *simGPU, mem float64) {
+	g.phaseLeft = 30 + s.rng.Float64()*570
+
+	switch r := s.rng.Float64(); {
+	case r < 0.35: // idle; sometimes a forgotten process keeps its memory
+		g.target = s.rng.Float64() * 3
+		if g.pid == 0 || s.rng.Float64() < 0.7 {
+			g.pid = 0
+			g.memTarget = 0
+		}
+	case r < 0.8: // training
+		g.target = 85 + s.rng.Float64()*15
+		g.memTarget = mem * (0.6 + s.rng.Float64()*0.35)
+		s.newProcess(g)
+	default: // bursty inference
+		g.target = 20 + s.rng.Float64()*50
+		g.memTarget = mem * (0.2 + s.rng.Float64()*0.3)
+		s.newProcess(g)
+	}
+}
+
+func (s *SimGPUSource) newProcess(g *simGPU) {
+	g.pid = uint32(1000 + s.rng.Intn(60000))
+	name := simProcNames[s.rng.Intn(len(simProcNames))]
+	t := simTenants[s.rng.Intn(len(simTenants))]
+
+	g.proc = GPUProcess{
+		PID:     g.pid,
+		Name:    name,
+		Cmdline: name + " serve.py --port 8000",
+		UID:     t.uid,
+		User:    t.user,
+	}
+	if name == "python" || name == "python3" || name == "torchrun" {
+		g.proc.Cmdline = name + " train.py --config config.yaml"
+	}
+	if t.namespace != "" {
+		b := make([]byte, 32)
+		s.rng.Read(b)
+		id := fmt.Sprintf("%x", b)
+		podUID := s.uuid()[len("GPU-"):]
+		g.proc.Cgroup = fmt.Sprintf("/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod%s.slice/cri-containerd-%s.scope",
+			strings.ReplaceAll(podUID, "-", "_"), id)
+		g.proc.ContainerID = id
+		g.proc.ContainerName = name
+		g.proc.Namespace = t.namespace
+		g.proc.Pod = fmt.Sprintf("%s-%s-%x", t.namespace, name, b[:3])
+		return
+	}
+
+	// Slurm: half of the new processes join the previous job (multi-GPU jobs)
+	if s.lastJob.JobID == "" || s.rng.Float64() < 0.5 {
+		id := 40000 + s.rng.Intn(10000)
+		s.lastJob = GPUProcess{
+			UID:     t.uid,
+			User:    t.user,
+			JobID:   fmt.Sprint(id),
+			JobUser: t.user,
+			JobName: []string{"llama-ft", "resnet-sweep", "bert-pretrain", "eval"}[s.rng.Intn(4)],
+			Cgroup:  fmt.Sprintf("/system.slice/slurmstepd.scope/job_%d/step_0/user/task_0", id),
+		}
+	}
+	j := s.lastJob
+	g.proc.UID, g.proc.User, g.proc.Cgroup = j.UID, j.User, j.Cgroup
+	g.proc.JobID, g.proc.JobUser, g.proc.JobName = j.JobID, j.JobUser, j.JobName
+}
+
+func (s *SimGPUSource) uuid() string {
+	b := make([]byte, 16)
+	s.rng.Read(b)
+	return fmt.Sprintf("GPU-%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// A trace is a JSON Lines file of Snapshots. The first line is a header
+// snapshot of type "devices" listing the recorded GPUs; every following line
+// is a snapshot exactly as produced by Collector.Run.
+
+// maxTraceLine bounds a single snapshot line (large process lists on big nodes).
+const maxTraceLine = 16 * 1024 * 1024
+
+// Recorder is a BroadcastSink that appends every snapshot to a trace file.
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	enc    *json.Encoder
+	count  int
+	closed bool
+}
+
+// NewRecorder writes the trace header for devices to w and returns a Recorder.
+func NewRecorder(w io.WriteCloser, devices []GPUDevice) (*Recorder, error) {
+	r := &Recorder{w: w, enc: json.NewEncoder(w)}
+	err := r.enc.Encode(Snapshot{
+		Type:      "devices",
+		Timestamp: time.Now().Unix(),
+		Devices:   devices,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write trace header: %w", err)
+	}
+	return r, nil
+}
+
+// Broadcast implements BroadcastSink. Snapshots after Close are dropped.
+func (r *Recorder) Broadcast(snap Snapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	if err := r.enc.Encode(snap); err != nil {
+		log.Printf("error recording %s: %v", snap.Type, err)
+		return
+	}
+	r.count++
+}
+
+// Count returns the number of snapshots recorded so far.
+func (r *Recorder) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+// Close closes the underlying writer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.w.Close()
+}
+
+// ReplaySource is a GPUSource that plays back a recorded trace. It is a
+// SnapshotSource: the Collector drains its snapshots instead of polling it,
+// so Collect and CollectProcesses return nothing.
+//
+// Snapshots are emitted with the original spacing divided by speed, and their
+// timestamps are rewritten to the wall clock at emission so replayed data
+// looks live to storage, rollups and the UI. Each recorded timestamp gets a
+// later one than the last, so that at speeds above 1 samples do not
+// overwrite each other; replayed data then runs ahead of the clock.
+type ReplaySource struct {
+	path    string
+	speed   float64
+	loop    bool
+	devices []GPUDevice
+	f       *os.File
+
+	lastTs int64 // last timestamp emitted, across passes
+}
+
+// NewReplaySource opens a trace file and reads its device header.
+func NewReplaySource(path string, speed float64, loop bool) (*ReplaySource, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("replay speed must be positive, got %v", speed)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open trace: %w", err)
+	}
+
+	sc := newTraceScanner(f)
+	if !sc.Scan() {
+		f.Close()
+		return nil, fmt.Errorf("read trace header: %v", sc.Err())
+	}
+	var header Snapshot
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil || header.Type != "devices" {
+		f.Close()
+		return nil, fmt.Errorf("%s: missing devices header", path)
+	}
+
+	return &ReplaySource{
+		path:    path,
+		speed:   speed,
+		loop:    loop,
+		devices: header.Devices,
+		f:       f,
+	}, nil
+}
+
+// Devices returns the devices recorded in the trace header.
+func (rs *ReplaySource) Devices() []GPUDevice {
+	return rs.devices
+}
+
+// Collect is unused for replay; snapshots are delivered via Snapshots.
+func (rs *ReplaySource) Collect() []GPUMetrics { return nil }
+
+// CollectProcesses is unused for replay; snapshots are delivered via Snapshots.
+func (rs *ReplaySource) CollectProcesses() []GPUProcess { return nil }
+
+// Shutdown closes the trace file.
+func (rs *ReplaySource) Shutdown() {
+	rs.f.Close()
+}
+
+// Snapshots streams the trace until it ends (or forever when looping) or ctx
+// is cancelled. The channel is closed when playback stops.
+func (rs *ReplaySource) Snapshots(ctx context.Context) <-chan Snapshot {
+	ch := make(chan Snapshot)
+	go func() {
+		defer close(ch)
+		for pass := 1; ; pass++ {
+			n, err := rs.play(ctx, ch)
+			if err != nil {
+				log.Printf("replay %s: %v", rs.path, err)
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("replay %s: pass %d finished (%d snapshots)", rs.path, pass, n)
+			if !rs.loop || n == 0 {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// play emits one pass over the trace, returning the number of snapshots sent.
+func (rs *ReplaySource) play(ctx context.Context, ch chan<- Snapshot) (int, error) {
+	if _, err := rs.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	sc := newTraceScanner(rs.f)
+	sc.Scan() // header
+
+	var start time.Time
+	var firstTs, prevTs int64
+	n := 0
+	for sc.Scan() {
+		var snap Snapshot
+		if err := json.Unmarshal(sc.Bytes(), &snap); err != nil {
+			return n, fmt.Errorf("line %d: %w", n+2, err)
+		}
+
+		if start.IsZero() {
+			start = time.Now()
+			firstTs = snap.Timestamp
+		}
+		offset := time.Duration(float64(snap.Timestamp-firstTs) * float64(time.Second) / rs.speed)
+		due := start.Add(offset)
+
+		select {
+		case <-ctx.Done():
+			return n, nil
+		case <-time.After(time.Until(due)):
+		}
+
+		// Snapshots recorded together keep sharing a timestamp
+		ts := rs.lastTs
+		if n == 0 || snap.Timestamp != prevTs {
+			ts = max(due.Unix(), rs.lastTs+1)
+		}
+		prevTs, rs.lastTs = snap.Timestamp, ts
+		retime(&snap, ts)
+		select {
+		case <-ctx.Done():
+			return n, nil
+		case ch <- snap:
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+// retime rewrites every timestamp in a snapshot to ts.
+func retime(snap *Snapshot, ts int64) {
+	snap.Timestamp = ts
+	for i := range snap.GPUs {
+		snap.GPUs[i].Timestamp = ts
+	}
+	for i := range snap.Processes {
+		snap.Processes[i].Timestamp = ts
+	}
+	for i := range snap.Events {
+		snap.Events[i].Timestamp = ts
+	}
+	for i := range snap.NVLinks {
+		snap.NVLinks[i].Timestamp = ts
+	}
+	if snap.Host != nil {
+		snap.Host.Timestamp = ts
+	}
+}
+
+func newTraceScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxTraceLine)
+	return sc
+}
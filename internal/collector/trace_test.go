@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTrace records snapshots of GPU metrics and host metrics for each of
+// the given timestamps.
+func writeTrace(t *testing.T, timestamps ...int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewRecorder(f, []GPUDevice{{ID: 0, UUID: "GPU-0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range timestamps {
+		rec.Broadcast(Snapshot{Type: "gpu_metrics", Timestamp: ts, GPUs: []GPUMetrics{{Timestamp: ts, GPUID: 0}}})
+		rec.Broadcast(Snapshot{Type: "host_metrics", Timestamp: ts, Host: &HostMetrics{Timestamp: ts}})
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplayKeepsSamplesApart(t *testing.T) {
+	// At 1000x the whole trace plays within a second of wall clock
+	rs, err := NewReplaySource(writeTrace(t, 100, 101, 102, 105), 1000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := rs.Snapshots(ctx)
+
+	// Two passes: the second continues after the first
+	var last int64
+	for i := 0; i < 8; i++ {
+		gpu, host := <-ch, <-ch
+		if gpu.Type != "gpu_metrics" || host.Type != "host_metrics" {
+			t.Fatalf("tick %d: got %s and %s", i, gpu.Type, host.Type)
+		}
+		ts := gpu.Timestamp
+		if ts <= last {
+			t.Errorf("tick %d: timestamp %d after %d", i, ts, last)
+		}
+		if gpu.GPUs[0].Timestamp != ts || host.Timestamp != ts || host.Host.Timestamp != ts {
+			t.Errorf("tick %d: recorded together, but stamped %d, %d and %d",
+				i, gpu.GPUs[0].Timestamp, host.Timestamp, host.Host.Timestamp)
+		}
+		last = ts
+	}
+}
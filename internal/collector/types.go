@@ -1,5 +1,12 @@
 package collector
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
 // GPUDevice holds static GPU info discovered at startup.
 type GPUDevice struct {
 	NodeID    string `json:"node_id"`
@@ -8,6 +15,47 @@ type GPUDevice struct {
 	Name      string `json:"name"`
 	MemTotal  uint64 `json:"mem_total"` // MiB
 	DriverVer string `json:"driver_ver"`
+
+	ClockGfxMax  int `json:"clock_gfx_max"` // MHz
+	ClockMemMax  int `json:"clock_mem_max"` // MHz
+	TempSlowdown int `json:"temp_slowdown"` // °C at which the GPU starts throttling
+	TempShutdown int `json:"temp_shutdown"` // °C at which the GPU shuts down
+
+	// MIG: a physical GPU in MIG mode is partitioned into instances, which are
+	// reported as child devices with their own ID (see MIGDeviceID) and UUID.
+	MIGEnabled bool   `json:"mig_enabled"`                   // physical GPU is in MIG mode
+	ParentUUID string `json:"parent_uuid,omitempty"`         // set for MIG instances only
+	ParentID   int    `json:"parent_id"`                     // physical GPU index of a MIG instance, -1 for physical GPUs
+	MIGProfile string `json:"mig_profile,omitempty"`         // e.g. "3g.40gb"
+	GIID       int    `json:"gpu_instance_id,omitempty"`     // MIG GPU instance ID
+	CIID       int    `json:"compute_instance_id,omitempty"` // MIG compute instance ID
+}
+
+// IsMIG reports whether the device is a MIG instance rather than a physical GPU.
+func (d GPUDevice) IsMIG() bool {
+	return d.ParentUUID != ""
+}
+
+// DeviceHash fingerprints a node's GPU inventory (IDs, UUIDs, models, memory,
+// driver version and MIG layout), so the hub notices GPU swaps, driver
+// upgrades and repartitioning.
+func DeviceHash(devices []GPUDevice) string {
+	ds := append([]GPUDevice(nil), devices...)
+	sort.Slice(ds, func(i, j int) bool { return ds[i].ID < ds[j].ID })
+
+	h := sha256.New()
+	for _, d := range ds {
+		fmt.Fprintf(h, "%d|%s|%s|%d|%s|%s\n", d.ID, d.UUID, d.Name, d.MemTotal, d.DriverVer, d.MIGProfile)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// MIGDeviceID returns the device ID of a MIG instance: (parent+1)*1000 +
+// GI*10 + CI, e.g. 1013 for GPU 0, GPU instance 1, compute instance 3. The
+// ID only depends on the instance placement, so it is stable across restarts
+// and never collides with physical GPU indices.
+func MIGDeviceID(parent, gi, ci int) int {
+	return (parent+1)*1000 + gi*10 + ci
 }
 
 // GPUMetrics holds a single snapshot of GPU metrics.
@@ -22,13 +70,69 @@ type GPUMetrics struct {
 	FanSpeed    int     `json:"fan_speed"`
 	PowerDraw   float64 `json:"power_draw"` // W
 	PowerLimit  float64 `json:"power_limit"`
-	ClockGfx    int     `json:"clock_gfx"`  // MHz
-	ClockMem    int     `json:"clock_mem"`   // MHz
-	PCIeTx      int     `json:"pcie_tx"`     // KB/s
-	PCIeRx      int     `json:"pcie_rx"`     // KB/s
+	ClockGfx    int     `json:"clock_gfx"` // MHz
+	ClockMem    int     `json:"clock_mem"` // MHz
+	PCIeTx      int     `json:"pcie_tx"`   // KB/s
+	PCIeRx      int     `json:"pcie_rx"`   // KB/s
 	PState      int     `json:"pstate"`
 	EncoderUtil float64 `json:"encoder_util"`
 	DecoderUtil float64 `json:"decoder_util"`
+
+	ThrottleReasons uint64 `json:"throttle_reasons"` // Throttle* bitmask
+	ClockGfxApp     int    `json:"clock_gfx_app"`    // application clock, MHz
+	ClockMemApp     int    `json:"clock_mem_app"`    // application clock, MHz
+
+	// Memory health (counters are zero when ECC is unsupported or disabled)
+	ECCEnabled         bool   `json:"ecc_enabled"`
+	ECCCorrVolatile    uint64 `json:"ecc_corrected_volatile"` // since last driver reload
+	ECCUncorrVolatile  uint64 `json:"ecc_uncorrected_volatile"`
+	ECCCorrAggregate   uint64 `json:"ecc_corrected_aggregate"` // lifetime
+	ECCUncorrAggregate uint64 `json:"ecc_uncorrected_aggregate"`
+	RetiredPagesSBE    int    `json:"retired_pages_sbe"`         // retired for multiple single-bit errors
+	RetiredPagesDBE    int    `json:"retired_pages_dbe"`         // retired for double-bit errors
+	RetiredPending     bool   `json:"retired_pending"`           // retirement awaits reboot
+	RemappedCorr       int    `json:"remapped_rows_corrected"`   // rows remapped for correctable errors
+	RemappedUncorr     int    `json:"remapped_rows_uncorrected"` // rows remapped for uncorrectable errors
+	RemapPending       bool   `json:"remap_pending"`             // remap awaits GPU reset
+	RemapFailed        bool   `json:"remap_failed"`
+}
+
+// Clock throttle reason bits, identical to NVML's nvmlClocksThrottleReason* values.
+const (
+	ThrottleGPUIdle      uint64 = 0x1
+	ThrottleAppClocks    uint64 = 0x2
+	ThrottleSWPowerCap   uint64 = 0x4
+	ThrottleHWSlowdown   uint64 = 0x8
+	ThrottleSyncBoost    uint64 = 0x10
+	ThrottleSWThermal    uint64 = 0x20
+	ThrottleHWThermal    uint64 = 0x40
+	ThrottleHWPowerBrake uint64 = 0x80
+)
+
+// ThrottleReason names a throttle bit that counts as a slowdown. Idle and
+// application-clock settings are deliberate and not tracked.
+type ThrottleReason struct {
+	Name string
+	Bit  uint64
+}
+
+// ThrottleReasons are the slowdown reasons tracked in rollups and exported
+// to Prometheus.
+var ThrottleReasons = []ThrottleReason{
+	{"sw_power_cap", ThrottleSWPowerCap},
+	{"hw_slowdown", ThrottleHWSlowdown},
+	{"sync_boost", ThrottleSyncBoost},
+	{"sw_thermal", ThrottleSWThermal},
+	{"hw_thermal", ThrottleHWThermal},
+	{"hw_power_brake", ThrottleHWPowerBrake},
+}
+
+// ThrottleBucket is the time a GPU spent throttled per reason within a bucket.
+type ThrottleBucket struct {
+	NodeID    string             `json:"node_id"`
+	Timestamp int64              `json:"ts"`
+	GPUID     int                `json:"gpu_id"`
+	Seconds   map[string]float64 `json:"seconds"` // reason name -> seconds throttled
 }
 
 // GPUProcess represents a process using the GPU.
@@ -39,6 +143,98 @@ type GPUProcess struct {
 	PID       uint32 `json:"pid"`
 	Name      string `json:"name"`
 	GPUMem    uint64 `json:"gpu_mem"` // MiB
+
+	// Utilization by this process over the last sampling interval, % of the
+	// GPU (zero when the driver does not report per-process utilization)
+	SMUtil  float64 `json:"sm_util"`
+	MemUtil float64 `json:"mem_util"`
+	EncUtil float64 `json:"enc_util"`
+	DecUtil float64 `json:"dec_util"`
+
+	// Attribution (empty when unknown)
+	Cmdline       string `json:"cmdline,omitempty"`
+	UID           int    `json:"uid"` // -1 if unknown
+	User          string `json:"user,omitempty"`
+	Cgroup        string `json:"cgroup,omitempty"`
+	ContainerID   string `json:"container_id,omitempty"`
+	ContainerName string `json:"container_name,omitempty"` // Docker name or Kubernetes container name
+	Namespace     string `json:"namespace,omitempty"`      // Kubernetes pod namespace
+	Pod           string `json:"pod,omitempty"`            // Kubernetes pod name
+	JobID         string `json:"job_id,omitempty"`         // Slurm job ID
+	JobUser       string `json:"job_user,omitempty"`
+	JobName       string `json:"job_name,omitempty"`
+}
+
+// Job is a batch job (Slurm) seen running on GPUs, with usage accounting.
+type Job struct {
+	JobID      string   `json:"job_id"`
+	User       string   `json:"user"`
+	Name       string   `json:"name"`
+	FirstSeen  int64    `json:"first_seen"`
+	LastSeen   int64    `json:"last_seen"`
+	PeakMem    uint64   `json:"peak_mem"`    // MiB, peak total across the job's processes on a node
+	AvgUtil    float64  `json:"avg_util"`    // mean SM utilization of the job's processes, %
+	GPUSeconds float64  `json:"gpu_seconds"` // sum over GPUs of the time the job was seen on them
+	GPUs       []JobGPU `json:"gpus"`
+}
+
+// JobGPU is a GPU a job ran on and when.
+type JobGPU struct {
+	NodeID    string `json:"node_id"`
+	GPUID     int    `json:"gpu_id"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+}
+
+// GPUEvent is a discrete driver-reported event such as an XID error.
+type GPUEvent struct {
+	NodeID      string `json:"node_id,omitempty"`
+	Timestamp   int64  `json:"ts"`
+	GPUID       int    `json:"gpu_id"` // -1 if the device could not be identified
+	Type        string `json:"type"`   // "xid", "double_bit_ecc", "power_source", "clock_change", "nvml_error", "anomaly"
+	XID         uint64 `json:"xid,omitempty"`
+	Description string `json:"description"`
+}
+
+// NVLinkMetrics is a sample of one NVLink of a GPU.
+type NVLinkMetrics struct {
+	NodeID         string `json:"node_id,omitempty"`
+	Timestamp      int64  `json:"ts"`
+	GPUID          int    `json:"gpu_id"`
+	Link           int    `json:"link"`
+	Active         bool   `json:"active"`
+	RemoteType     string `json:"remote_type"`   // "gpu", "switch", "ibmnpu", "unknown"
+	RemotePCI      string `json:"remote_pci"`    // PCI bus ID of the peer device
+	RemoteGPUID    int    `json:"remote_gpu_id"` // index of a local GPU peer, -1 otherwise
+	TxKBps         uint64 `json:"tx_kbps"`       // data throughput, KiB/s
+	RxKBps         uint64 `json:"rx_kbps"`
+	CRCErrors      uint64 `json:"crc_errors"` // cumulative
+	ReplayErrors   uint64 `json:"replay_errors"`
+	RecoveryErrors uint64 `json:"recovery_errors"`
+}
+
+// NVLinkPeer is a direct NVLink connection between two GPUs of a node.
+type NVLinkPeer struct {
+	GPUID       int `json:"gpu_id"`
+	PeerGPUID   int `json:"peer_gpu_id"`
+	Links       int `json:"links"`
+	ActiveLinks int `json:"active_links"`
+}
+
+// NVSwitchLinks groups the GPU links that terminate on one NVSwitch.
+type NVSwitchLinks struct {
+	PCI         string `json:"pci"`
+	GPUs        []int  `json:"gpus"`
+	Links       int    `json:"links"`
+	ActiveLinks int    `json:"active_links"`
+}
+
+// NVLinkTopology describes how the GPUs of a node are interconnected.
+type NVLinkTopology struct {
+	NodeID   string          `json:"node_id"`
+	Links    []NVLinkMetrics `json:"links"` // latest sample per GPU link
+	Peers    []NVLinkPeer    `json:"peers"`
+	Switches []NVSwitchLinks `json:"switches"`
 }
 
 // HostMetrics holds a snapshot of host-level metrics.
@@ -59,20 +255,73 @@ type HostMetrics struct {
 
 // Snapshot is a complete point-in-time reading pushed via WebSocket.
 type Snapshot struct {
-	Type      string       `json:"type"`
-	NodeID    string       `json:"node_id,omitempty"`
-	Timestamp int64        `json:"ts"`
-	GPUs      []GPUMetrics `json:"gpus,omitempty"`
-	Host      *HostMetrics `json:"host,omitempty"`
-	Processes []GPUProcess `json:"processes,omitempty"`
+	Type      string          `json:"type"`
+	NodeID    string          `json:"node_id,omitempty"`
+	Timestamp int64           `json:"ts"`
+	GPUs      []GPUMetrics    `json:"gpus,omitempty"`
+	Host      *HostMetrics    `json:"host,omitempty"`
+	Processes []GPUProcess    `json:"processes,omitempty"`
+	Events    []GPUEvent      `json:"events,omitempty"`
+	NVLinks   []NVLinkMetrics `json:"nvlinks,omitempty"`
+	Devices   []GPUDevice     `json:"devices,omitempty"` // trace header only
+}
+
+// IngestBatchVersion is the current version of the IngestBatch envelope.
+const IngestBatchVersion = 1
+
+// IngestBatch is the envelope an agent posts to /api/v1/ingest/batch: every
+// snapshot the node collected since its last flush, oldest first.
+type IngestBatch struct {
+	Version    int        `json:"version"`
+	NodeID     string     `json:"node_id"`
+	DeviceHash string     `json:"device_hash,omitempty"` // DeviceHash of the agent's GPUs
+	Snapshots  []Snapshot `json:"snapshots"`
+}
+
+// Registration announces an agent and its GPUs to the hub.
+type Registration struct {
+	NodeID   string            `json:"node_id"`
+	Hostname string            `json:"hostname"`
+	Devices  []GPUDevice       `json:"devices"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Message types on the agent/hub ingest stream.
+const (
+	StreamRegister  = "register"  // agent -> hub, first message on a connection
+	StreamBatch     = "batch"     // agent -> hub, followed by an IngestBatch payload
+	StreamHeartbeat = "heartbeat" // agent -> hub
+	StreamAck       = "ack"       // hub -> agent, for every message with a Seq
+	StreamCommand   = "command"   // hub -> agent
+)
+
+// StreamMessage is a frame on the ingest stream (/api/v1/ingest/stream).
+// Control messages are JSON text frames; a batch is a binary frame holding
+// the JSON message, a newline and the (possibly compressed) IngestBatch.
+type StreamMessage struct {
+	Type     string        `json:"type"`
+	Seq      uint64        `json:"seq,omitempty"`      // echoed in the ack
+	Encoding string        `json:"encoding,omitempty"` // batch payload Content-Encoding
+	Status   int           `json:"status,omitempty"`   // ack: HTTP-style status, 200 = stored
+	Error    string        `json:"error,omitempty"`    // ack: why the message was rejected
+	Register *Registration `json:"register,omitempty"`
+	Command  string        `json:"command,omitempty"`
 }
 
 // Node represents a registered agent node.
 type Node struct {
-	NodeID    string `json:"node_id"`
-	Hostname  string `json:"hostname"`
-	GPUCount  int    `json:"gpu_count"`
-	FirstSeen int64  `json:"first_seen"`
-	LastSeen  int64  `json:"last_seen"`
-	Online    bool   `json:"online"`
+	NodeID     string `json:"node_id"`
+	Hostname   string `json:"hostname"`
+	GPUCount   int    `json:"gpu_count"`
+	FirstSeen  int64  `json:"first_seen"`
+	LastSeen   int64  `json:"last_seen"`
+	Online     bool   `json:"online"`
+	Streaming  bool   `json:"streaming"`             // connected over the ingest stream
+	DeviceHash string `json:"device_hash,omitempty"` // DeviceHash of the registered GPUs
+	// Maintenance silences the node's alerts, e.g. while it is drained
+	// for a driver upgrade.
+	Maintenance bool `json:"maintenance"`
+	// Labels are set by the agent (--node-labels), e.g. {"tier": "consumer"},
+	// and scope alert threshold overrides.
+	Labels map[string]string `json:"labels,omitempty"`
 }
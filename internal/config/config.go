@@ -3,26 +3,78 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
+	Command         string   // subcommand (e.g. "record"), empty = run server
+	Args            []string // remaining positional arguments (e.g. "create" in "token create")
 	Mode            string
 	Port            int
 	DataDir         string
 	HubURL          string
 	NodeID          string
+	NodeLabels      string        // node labels, "key=value,key=value"
+	FlushInterval   time.Duration // how often the agent sends buffered samples to the hub
+	BatchEncoding   string        // compression of agent batches: gzip or zstd
+	SpoolDir        string        // agent spool directory (default <data-dir>/spool)
+	SpoolMaxMB      int           // agent spool size limit in MiB (0 = spooling disabled)
+	SpoolMaxAge     time.Duration // spooled batches older than this are dropped
+	GPUBackend      string        // "nvml", "sim" or "replay"
+	SimGPUs         int           // number of simulated GPUs (sim backend)
+	SimSeed         int64         // random seed for the sim backend
+	SimMIG          int           // number of simulated GPUs partitioned with MIG (sim backend)
+	ReplayFile      string        // trace file (replay backend)
+	ReplaySpeed     float64       // replay speed multiplier (replay backend)
+	ReplayLoop      bool          // restart the trace when it ends (replay backend)
+	RecordOut       string        // trace output file (record command)
+	TokenName       string        // token name (token create)
+	TokenRole       string        // token role (token create)
+	TokenNode       string        // node an agent token is scoped to (token create)
+	TokenExpires    time.Duration // token lifetime, 0 = never expires (token create)
+	HostRoot        string        // host filesystem root for /proc and container runtime state
 	CollectInterval time.Duration
 	HostInterval    time.Duration
+	NVLinkInterval  time.Duration // 0 = NVLink collection disabled
 	RetentionRaw    time.Duration
 	Retention1m     time.Duration
 	Retention1h     time.Duration
 	DevMode         bool
 	UIDir           string
 	Auth            string // "user:password" for basic auth (empty = disabled)
+	TLSCert         string // server certificate (hub/standalone) or client certificate (agent)
+	TLSKey          string // private key of TLSCert
+	TLSCA           string // CA bundle: of agent client certificates (hub) or of the hub (agent)
+	IngestSecret    string // secret agent node keys are derived from (HMAC-signed ingest)
+	IngestKey       string // agent node key (alternative to IngestSecret in agent mode)
+	IngestTokens    bool   // hub requires agent API tokens for ingest
+	IngestToken     string // agent API token sent to the hub
+	OIDCIssuer      string // OpenID Connect issuer URL (empty = SSO disabled)
+	OIDCClientID    string
+	OIDCSecret      string
+	OIDCRedirectURL string // callback URL registered at the provider (https://<host>/auth/callback)
+	OIDCScopes      string // requested in addition to "openid", space-separated
+	OIDCGroups      string // ID token claim listing the user's groups
+	OIDCAdmins      string // comma-separated groups mapped to the admin role
+	OIDCViewers     string // comma-separated groups mapped to the viewer role (empty = any user)
+	SessionSecret   string // signs SSO session cookies (empty = random per start)
 	AlertTempMax    int    // temperature alert threshold (°C, 0 = disabled)
 	AlertGPUUtil    int    // GPU utilization alert threshold (%, 0 = disabled)
 	AlertMemUtil    int    // memory utilization alert threshold (%, 0 = disabled)
+	AlertRules      string // JSON file of alert rules
+	AlertInterval   time.Duration
+	OfflineAfter    time.Duration // hub: node_offline after this long without contact (0 = disabled)
+	StaleAfter      time.Duration // gpu_metrics_stale/gpu_missing after this long (0 = disabled)
+	NotifyConfig    string        // JSON file of alert notification channels
+	RulesFile       string        // YAML file of alert rules, channels and silences (reloaded on change)
+	WasteUtil       float64       // GPUs below this utilization (%) while holding memory are idle
+	WasteMinMem     int           // MiB of GPU memory that counts as an allocation
+	WasteAfter      time.Duration // idle allocations are reported after this long
+	WasteAlert      bool          // raise gpu_idle_allocated alerts
+	AnomalyScore    float64       // standard deviations that count as an anomaly (0 = disabled)
+	AnomalyHalfLife time.Duration // half-life of the anomaly baselines
 }
 
 func Load() *Config {
@@ -33,19 +85,79 @@ func Load() *Config {
 	flag.StringVar(&cfg.DataDir, "data-dir", envOrDefault("CUDASCOPE_DATA_DIR", "/data"), "data directory for SQLite")
 	flag.StringVar(&cfg.HubURL, "hub-url", envOrDefault("CUDASCOPE_HUB_URL", ""), "hub URL (agent mode)")
 	flag.StringVar(&cfg.NodeID, "node-id", envOrDefault("CUDASCOPE_NODE_ID", ""), "node identifier (default: hostname)")
+	flag.StringVar(&cfg.NodeLabels, "node-labels", envOrDefault("CUDASCOPE_NODE_LABELS", ""), "node labels for alert threshold overrides, e.g. tier=consumer,rack=a1")
+	flag.DurationVar(&cfg.FlushInterval, "flush-interval", envOrDefaultDuration("CUDASCOPE_FLUSH_INTERVAL", 5*time.Second), "how often the agent sends buffered samples to the hub in one batch (0=every sample)")
+	flag.StringVar(&cfg.BatchEncoding, "batch-encoding", envOrDefault("CUDASCOPE_BATCH_ENCODING", "gzip"), "compression of the batches the agent sends: gzip or zstd (agent mode)")
+	flag.StringVar(&cfg.SpoolDir, "spool-dir", envOrDefault("CUDASCOPE_SPOOL_DIR", ""), "directory for batches not yet delivered to the hub (agent mode, default <data-dir>/spool)")
+	flag.IntVar(&cfg.SpoolMaxMB, "spool-max-mb", envOrDefaultInt("CUDASCOPE_SPOOL_MAX_MB", 512), "agent spool size limit in MiB (0=disabled)")
+	flag.DurationVar(&cfg.SpoolMaxAge, "spool-max-age", envOrDefaultDuration("CUDASCOPE_SPOOL_MAX_AGE", 24*time.Hour), "drop spooled batches older than this (agent mode)")
+	flag.StringVar(&cfg.GPUBackend, "gpu-backend", envOrDefault("CUDASCOPE_GPU_BACKEND", "nvml"), "GPU backend: nvml, sim, replay")
+	flag.IntVar(&cfg.SimGPUs, "sim-gpus", envOrDefaultInt("CUDASCOPE_SIM_GPUS", 4), "number of simulated GPUs (sim backend)")
+	flag.Int64Var(&cfg.SimSeed, "sim-seed", int64(envOrDefaultInt("CUDASCOPE_SIM_SEED", 1)), "random seed for the sim backend")
+	flag.IntVar(&cfg.SimMIG, "sim-mig", envOrDefaultInt("CUDASCOPE_SIM_MIG", 0), "number of simulated GPUs partitioned with MIG (sim backend, A100/H100 models)")
+	flag.StringVar(&cfg.ReplayFile, "replay-file", envOrDefault("CUDASCOPE_REPLAY_FILE", ""), "trace file to replay (replay backend)")
+	flag.Float64Var(&cfg.ReplaySpeed, "replay-speed", envOrDefaultFloat("CUDASCOPE_REPLAY_SPEED", 1), "replay speed multiplier (replay backend)")
+	flag.BoolVar(&cfg.ReplayLoop, "replay-loop", envOrDefault("CUDASCOPE_REPLAY_LOOP", "") == "true", "restart the trace when it ends (replay backend)")
+	flag.StringVar(&cfg.RecordOut, "out", "", "trace output file (record command)")
+	flag.StringVar(&cfg.TokenName, "name", "", "token name (token create)")
+	flag.StringVar(&cfg.TokenRole, "role", "viewer", "token role: viewer, admin, agent (token create)")
+	flag.StringVar(&cfg.TokenNode, "node", "", "node an agent token may ingest as (token create, required for agent tokens)")
+	flag.DurationVar(&cfg.TokenExpires, "expires", 0, "token lifetime (token create, 0=never expires)")
+	flag.StringVar(&cfg.HostRoot, "host-root", envOrDefault("CUDASCOPE_HOST_ROOT", "/"), "host filesystem root for /proc and container runtime state")
 	flag.DurationVar(&cfg.CollectInterval, "collect-interval", envOrDefaultDuration("CUDASCOPE_COLLECT_INTERVAL", time.Second), "GPU metric collection interval")
 	flag.DurationVar(&cfg.HostInterval, "host-interval", envOrDefaultDuration("CUDASCOPE_HOST_INTERVAL", 5*time.Second), "host metric collection interval")
+	flag.DurationVar(&cfg.NVLinkInterval, "nvlink-interval", envOrDefaultDuration("CUDASCOPE_NVLINK_INTERVAL", 10*time.Second), "NVLink state and counter collection interval (0=disabled)")
 	flag.DurationVar(&cfg.RetentionRaw, "retention-raw", envOrDefaultDuration("CUDASCOPE_RETENTION_RAW", 24*time.Hour), "raw metrics retention")
 	flag.DurationVar(&cfg.Retention1m, "retention-1m", envOrDefaultDuration("CUDASCOPE_RETENTION_1M", 30*24*time.Hour), "1-minute rollup retention")
 	flag.DurationVar(&cfg.Retention1h, "retention-1h", envOrDefaultDuration("CUDASCOPE_RETENTION_1H", 365*24*time.Hour), "1-hour rollup retention")
 	flag.BoolVar(&cfg.DevMode, "dev", false, "development mode (serve UI from filesystem)")
 	flag.StringVar(&cfg.UIDir, "ui-dir", "ui/build", "UI directory (dev mode)")
 	flag.StringVar(&cfg.Auth, "auth", envOrDefault("CUDASCOPE_AUTH", ""), "basic auth credentials (user:password)")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", envOrDefault("CUDASCOPE_TLS_CERT", ""), "TLS certificate: served by hub/standalone, presented to the hub by agents")
+	flag.StringVar(&cfg.TLSKey, "tls-key", envOrDefault("CUDASCOPE_TLS_KEY", ""), "private key for --tls-cert")
+	flag.StringVar(&cfg.TLSCA, "tls-ca", envOrDefault("CUDASCOPE_TLS_CA", ""), "CA bundle: hub verifies agent client certificates with it, agents verify the hub with it")
+	flag.StringVar(&cfg.IngestSecret, "ingest-secret", envOrDefault("CUDASCOPE_INGEST_SECRET", ""), "secret for HMAC-signed agent ingest (hub: required to verify, agent: derives its node key)")
+	flag.StringVar(&cfg.IngestKey, "ingest-key", envOrDefault("CUDASCOPE_INGEST_KEY", ""), "node key for signed ingest, from the ingest-key command (agent mode)")
+	flag.StringVar(&cfg.OIDCIssuer, "oidc-issuer", envOrDefault("CUDASCOPE_OIDC_ISSUER", ""), "OpenID Connect issuer URL for SSO login to the web UI")
+	flag.StringVar(&cfg.OIDCClientID, "oidc-client-id", envOrDefault("CUDASCOPE_OIDC_CLIENT_ID", ""), "OpenID Connect client ID")
+	flag.StringVar(&cfg.OIDCSecret, "oidc-client-secret", envOrDefault("CUDASCOPE_OIDC_CLIENT_SECRET", ""), "OpenID Connect client secret")
+	flag.StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", envOrDefault("CUDASCOPE_OIDC_REDIRECT_URL", ""), "callback URL registered at the provider, e.g. https://cudascope.example.com/auth/callback")
+	flag.StringVar(&cfg.OIDCScopes, "oidc-scopes", envOrDefault("CUDASCOPE_OIDC_SCOPES", "profile email"), "scopes requested in addition to openid")
+	flag.StringVar(&cfg.OIDCGroups, "oidc-groups-claim", envOrDefault("CUDASCOPE_OIDC_GROUPS_CLAIM", "groups"), "ID token claim listing the user's groups")
+	flag.StringVar(&cfg.OIDCAdmins, "oidc-admin-groups", envOrDefault("CUDASCOPE_OIDC_ADMIN_GROUPS", ""), "comma-separated groups granted the admin role")
+	flag.StringVar(&cfg.OIDCViewers, "oidc-viewer-groups", envOrDefault("CUDASCOPE_OIDC_VIEWER_GROUPS", ""), "comma-separated groups granted the viewer role (empty=any authenticated user)")
+	flag.StringVar(&cfg.SessionSecret, "session-secret", envOrDefault("CUDASCOPE_SESSION_SECRET", ""), "secret signing SSO session cookies (default random, sessions end on restart)")
+	flag.BoolVar(&cfg.IngestTokens, "ingest-tokens", envOrDefault("CUDASCOPE_INGEST_TOKENS", "") == "true", "require agent API tokens for ingest (hub)")
+	flag.StringVar(&cfg.IngestToken, "ingest-token", envOrDefault("CUDASCOPE_INGEST_TOKEN", ""), "API token with the agent role to send to the hub (agent mode)")
 	flag.IntVar(&cfg.AlertTempMax, "alert-temp", envOrDefaultInt("CUDASCOPE_ALERT_TEMP", 0), "temperature alert threshold °C (0=disabled)")
 	flag.IntVar(&cfg.AlertGPUUtil, "alert-gpu-util", envOrDefaultInt("CUDASCOPE_ALERT_GPU_UTIL", 0), "GPU utilization alert threshold % (0=disabled)")
 	flag.IntVar(&cfg.AlertMemUtil, "alert-mem-util", envOrDefaultInt("CUDASCOPE_ALERT_MEM_UTIL", 0), "memory utilization alert threshold % (0=disabled)")
+	flag.StringVar(&cfg.AlertRules, "alert-rules", envOrDefault("CUDASCOPE_ALERT_RULES", ""), "JSON file of alert rules (in addition to the --alert-* thresholds)")
+	flag.DurationVar(&cfg.AlertInterval, "alert-interval", envOrDefaultDuration("CUDASCOPE_ALERT_INTERVAL", 15*time.Second), "how often alert rules are evaluated")
+	flag.DurationVar(&cfg.OfflineAfter, "alert-offline-after", envOrDefaultDuration("CUDASCOPE_ALERT_OFFLINE_AFTER", 90*time.Second), "hub: alert when a node sent no metrics or heartbeats for this long (0=disabled)")
+	flag.DurationVar(&cfg.StaleAfter, "alert-stale-after", envOrDefaultDuration("CUDASCOPE_ALERT_STALE_AFTER", 60*time.Second), "alert when GPU metrics stop while host metrics continue, or a registered GPU stops reporting (0=disabled)")
+	flag.StringVar(&cfg.NotifyConfig, "notify-config", envOrDefault("CUDASCOPE_NOTIFY_CONFIG", ""), "JSON file of alert notification channels (webhook, slack, email, alertmanager)")
+	flag.StringVar(&cfg.RulesFile, "rules-file", envOrDefault("CUDASCOPE_RULES_FILE", ""), "YAML file of alert rules, notification channels and silences, reloaded on change or SIGHUP")
+	flag.Float64Var(&cfg.WasteUtil, "waste-util", envOrDefaultFloat("CUDASCOPE_WASTE_UTIL", 5), "GPU utilization % below which a GPU holding memory is idle")
+	flag.IntVar(&cfg.WasteMinMem, "waste-min-mem", envOrDefaultInt("CUDASCOPE_WASTE_MIN_MEM", 1024), "GPU memory (MiB) a GPU must hold to count as allocated")
+	flag.DurationVar(&cfg.WasteAfter, "waste-after", envOrDefaultDuration("CUDASCOPE_WASTE_AFTER", time.Hour), "report GPUs idle while holding memory for this long")
+	flag.BoolVar(&cfg.WasteAlert, "waste-alert", envOrDefault("CUDASCOPE_WASTE_ALERT", "") == "true", "raise gpu_idle_allocated alerts for idle allocations")
+	flag.Float64Var(&cfg.AnomalyScore, "anomaly-score", envOrDefaultFloat("CUDASCOPE_ANOMALY_SCORE", 0), "standard deviations from a GPU's baseline or peers that count as an anomaly, e.g. 4 (0=disabled)")
+	flag.DurationVar(&cfg.AnomalyHalfLife, "anomaly-half-life", envOrDefaultDuration("CUDASCOPE_ANOMALY_HALF_LIFE", 7*24*time.Hour), "half-life of the per-GPU anomaly baselines")
 
-	flag.Parse()
+	// An optional leading subcommand precedes the flags: cudascope record --out trace.jsonl.
+	// Further words before the flags are arguments: cudascope token create --name ci
+	args := os.Args[1:]
+	var words []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		words = append(words, args[0])
+		args = args[1:]
+	}
+	if len(words) > 0 {
+		cfg.Command, words = words[0], words[1:]
+	}
+	flag.CommandLine.Parse(args)
+	cfg.Args = append(words, flag.Args()...)
 	return cfg
 }
 
@@ -70,6 +182,18 @@ func envOrDefaultInt(key string, def int) int {
 	return i
 }
 
+func envOrDefaultFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
 func envOrDefaultDuration(key string, def time.Duration) time.Duration {
 	v := os.Getenv(key)
 	if v == "" {
@@ -0,0 +1,232 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// statusError is an error response from a notification endpoint.
+type statusError struct {
+	url  string
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("POST %s: status %d: %s", e.url, e.code, e.body)
+}
+
+// permanent reports whether retrying cannot succeed: the endpoint rejected
+// the request itself (4xx other than 408 and 429).
+func permanent(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code >= 400 && se.code < 500 &&
+		se.code != http.StatusRequestTimeout && se.code != http.StatusTooManyRequests
+}
+
+func post(ctx context.Context, url, contentType string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{url: url, code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// alertLine describes an alert on one line, e.g.
+// "node-1 GPU 0: temperature 91 > 85 (critical, firing since 03:12:00 UTC)".
+func alertLine(a *storage.Alert) string {
+	target := a.NodeID + " host"
+	if a.GPUID >= 0 {
+		target = fmt.Sprintf("%s GPU %d", a.NodeID, a.GPUID)
+	}
+	since := fmt.Sprintf("firing since %s", formatTime(a.FiredAt))
+	if a.State == storage.AlertResolved {
+		since = fmt.Sprintf("resolved at %s", formatTime(a.ResolvedAt))
+	}
+	thresh := formatValue(a.Thresh)
+	if a.Scope != "" {
+		thresh += " for " + a.Scope
+	}
+	return fmt.Sprintf("%s: %s %s %s %s (%s, %s)", target, a.Metric, formatValue(a.Value), a.Op,
+		thresh, a.Severity, since)
+}
+
+func formatTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// text renders a message as a plain text body.
+func text(m *Message) string {
+	var b strings.Builder
+	for i := range m.Alerts {
+		b.WriteString(alertLine(&m.Alerts[i]))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// webhook posts the message as JSON, or the body rendered by a template.
+type webhook struct {
+	url     string
+	headers map[string]string
+	tmpl    *template.Template
+}
+
+func (w *webhook) send(ctx context.Context, m *Message) error {
+	var body []byte
+	if w.tmpl != nil {
+		var buf bytes.Buffer
+		if err := w.tmpl.Execute(&buf, m); err != nil {
+			return fmt.Errorf("template: %w", err)
+		}
+		body = buf.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(m); err != nil {
+			return err
+		}
+	}
+	return post(ctx, w.url, "application/json", w.headers, body)
+}
+
+// slack posts to a Slack or Mattermost incoming webhook.
+type slack struct {
+	url string
+}
+
+var severityColors = map[string]string{
+	"info":     "#439fe0",
+	"warning":  "#daa038",
+	"critical": "#d00000",
+}
+
+func (s *slack) send(ctx context.Context, m *Message) error {
+	color := "#2eb886" // resolved
+	if m.Status == storage.AlertFiring {
+		color = severityColors[m.Severity]
+	}
+	body, err := json.Marshal(map[string]any{
+		"text": m.Title(),
+		"attachments": []map[string]string{{
+			"color":    color,
+			"text":     text(m),
+			"fallback": m.Title(),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.url, "application/json", nil, body)
+}
+
+// email sends a plain text mail over SMTP.
+type email struct {
+	host     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func (e *email) send(ctx context.Context, m *Message) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", m.Title())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text(m), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.user != "" {
+		host, _, _ := strings.Cut(e.host, ":")
+		auth = smtp.PlainAuth("", e.user, e.password, host)
+	}
+	// smtp.SendMail takes no context; run it so the timeout still applies
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.host, auth, e.from, e.to, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// alertmanager pushes alerts to the Alertmanager v2 API. Alertmanager
+// resolves alerts that are not re-sent within its resolve_timeout, which
+// the channel's short default repeat interval takes care of.
+type alertmanager struct {
+	url     string
+	headers map[string]string
+}
+
+type amAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    string            `json:"startsAt"`
+	EndsAt      string            `json:"endsAt,omitempty"`
+}
+
+func (am *alertmanager) send(ctx context.Context, m *Message) error {
+	alerts := make([]amAlert, len(m.Alerts))
+	for i := range m.Alerts {
+		a := &m.Alerts[i]
+		labels := map[string]string{
+			"alertname": a.Rule,
+			"severity":  a.Severity,
+			"node":      a.NodeID,
+			"metric":    a.Metric,
+			"job":       "cudascope",
+		}
+		if a.GPUID >= 0 {
+			labels["gpu"] = strconv.Itoa(a.GPUID)
+		}
+		alerts[i] = amAlert{
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary": alertLine(a),
+				"value":   formatValue(a.Value),
+			},
+			StartsAt: time.Unix(a.FiredAt, 0).UTC().Format(time.RFC3339),
+		}
+		if a.State == storage.AlertResolved {
+			alerts[i].EndsAt = time.Unix(a.ResolvedAt, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	return post(ctx, strings.TrimRight(am.url, "/")+"/api/v2/alerts", "application/json", am.headers, body)
+}
@@ -0,0 +1,382 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sergey/cudascope/internal/alert"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+const (
+	firedAt    = 1767225600 // 2026-01-01 00:00:00 UTC
+	resolvedAt = firedAt + 90
+)
+
+func testMessage() *Message {
+	return &Message{
+		Status:   storage.AlertFiring,
+		Rule:     "gpu_hot",
+		NodeID:   "node-1",
+		Severity: alert.SeverityCritical,
+		Alerts: []storage.Alert{
+			{ID: 1, Rule: "gpu_hot", Severity: alert.SeverityCritical, State: storage.AlertFiring, NodeID: "node-1",
+				GPUID: 0, Metric: "temperature", Op: ">", Thresh: 85, Value: 91, StartedAt: firedAt - 60, FiredAt: firedAt},
+			{ID: 2, Rule: "gpu_hot", Severity: alert.SeverityWarning, State: storage.AlertResolved, NodeID: "node-1",
+				GPUID: 3, Metric: "temperature", Op: ">", Thresh: 80, Scope: "model=*4090*", Value: 79.5,
+				StartedAt: firedAt - 60, FiredAt: firedAt, ResolvedAt: resolvedAt},
+		},
+	}
+}
+
+// request is a request received by a recordingServer.
+type request struct {
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+// recordingServer records the requests it receives and answers them with
+// the given statuses in turn (200 once they run out).
+func recordingServer(t *testing.T, statuses ...int) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 100)
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.URL.Path, r.Header, body}
+		mu.Lock()
+		code := http.StatusOK
+		if len(statuses) > 0 {
+			code, statuses = statuses[0], statuses[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "status %d\n", code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func newTestSender(t *testing.T, cc ChannelConfig) sender {
+	t.Helper()
+	s, err := newSender(&cc)
+	if err != nil {
+		t.Fatalf("newSender: %v", err)
+	}
+	return s
+}
+
+func TestWebhook(t *testing.T) {
+	srv, requests := recordingServer(t)
+	s := newTestSender(t, ChannelConfig{Name: "hook", Type: TypeWebhook, URL: srv.URL + "/hook",
+		Headers: map[string]string{"Authorization": "Bearer abc"}})
+	if err := s.send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	req := <-requests
+	if req.path != "/hook" || req.headers.Get("Authorization") != "Bearer abc" || req.headers.Get("Content-Type") != "application/json" {
+		t.Errorf("request: path %s, headers %v", req.path, req.headers)
+	}
+	var got Message
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("body %s: %v", req.body, err)
+	}
+	if !reflect.DeepEqual(&got, testMessage()) {
+		t.Errorf("body:\n got %+v\nwant %+v", got, *testMessage())
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv, requests := recordingServer(t)
+	s := newTestSender(t, ChannelConfig{Name: "hook", Type: TypeWebhook, URL: srv.URL,
+		Template: `{"title":{{json .Title}},"gpus":[{{range $i, $a := .Alerts}}{{if $i}},{{end}}{{$a.GPUID}}{{end}}]}`})
+	if err := s.send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got, want := string((<-requests).body), `{"title":"[FIRING:1] gpu_hot on node-1","gpus":[0,3]}`; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+
+	if _, err := newSender(&ChannelConfig{Name: "bad", Type: TypeWebhook, URL: srv.URL, Template: "{{.Title"}); err == nil {
+		t.Error("newSender accepted a malformed template")
+	}
+}
+
+func TestSlack(t *testing.T) {
+	srv, requests := recordingServer(t)
+	s := newTestSender(t, ChannelConfig{Name: "slack", Type: TypeSlack, URL: srv.URL})
+
+	type payload struct {
+		Text        string              `json:"text"`
+		Attachments []map[string]string `json:"attachments"`
+	}
+	resolved := testMessage()
+	resolved.Status = storage.AlertResolved
+	resolved.Alerts = resolved.Alerts[1:]
+	tests := []struct {
+		msg  *Message
+		want payload
+	}{
+		{testMessage(), payload{
+			Text: "[FIRING:1] gpu_hot on node-1",
+			Attachments: []map[string]string{{
+				"color":    "#d00000",
+				"fallback": "[FIRING:1] gpu_hot on node-1",
+				"text": "node-1 GPU 0: temperature 91 > 85 (critical, firing since 2026-01-01 00:00:00 UTC)\n" +
+					"node-1 GPU 3: temperature 79.5 > 80 for model=*4090* (warning, resolved at 2026-01-01 00:01:30 UTC)\n",
+			}},
+		}},
+		{resolved, payload{
+			Text: "[RESOLVED] gpu_hot on node-1",
+			Attachments: []map[string]string{{
+				"color":    "#2eb886",
+				"fallback": "[RESOLVED] gpu_hot on node-1",
+				"text":     "node-1 GPU 3: temperature 79.5 > 80 for model=*4090* (warning, resolved at 2026-01-01 00:01:30 UTC)\n",
+			}},
+		}},
+	}
+	for _, tt := range tests {
+		if err := s.send(context.Background(), tt.msg); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		var got payload
+		if err := json.Unmarshal((<-requests).body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("payload:\n got %+v\nwant %+v", got, tt.want)
+		}
+	}
+}
+
+func TestAlertmanager(t *testing.T) {
+	srv, requests := recordingServer(t)
+	s := newTestSender(t, ChannelConfig{Name: "am", Type: TypeAlertmanager, URL: srv.URL + "/",
+		Headers: map[string]string{"X-Scope-OrgID": "gpu"}})
+	msg := testMessage()
+	msg.Alerts = append(msg.Alerts, storage.Alert{Rule: "host_mem", Severity: alert.SeverityWarning,
+		State: storage.AlertFiring, NodeID: "node-1", GPUID: -1, Metric: "mem_used_pct", Op: ">", Thresh: 90, Value: 95, FiredAt: firedAt})
+	if err := s.send(context.Background(), msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	req := <-requests
+	if req.path != "/api/v2/alerts" || req.headers.Get("X-Scope-OrgID") != "gpu" {
+		t.Errorf("request: path %s, headers %v", req.path, req.headers)
+	}
+	var got []amAlert
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []amAlert{
+		{
+			Labels: map[string]string{"alertname": "gpu_hot", "severity": "critical", "node": "node-1", "metric": "temperature", "job": "cudascope", "gpu": "0"},
+			Annotations: map[string]string{"value": "91",
+				"summary": "node-1 GPU 0: temperature 91 > 85 (critical, firing since 2026-01-01 00:00:00 UTC)"},
+			StartsAt: "2026-01-01T00:00:00Z",
+		},
+		{
+			Labels: map[string]string{"alertname": "gpu_hot", "severity": "warning", "node": "node-1", "metric": "temperature", "job": "cudascope", "gpu": "3"},
+			Annotations: map[string]string{"value": "79.5",
+				"summary": "node-1 GPU 3: temperature 79.5 > 80 for model=*4090* (warning, resolved at 2026-01-01 00:01:30 UTC)"},
+			StartsAt: "2026-01-01T00:00:00Z",
+			EndsAt:   "2026-01-01T00:01:30Z",
+		},
+		{
+			// Host alerts have no gpu label
+			Labels: map[string]string{"alertname": "host_mem", "severity": "warning", "node": "node-1", "metric": "mem_used_pct", "job": "cudascope"},
+			Annotations: map[string]string{"value": "95",
+				"summary": "node-1 host: mem_used_pct 95 > 90 (warning, firing since 2026-01-01 00:00:00 UTC)"},
+			StartsAt: "2026-01-01T00:00:00Z",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("alerts:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestPostErrors(t *testing.T) {
+	tests := []struct {
+		code      int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusNotFound, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		srv, _ := recordingServer(t, tt.code)
+		err := post(context.Background(), srv.URL, "application/json", nil, []byte("{}"))
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("status %d: status %d", tt.code, tt.code)) {
+			t.Errorf("status %d: err = %v", tt.code, err)
+			continue
+		}
+		if permanent(err) != tt.permanent {
+			t.Errorf("status %d: permanent = %t, want %t", tt.code, !tt.permanent, tt.permanent)
+		}
+	}
+
+	// Network errors are retried
+	srv, _ := recordingServer(t)
+	srv.Close()
+	if err := post(context.Background(), srv.URL, "application/json", nil, nil); err == nil || permanent(err) {
+		t.Errorf("closed server: err = %v, want a temporary error", err)
+	}
+}
+
+// mail is a message received by an smtpServer.
+type mail struct {
+	auth string // decoded AUTH PLAIN credentials
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer is a minimal local SMTP server that accepts every message and
+// AUTH PLAIN.
+func smtpServer(t *testing.T) (addr string, mails <-chan mail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan mail, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+func serveSMTP(conn net.Conn, mails chan<- mail) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+
+	reply("220 localhost ESMTP test")
+	var m mail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			mech, cred, _ := strings.Cut(arg, " ")
+			dec, err := base64.StdEncoding.DecodeString(cred)
+			if mech != "PLAIN" || err != nil {
+				reply("535 authentication failed")
+				continue
+			}
+			m.auth = strings.ReplaceAll(string(dec), "\x00", "|")
+			reply("235 ok")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			if i := strings.Index(m.from, ">"); i >= 0 {
+				m.from = m.from[:i]
+			}
+			reply("250 ok")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			m.data = data.String()
+			mails <- m
+			m = mail{}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	addr, mails := smtpServer(t)
+	s := newTestSender(t, ChannelConfig{Name: "mail", Type: TypeEmail, SMTPHost: addr, SMTPUser: "cudascope", SMTPPassword: "pw",
+		From: "cudascope@example.com", To: []string{"oncall@example.com", "ml@example.com"}})
+	if err := s.send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	var m mail
+	select {
+	case m = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	if m.auth != "|cudascope|pw" {
+		t.Errorf("auth = %q", m.auth)
+	}
+	if m.from != "cudascope@example.com" || !reflect.DeepEqual(m.to, []string{"oncall@example.com", "ml@example.com"}) {
+		t.Errorf("envelope: from %q to %v", m.from, m.to)
+	}
+	for _, want := range []string{
+		"From: cudascope@example.com\r\n",
+		"To: oncall@example.com, ml@example.com\r\n",
+		"Subject: [FIRING:1] gpu_hot on node-1\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+			"node-1 GPU 0: temperature 91 > 85 (critical, firing since 2026-01-01 00:00:00 UTC)\r\n" +
+			"node-1 GPU 3: temperature 79.5 > 80 for model=*4090* (warning, resolved at 2026-01-01 00:01:30 UTC)\r\n",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestEmailUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	s := newTestSender(t, ChannelConfig{Name: "mail", Type: TypeEmail, SMTPHost: addr, From: "a@example.com", To: []string{"b@example.com"}})
+	if err := s.send(context.Background(), testMessage()); err == nil || permanent(err) {
+		t.Errorf("err = %v, want a temporary error", err)
+	}
+}
@@ -0,0 +1,445 @@
+// Package notify sends alert notifications to webhooks, Slack-compatible
+// incoming webhooks, email and Alertmanager.
+//
+// Alerts that fire or resolve are routed to the channels their rule names
+// (or the default channels), grouped per channel, rule and node, and sent
+// once the group has waited group_wait for further alerts. Groups with
+// firing alerts are sent again every repeat_interval. Each channel delivers
+// its messages in order, retrying failures with exponential backoff.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sergey/cudascope/internal/alert"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+// Channel types.
+const (
+	TypeWebhook      = "webhook"
+	TypeSlack        = "slack" // Slack or Mattermost incoming webhook
+	TypeEmail        = "email"
+	TypeAlertmanager = "alertmanager"
+)
+
+// Config is the notification config file.
+type Config struct {
+	Channels []ChannelConfig `json:"channels"`
+	Default  []string        `json:"default,omitempty"` // channels for rules without notify
+}
+
+// ChannelConfig configures one notification channel.
+type ChannelConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// webhook, slack and alertmanager
+	URL     string            `json:"url,omitempty"`     // alertmanager: base URL, e.g. http://alertmanager:9093
+	Headers map[string]string `json:"headers,omitempty"` // e.g. Authorization
+	// Template is a text/template over Message producing the webhook body
+	// (default: the Message as JSON). The json function encodes a value.
+	Template string `json:"template,omitempty"`
+
+	// email
+	SMTPHost     string   `json:"smtp_host,omitempty"` // host:port; STARTTLS is used when offered
+	SMTPUser     string   `json:"smtp_user,omitempty"`
+	SMTPPassword string   `json:"smtp_password,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+
+	GroupWait      alert.Duration `json:"group_wait,omitempty"`      // default 10s
+	RepeatInterval alert.Duration `json:"repeat_interval,omitempty"` // default 4h (alertmanager: 1m, so alerts do not time out there)
+	SkipResolved   bool           `json:"skip_resolved,omitempty"`   // do not notify when alerts resolve
+	Retries        int            `json:"retries,omitempty"`         // default 5
+	Timeout        alert.Duration `json:"timeout,omitempty"`         // per attempt, default 10s
+}
+
+// Message is one notification: the alerts of a rule on a node that changed
+// since the last notification, or are still firing when it repeats.
+type Message struct {
+	Status   string          `json:"status"` // firing if any alert is firing, else resolved
+	Rule     string          `json:"rule"`
+	NodeID   string          `json:"node_id"`
+	Severity string          `json:"severity"`
+	Alerts   []storage.Alert `json:"alerts"`
+}
+
+// Title summarizes the message, e.g. "[FIRING:2] gpu_hot on node-1".
+func (m *Message) Title() string {
+	firing := 0
+	for _, a := range m.Alerts {
+		if a.State == storage.AlertFiring {
+			firing++
+		}
+	}
+	if firing > 0 {
+		return fmt.Sprintf("[FIRING:%d] %s on %s", firing, m.Rule, m.NodeID)
+	}
+	return fmt.Sprintf("[RESOLVED] %s on %s", m.Rule, m.NodeID)
+}
+
+// sender delivers a message over one channel type.
+type sender interface {
+	send(ctx context.Context, m *Message) error
+}
+
+type channel struct {
+	cfg    ChannelConfig
+	sender sender
+	queue  chan *Message
+	stop   context.CancelFunc // stops deliver; nil until started
+}
+
+type groupKey struct {
+	channel string
+	rule    string
+	nodeID  string
+}
+
+// group collects the alerts of a rule on a node for one channel.
+type group struct {
+	alerts   map[int64]storage.Alert
+	pending  time.Time // first transition not yet sent (zero = none)
+	lastSent time.Time
+}
+
+// Manager routes alert transitions to channels. It implements
+// alert.Notifier.
+type Manager struct {
+	mu       sync.Mutex
+	ctx      context.Context // of Run, nil until running
+	channels map[string]*channel
+	defaults []string
+	groups   map[groupKey]*group
+}
+
+// Load reads a notification config file.
+func Load(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return &cfg, nil
+}
+
+// New validates a config and creates its channels.
+func New(cfg *Config) (*Manager, error) {
+	m := &Manager{
+		channels: make(map[string]*channel),
+		defaults: cfg.Default,
+		groups:   make(map[groupKey]*group),
+	}
+	for _, cc := range cfg.Channels {
+		if cc.Name == "" {
+			return nil, fmt.Errorf("channel without name")
+		}
+		if m.channels[cc.Name] != nil {
+			return nil, fmt.Errorf("duplicate channel name %q", cc.Name)
+		}
+		if cc.GroupWait == 0 {
+			cc.GroupWait = alert.Duration(10 * time.Second)
+		}
+		if cc.RepeatInterval == 0 {
+			cc.RepeatInterval = alert.Duration(4 * time.Hour)
+			if cc.Type == TypeAlertmanager {
+				cc.RepeatInterval = alert.Duration(time.Minute)
+			}
+		}
+		if cc.Retries == 0 {
+			cc.Retries = 5
+		}
+		if cc.Timeout == 0 {
+			cc.Timeout = alert.Duration(10 * time.Second)
+		}
+
+		s, err := newSender(&cc)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", cc.Name, err)
+		}
+		m.channels[cc.Name] = &channel{cfg: cc, sender: s, queue: make(chan *Message, 100)}
+	}
+	for _, name := range m.defaults {
+		if m.channels[name] == nil {
+			return nil, fmt.Errorf("default: unknown channel %q", name)
+		}
+	}
+	return m, nil
+}
+
+func newSender(cc *ChannelConfig) (sender, error) {
+	switch cc.Type {
+	case TypeWebhook:
+		if cc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		w := &webhook{url: cc.URL, headers: cc.Headers}
+		if cc.Template != "" {
+			t, err := template.New(cc.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(cc.Template)
+			if err != nil {
+				return nil, fmt.Errorf("template: %w", err)
+			}
+			w.tmpl = t
+		}
+		return w, nil
+	case TypeSlack:
+		if cc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &slack{url: cc.URL}, nil
+	case TypeEmail:
+		if cc.SMTPHost == "" || cc.From == "" || len(cc.To) == 0 {
+			return nil, fmt.Errorf("smtp_host, from and to are required")
+		}
+		return &email{host: cc.SMTPHost, user: cc.SMTPUser, password: cc.SMTPPassword, from: cc.From, to: cc.To}, nil
+	case TypeAlertmanager:
+		if cc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &alertmanager{url: cc.URL, headers: cc.Headers}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", cc.Type)
+}
+
+// CheckRules checks that the channels rules notify exist.
+func (m *Manager) CheckRules(rules []alert.Rule) error {
+	for _, r := range rules {
+		for _, name := range r.Notify {
+			if m.channels[name] == nil {
+				return fmt.Errorf("rule %s: unknown notification channel %q", r.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Reload replaces the channels with those of a new config. Channels whose
+// config did not change keep their pending groups and queued messages;
+// those of removed or changed channels are dropped.
+func (m *Manager) Reload(cfg *Config) error {
+	next, err := New(cfg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, ch := range m.channels {
+		if nc := next.channels[name]; nc != nil && reflect.DeepEqual(nc.cfg, ch.cfg) {
+			next.channels[name] = ch
+		} else if ch.stop != nil {
+			ch.stop()
+		}
+	}
+	for _, ch := range next.channels {
+		if ch.stop == nil && m.ctx != nil {
+			m.start(ch)
+		}
+	}
+	for k := range m.groups {
+		if next.channels[k.channel] != m.channels[k.channel] {
+			delete(m.groups, k)
+		}
+	}
+	m.channels = next.channels
+	m.defaults = next.defaults
+	return nil
+}
+
+// Notify adds an alert transition to the groups of the rule's channels.
+// Silenced alerts are removed from their groups instead.
+func (m *Manager) Notify(r *alert.Rule, a storage.Alert) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := r.Notify
+	if len(names) == 0 {
+		names = m.defaults
+	}
+	for _, name := range names {
+		ch := m.channels[name]
+		if ch == nil {
+			continue
+		}
+		k := groupKey{name, a.Rule, a.NodeID}
+		g := m.groups[k]
+		if a.Silenced || (a.State == storage.AlertResolved && ch.cfg.SkipResolved) {
+			if g != nil {
+				delete(g.alerts, a.ID)
+			}
+			continue
+		}
+		if g == nil {
+			g = &group{alerts: make(map[int64]storage.Alert)}
+			m.groups[k] = g
+		}
+		g.alerts[a.ID] = a
+		if g.pending.IsZero() {
+			g.pending = now
+		}
+	}
+}
+
+// Run delivers notifications until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	for _, ch := range m.channels {
+		m.start(ch)
+	}
+	m.mu.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.flush(now)
+		}
+	}
+}
+
+// start runs a channel's deliver loop until the manager stops or the
+// channel is removed.
+func (m *Manager) start(ch *channel) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	ch.stop = cancel
+	go ch.deliver(ctx)
+}
+
+// flush queues the groups that are due: new transitions once group_wait
+// has passed, and firing alerts again after repeat_interval.
+func (m *Manager) flush(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, g := range m.groups {
+		ch := m.channels[k.channel]
+		due := !g.pending.IsZero() && now.Sub(g.pending) >= time.Duration(ch.cfg.GroupWait)
+		if !due && !g.lastSent.IsZero() && now.Sub(g.lastSent) >= time.Duration(ch.cfg.RepeatInterval) {
+			due = true
+		}
+		if !due {
+			continue
+		}
+
+		msg := &Message{Status: storage.AlertResolved, Rule: k.rule, NodeID: k.nodeID}
+		for id, a := range g.alerts {
+			msg.Alerts = append(msg.Alerts, a)
+			if a.State == storage.AlertFiring {
+				msg.Status = storage.AlertFiring
+			} else {
+				delete(g.alerts, id) // resolved alerts are sent once
+			}
+			if severityRank(a.Severity) > severityRank(msg.Severity) {
+				msg.Severity = a.Severity
+			}
+		}
+		sort.Slice(msg.Alerts, func(i, j int) bool { return msg.Alerts[i].GPUID < msg.Alerts[j].GPUID })
+		g.pending = time.Time{}
+		g.lastSent = now
+		if len(g.alerts) == 0 {
+			delete(m.groups, k)
+		}
+		if len(msg.Alerts) == 0 {
+			continue
+		}
+
+		select {
+		case ch.queue <- msg:
+		default:
+			log.Printf("notify %s: queue full, dropping %q", k.channel, msg.Title())
+		}
+	}
+}
+
+func severityRank(s string) int {
+	switch s {
+	case alert.SeverityInfo:
+		return 1
+	case alert.SeverityWarning:
+		return 2
+	case alert.SeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// retryBackoff is the wait before the first retry of a failed delivery.
+var retryBackoff = time.Second
+
+// deliver sends queued messages in order, retrying each with exponential
+// backoff (1s, 2s, 4s, ... up to a minute) unless the failure is permanent.
+func (ch *channel) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-ch.queue:
+			backoff := retryBackoff
+			for attempt := 0; ; attempt++ {
+				sctx, cancel := context.WithTimeout(ctx, time.Duration(ch.cfg.Timeout))
+				err := ch.sender.send(sctx, msg)
+				cancel()
+				if err == nil {
+					break
+				}
+				if permanent(err) || attempt >= ch.cfg.Retries {
+					log.Printf("notify %s: %q: %v (giving up)", ch.cfg.Name, msg.Title(), err)
+					break
+				}
+				log.Printf("notify %s: %q: %v (retrying in %s)", ch.cfg.Name, msg.Title(), err, backoff)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(backoff*2, time.Minute)
+			}
+		}
+	}
+}
+
+// Test sends a test message over a channel, without retries.
+func (m *Manager) Test(ctx context.Context, name string) error {
+	ch := m.channels[name]
+	if ch == nil {
+		return fmt.Errorf("unknown channel %q", name)
+	}
+	now := time.Now().Unix()
+	msg := &Message{
+		Status:   storage.AlertFiring,
+		Rule:     "cudascope_test",
+		NodeID:   "test-node",
+		Severity: alert.SeverityInfo,
+		Alerts: []storage.Alert{{
+			Rule: "cudascope_test", Severity: alert.SeverityInfo, State: storage.AlertFiring,
+			NodeID: "test-node", GPUID: 0, Metric: "temperature", Op: ">", Thresh: 80, Value: 81,
+			StartedAt: now, FiredAt: now,
+		}},
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ch.cfg.Timeout))
+	defer cancel()
+	return ch.sender.send(ctx, msg)
+}
+
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
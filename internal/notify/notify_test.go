@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sergey/cudascope/internal/alert"
+	"github.com/sergey/cudascope/internal/storage"
+)
+
+func init() {
+	retryBackoff = 10 * time.Millisecond
+}
+
+// startManager starts the channels of a manager with one webhook channel,
+// whose config cc completes, against a server answering with the given
+// statuses. Groups are flushed by the test, with flush.
+func startManager(t *testing.T, cc ChannelConfig, statuses ...int) (*Manager, <-chan request) {
+	t.Helper()
+	srv, requests := recordingServer(t, statuses...)
+	cc.Name, cc.Type, cc.URL = "hook", TypeWebhook, srv.URL
+	m, err := New(&Config{Channels: []ChannelConfig{cc}, Default: []string{"hook"}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m.mu.Lock()
+	m.ctx = ctx
+	for _, ch := range m.channels {
+		m.start(ch)
+	}
+	m.mu.Unlock()
+	return m, requests
+}
+
+// flush sends the groups whose group_wait has passed.
+func flush(m *Manager) {
+	m.flush(time.Now().Add(time.Minute))
+}
+
+// receive waits for the next message posted to the webhook.
+func receive(t *testing.T, requests <-chan request) *Message {
+	t.Helper()
+	select {
+	case req := <-requests:
+		var msg Message
+		if err := json.Unmarshal(req.body, &msg); err != nil {
+			t.Fatalf("body %s: %v", req.body, err)
+		}
+		return &msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification sent")
+		return nil
+	}
+}
+
+// expectNone checks that nothing more is posted for a while.
+func expectNone(t *testing.T, requests <-chan request) {
+	t.Helper()
+	select {
+	case req := <-requests:
+		t.Errorf("unexpected notification: %s", req.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func hot(id int64, gpu int, state, severity string) storage.Alert {
+	return storage.Alert{ID: id, Rule: "gpu_hot", Severity: severity, State: state, NodeID: "node-1",
+		GPUID: gpu, Metric: "temperature", Op: ">", Thresh: 85, Value: 90, FiredAt: firedAt}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	m, requests := startManager(t, ChannelConfig{Retries: 3},
+		http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	m.Notify(&alert.Rule{Name: "gpu_hot"}, hot(1, 0, storage.AlertFiring, alert.SeverityWarning))
+	flush(m)
+
+	for i := 0; i < 3; i++ {
+		if msg := receive(t, requests); msg.Title() != "[FIRING:1] gpu_hot on node-1" {
+			t.Errorf("attempt %d: %q", i+1, msg.Title())
+		}
+	}
+	expectNone(t, requests)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{"permanent failure", []int{http.StatusBadRequest}, 1},
+		{"after retries", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, requests := startManager(t, ChannelConfig{Retries: 2}, tt.statuses...)
+			m.Notify(&alert.Rule{Name: "gpu_hot"}, hot(1, 0, storage.AlertFiring, alert.SeverityWarning))
+			flush(m)
+			for i := 0; i < tt.attempts; i++ {
+				receive(t, requests)
+			}
+			expectNone(t, requests)
+
+			// The next message is delivered
+			m.Notify(&alert.Rule{Name: "gpu_hot"}, hot(1, 0, storage.AlertResolved, alert.SeverityWarning))
+			flush(m)
+			if msg := receive(t, requests); msg.Status != storage.AlertResolved {
+				t.Errorf("next message: status %s, want resolved", msg.Status)
+			}
+		})
+	}
+}
+
+func TestGrouping(t *testing.T) {
+	m, requests := startManager(t, ChannelConfig{})
+	rule := &alert.Rule{Name: "gpu_hot"}
+	m.Notify(rule, hot(2, 3, storage.AlertFiring, alert.SeverityCritical))
+	m.Notify(rule, hot(1, 0, storage.AlertFiring, alert.SeverityWarning))
+	other := hot(3, 0, storage.AlertFiring, alert.SeverityWarning)
+	other.NodeID = "node-2"
+	m.Notify(rule, other)
+	flush(m)
+
+	msgs := map[string]*Message{}
+	for i := 0; i < 2; i++ {
+		msg := receive(t, requests)
+		msgs[msg.NodeID] = msg
+	}
+	msg := msgs["node-1"]
+	if msg == nil || msg.Status != storage.AlertFiring || msg.Severity != alert.SeverityCritical ||
+		len(msg.Alerts) != 2 || msg.Alerts[0].GPUID != 0 || msg.Alerts[1].GPUID != 3 {
+		t.Errorf("node-1: %+v, want both alerts, by GPU, at the highest severity", msg)
+	}
+	if msg := msgs["node-2"]; msg == nil || len(msg.Alerts) != 1 {
+		t.Errorf("node-2: %+v, want its own message", msg)
+	}
+
+	// A resolved alert is sent once, with the alerts still firing
+	m.Notify(rule, hot(1, 0, storage.AlertResolved, alert.SeverityWarning))
+	flush(m)
+	msg = receive(t, requests)
+	if msg.Title() != "[FIRING:1] gpu_hot on node-1" || len(msg.Alerts) != 2 || msg.Alerts[0].State != storage.AlertResolved {
+		t.Errorf("after resolving: %q %+v", msg.Title(), msg.Alerts)
+	}
+	m.Notify(rule, hot(2, 3, storage.AlertResolved, alert.SeverityCritical))
+	flush(m)
+	msg = receive(t, requests)
+	if msg.Title() != "[RESOLVED] gpu_hot on node-1" || len(msg.Alerts) != 1 || msg.Alerts[0].GPUID != 3 {
+		t.Errorf("after resolving all: %q %+v", msg.Title(), msg.Alerts)
+	}
+	expectNone(t, requests)
+}
+
+func TestSkipResolvedAndSilenced(t *testing.T) {
+	m, requests := startManager(t, ChannelConfig{SkipResolved: true})
+	rule := &alert.Rule{Name: "gpu_hot"}
+
+	// Silenced before group_wait passed: nothing is sent
+	m.Notify(rule, hot(1, 0, storage.AlertFiring, alert.SeverityWarning))
+	silenced := hot(1, 0, storage.AlertFiring, alert.SeverityWarning)
+	silenced.Silenced = true
+	m.Notify(rule, silenced)
+	flush(m)
+	expectNone(t, requests)
+
+	m.Notify(rule, hot(2, 1, storage.AlertFiring, alert.SeverityWarning))
+	flush(m)
+	if msg := receive(t, requests); len(msg.Alerts) != 1 || msg.Alerts[0].ID != 2 {
+		t.Errorf("firing: %+v", msg.Alerts)
+	}
+	m.Notify(rule, hot(2, 1, storage.AlertResolved, alert.SeverityWarning))
+	flush(m)
+	expectNone(t, requests)
+}
+
+func TestNewValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unnamed channel", Config{Channels: []ChannelConfig{{Type: TypeSlack, URL: "http://x"}}}},
+		{"duplicate channel", Config{Channels: []ChannelConfig{{Name: "a", Type: TypeSlack, URL: "http://x"}, {Name: "a", Type: TypeSlack, URL: "http://y"}}}},
+		{"unknown type", Config{Channels: []ChannelConfig{{Name: "a", Type: "pager", URL: "http://x"}}}},
+		{"webhook without url", Config{Channels: []ChannelConfig{{Name: "a", Type: TypeWebhook}}}},
+		{"email without recipients", Config{Channels: []ChannelConfig{{Name: "a", Type: TypeEmail, SMTPHost: "mail:25", From: "a@example.com"}}}},
+		{"unknown default", Config{Channels: []ChannelConfig{{Name: "a", Type: TypeSlack, URL: "http://x"}}, Default: []string{"b"}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(&tt.cfg); err == nil {
+			t.Errorf("%s: New succeeded", tt.name)
+		}
+	}
+
+	m, err := New(&Config{Channels: []ChannelConfig{{Name: "am", Type: TypeAlertmanager, URL: "http://am:9093"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(m.channels["am"].cfg.RepeatInterval); got != time.Minute {
+		t.Errorf("alertmanager repeat interval = %s, want 1m", got)
+	}
+	if err := m.CheckRules([]alert.Rule{{Name: "r", Notify: []string{"slack"}}}); err == nil {
+		t.Error("CheckRules accepted an unknown channel")
+	}
+}
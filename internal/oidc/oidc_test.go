@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergey/cudascope/internal/oidc/oidctest"
+)
+
+const testRedirect = "https://cudascope.example.com/auth/callback"
+
+func discover(t *testing.T, op *oidctest.Provider) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), Config{
+		Issuer:       op.Issuer,
+		ClientID:     op.ClientID,
+		ClientSecret: op.ClientSecret,
+		RedirectURL:  testRedirect,
+		Scopes:       []string{"profile", "groups"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return p
+}
+
+// authorize follows an authorization URL to the provider and returns the
+// code and state it redirects back with.
+func authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loc.Scheme + "://" + loc.Host + loc.Path; got != testRedirect {
+		t.Fatalf("redirected to %s, want %s", got, testRedirect)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestLoginRoundTrip(t *testing.T) {
+	op := oidctest.NewProvider("cudascope", "s3cret/+")
+	defer op.Close()
+	op.SetClaims(map[string]any{"groups": []string{"ml-infra"}})
+	p := discover(t, op)
+	ctx := context.Background()
+
+	verifier, challenge := NewPKCE()
+	authURL := p.AuthCodeURL("state-1", "nonce-1", challenge)
+	if !strings.Contains(authURL, "scope=openid+profile+groups") {
+		t.Errorf("auth URL %s does not request the configured scopes", authURL)
+	}
+	code, state := authorize(t, authURL)
+	if state != "state-1" {
+		t.Errorf("state = %q, want state-1", state)
+	}
+
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := p.Verify(ctx, raw, "nonce-1")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims["preferred_username"] != "alice" {
+		t.Errorf("preferred_username = %v, want alice", claims["preferred_username"])
+	}
+	if groups, _ := claims["groups"].([]any); len(groups) != 1 || groups[0] != "ml-infra" {
+		t.Errorf("groups = %v, want [ml-infra]", claims["groups"])
+	}
+
+	// A code is redeemed only once
+	if _, err := p.Exchange(ctx, code, verifier); err == nil {
+		t.Error("Exchange of a used code succeeded")
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	op := oidctest.NewProvider("cudascope", "secret")
+	defer op.Close()
+	p := discover(t, op)
+
+	_, challenge := NewPKCE()
+	code, _ := authorize(t, p.AuthCodeURL("state", "nonce", challenge))
+	other, _ := NewPKCE()
+	if _, err := p.Exchange(context.Background(), code, other); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Exchange with the wrong verifier: err = %v, want invalid_grant", err)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	op := oidctest.NewProvider("cudascope", "secret")
+	defer op.Close()
+	_, err := Discover(context.Background(), Config{Issuer: op.Issuer + "/", ClientID: "cudascope"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("err = %v, want issuer mismatch", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	op := oidctest.NewProvider("cudascope", "secret")
+	defer op.Close()
+	other := oidctest.NewProvider("cudascope", "secret")
+	defer other.Close()
+	p := discover(t, op)
+
+	with := func(k string, v any) string {
+		claims := op.Claims("nonce")
+		claims[k] = v
+		return op.Sign(claims)
+	}
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid", op.Sign(op.Claims("nonce")), ""},
+		{"audience list", with("aud", []string{"other", "cudascope"}), ""},
+		{"within clock skew", with("exp", time.Now().Add(-30*time.Second).Unix()), ""},
+		{"wrong nonce", with("nonce", "replayed"), "nonce mismatch"},
+		{"missing nonce", with("nonce", nil), "nonce mismatch"},
+		{"expired", with("exp", time.Now().Add(-time.Hour).Unix()), "expired"},
+		{"no expiry", with("exp", nil), "expired"},
+		{"wrong issuer", with("iss", "https://evil.example.com"), "issuer"},
+		{"wrong audience", with("aud", "grafana"), "not for client"},
+		{"signed by another key", other.Sign(op.Claims("nonce")), "signature"},
+		{"tampered claims", tamper(op.Sign(op.Claims("nonce")), with("sub", "root")), "signature"},
+		{"unsigned", unsigned(op.Claims("nonce")), "algorithm"},
+		{"malformed", "not-a-jwt", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Verify(context.Background(), tt.token, "nonce")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Verify: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Verify: err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of a signed token with those of another.
+func tamper(token, claimsFrom string) string {
+	a, b := strings.Split(token, "."), strings.Split(claimsFrom, ".")
+	return a[0] + "." + b[1] + "." + a[2]
+}
+
+// unsigned returns a token with alg "none" and no signature.
+func unsigned(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": oidctest.KeyID})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
@@ -0,0 +1,173 @@
+// Package rulesfile loads alert rules, notification channels and silences
+// from a YAML file, and watches it for changes so they can be reloaded
+// without a restart.
+package rulesfile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sergey/cudascope/internal/alert"
+	"github.com/sergey/cudascope/internal/notify"
+	"github.com/sergey/cudascope/internal/storage"
+	"github.com/sergey/cudascope/internal/yaml"
+)
+
+// File is the rules file.
+type File struct {
+	Rules    []alert.Rule   `json:"rules"`
+	Notify   *notify.Config `json:"notify,omitempty"`
+	Silences []Silence      `json:"silences,omitempty"`
+}
+
+// Silence is a silence kept in the rules file. Without ends_at it lasts
+// until it is removed from the file.
+type Silence struct {
+	NodeID   string    `json:"node_id,omitempty"` // node ID glob
+	GPUID    *int      `json:"gpu_id,omitempty"`
+	Metric   string    `json:"metric,omitempty"`
+	Rule     string    `json:"rule,omitempty"`
+	StartsAt time.Time `json:"starts_at"` // RFC 3339, optional
+	EndsAt   time.Time `json:"ends_at"`   // RFC 3339, optional
+	Comment  string    `json:"comment,omitempty"`
+}
+
+// Config is the alerting config: the rules file combined with the rules
+// and channels given on the command line.
+type Config struct {
+	Rules    []alert.Rule
+	Notify   *notify.Config // nil = no channels
+	Silences []storage.Silence
+}
+
+// Loader loads the rules file. The rules and channels given on the command
+// line are fixed; they are combined with the file every time it is loaded.
+type Loader struct {
+	File   string         // "" = no rules file
+	Rules  []alert.Rule   // --alert-* thresholds and --alert-rules
+	Notify *notify.Config // --notify-config, or nil
+}
+
+// Load reads and validates the rules file.
+func (l *Loader) Load() (*Config, error) {
+	if l.File == "" {
+		return l.Parse(nil)
+	}
+	data, err := os.ReadFile(l.File)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := l.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", l.File, err)
+	}
+	return cfg, nil
+}
+
+// Parse validates a rules file as it would be loaded, without applying it.
+func (l *Loader) Parse(data []byte) (*Config, error) {
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Rules:  append(append([]alert.Rule{}, l.Rules...), f.Rules...),
+		Notify: l.Notify,
+	}
+	if err := alert.ValidateRules(cfg.Rules); err != nil {
+		return nil, err
+	}
+
+	if f.Notify != nil {
+		if l.Notify != nil {
+			return nil, fmt.Errorf("notify: channels are already configured with --notify-config")
+		}
+		cfg.Notify = f.Notify
+	}
+	nc := cfg.Notify
+	if nc == nil {
+		nc = &notify.Config{}
+	}
+	mgr, err := notify.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("notify: %w", err)
+	}
+	if err := mgr.CheckRules(cfg.Rules); err != nil {
+		return nil, err
+	}
+
+	for i, s := range f.Silences {
+		sil := storage.Silence{
+			NodeID:    s.NodeID,
+			GPUID:     s.GPUID,
+			Metric:    s.Metric,
+			Rule:      s.Rule,
+			Comment:   s.Comment,
+			CreatedBy: "rules file",
+		}
+		if !s.StartsAt.IsZero() {
+			sil.StartsAt = s.StartsAt.Unix()
+		}
+		if !s.EndsAt.IsZero() {
+			sil.EndsAt = s.EndsAt.Unix()
+			if sil.EndsAt <= sil.StartsAt {
+				return nil, fmt.Errorf("silences[%d]: ends_at must be after starts_at", i)
+			}
+		}
+		if err := alert.ValidateSilence(&sil); err != nil {
+			return nil, fmt.Errorf("silences[%d]: %w", i, err)
+		}
+		cfg.Silences = append(cfg.Silences, sil)
+	}
+	return cfg, nil
+}
+
+// Watch calls reload when the file changes, checking every interval, or
+// when the process receives SIGHUP. Blocks until ctx is cancelled.
+func Watch(ctx context.Context, file string, interval time.Duration, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stat(file)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("SIGHUP: reloading %s", file)
+			last = stat(file)
+			reload()
+		case <-ticker.C:
+			if fi := stat(file); !fi.modTime.Equal(last.modTime) || fi.size != last.size {
+				log.Printf("%s changed, reloading", file)
+				last = fi
+				reload()
+			}
+		}
+	}
+}
+
+type fileInfo struct {
+	modTime time.Time
+	size    int64
+}
+
+// stat returns the modification time and size of a file (zero if it
+// cannot be read, e.g. while it is being replaced).
+func stat(file string) fileInfo {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return fileInfo{}
+	}
+	return fileInfo{fi.ModTime(), fi.Size()}
+}
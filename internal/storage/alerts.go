@@ -0,0 +1,126 @@
+package storage
+
+import "database/sql"
+
+// Alert states.
+const (
+	AlertPending  = "pending"  // condition met, waiting out the rule's for duration
+	AlertFiring   = "firing"   // condition held for the for duration
+	AlertResolved = "resolved" // condition cleared after firing
+)
+
+// Alert is one instance of an alert rule firing for a node or GPU.
+type Alert struct {
+	ID         int64   `json:"id"`
+	Rule       string  `json:"rule"`
+	Severity   string  `json:"severity"`
+	State      string  `json:"state"`
+	NodeID     string  `json:"node_id"`
+	GPUID      int     `json:"gpu_id"` // -1 for host alerts
+	Metric     string  `json:"metric"`
+	Op         string  `json:"op"`
+	Thresh     float64 `json:"threshold"`
+	Scope      string  `json:"scope,omitempty"` // override that set the threshold, e.g. "model=*RTX 4090*" (empty = the rule's)
+	Value      float64 `json:"value"`
+	StartedAt  int64   `json:"started_at"`
+	FiredAt    int64   `json:"fired_at,omitempty"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+	Silenced   bool    `json:"silenced"` // by a silence or node maintenance (not stored)
+}
+
+// AlertsQuery filters the alert history. Alerts active at any time in
+// [From, To] are returned.
+type AlertsQuery struct {
+	From     int64
+	To       int64
+	NodeID   string // empty = all nodes
+	Rule     string // empty = all rules
+	Severity string // empty = all severities
+	State    string // empty = all states
+}
+
+const alertCols = `id, rule, severity, state, node_id, gpu_id, metric, op, threshold, scope, value,
+	started_at, COALESCE(fired_at, 0), COALESCE(resolved_at, 0)`
+
+// InsertAlert stores a new alert and sets its ID.
+func (db *DB) InsertAlert(a *Alert) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	res, err := db.conn.Exec(`INSERT INTO alerts (rule, severity, state, node_id, gpu_id, metric, op, threshold, scope, value,
+			started_at, fired_at, resolved_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0))`,
+		a.Rule, a.Severity, a.State, a.NodeID, a.GPUID, a.Metric, a.Op, a.Thresh, a.Scope, a.Value,
+		a.StartedAt, a.FiredAt, a.ResolvedAt,
+	)
+	if err != nil {
+		return err
+	}
+	a.ID, err = res.LastInsertId()
+	return err
+}
+
+// UpdateAlert stores a state transition of an alert, with the threshold
+// in effect at the time.
+func (db *DB) UpdateAlert(a *Alert) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.conn.Exec(`UPDATE alerts SET state = ?, threshold = ?, scope = ?, value = ?, fired_at = NULLIF(?, 0), resolved_at = NULLIF(?, 0)
+		WHERE id = ?`, a.State, a.Thresh, a.Scope, a.Value, a.FiredAt, a.ResolvedAt, a.ID)
+	return err
+}
+
+// DeleteAlert removes an alert that never fired.
+func (db *DB) DeleteAlert(id int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.conn.Exec("DELETE FROM alerts WHERE id = ?", id)
+	return err
+}
+
+// GetOpenAlerts returns pending and firing alerts.
+func (db *DB) GetOpenAlerts() ([]Alert, error) {
+	rows, err := db.conn.Query("SELECT "+alertCols+" FROM alerts WHERE state != ? ORDER BY id", AlertResolved)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlerts(rows)
+}
+
+// GetAlertHistory returns alerts active in the query range, newest first.
+func (db *DB) GetAlertHistory(q AlertsQuery) ([]Alert, error) {
+	query := "SELECT " + alertCols + " FROM alerts WHERE started_at <= ? AND (resolved_at IS NULL OR resolved_at >= ?)"
+	args := []any{q.To, q.From}
+	for _, f := range []struct{ col, val string }{
+		{"node_id", q.NodeID}, {"rule", q.Rule}, {"severity", q.Severity}, {"state", q.State},
+	} {
+		if f.val != "" {
+			query += " AND " + f.col + " = ?"
+			args = append(args, f.val)
+		}
+	}
+	query += " ORDER BY started_at DESC, id DESC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlerts(rows)
+}
+
+func scanAlerts(rows *sql.Rows) ([]Alert, error) {
+	defer rows.Close()
+	var alerts []Alert
+	for rows.Next() {
+		var a Alert
+		err := rows.Scan(&a.ID, &a.Rule, &a.Severity, &a.State, &a.NodeID, &a.GPUID, &a.Metric, &a.Op, &a.Thresh, &a.Scope, &a.Value,
+			&a.StartedAt, &a.FiredAt, &a.ResolvedAt)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/sergey/cudascope/internal/collector"
+)
+
+// AnomalyBaseline is the EWMA mean and variance of one GPU metric while the
+// GPU's utilization is in one band.
+type AnomalyBaseline struct {
+	NodeID string
+	GPUID  int
+	Metric string
+	Band   int
+	N      int64 // samples seen
+	Mean   float64
+	Var    float64
+	TS     int64 // last sample
+}
+
+// Anomaly is a GPU metric that deviates from the GPU's own baseline or from
+// its peers (GPUs of the same model on the same node).
+type Anomaly struct {
+	NodeID   string  `json:"node_id"`
+	GPUID    int     `json:"gpu_id"`
+	Metric   string  `json:"metric"`
+	Kind     string  `json:"kind"` // "baseline" or "peers"
+	Since    int64   `json:"since"`
+	TS       int64   `json:"ts"` // last anomalous sample
+	GPUUtil  float64 `json:"gpu_util"`
+	Value    float64 `json:"value"`
+	Expected float64 `json:"expected"`
+	Score    float64 `json:"score"` // deviations from expected, signed
+}
+
+// RollupSample is one GPU's 1-minute averages that anomaly detection uses.
+type RollupSample struct {
+	TS          int64
+	NodeID      string
+	GPUID       int
+	GPUUtil     float64
+	Temperature float64
+	PowerDraw   float64
+	FanSpeed    float64
+}
+
+// GetGPURollups returns the 1-minute rollups of physical GPUs in (from, to],
+// oldest first.
+func (db *DB) GetGPURollups(from, to int64) ([]RollupSample, error) {
+	rows, err := db.conn.Query(`SELECT ts, COALESCE(node_id, 'local'), gpu_id, COALESCE(gpu_util_avg, 0),
+			COALESCE(temperature_avg, 0), COALESCE(power_draw_avg, 0), COALESCE(fan_speed_avg, 0)
+		FROM gpu_metrics_1m WHERE ts > ? AND ts <= ? AND `+physicalGPUs("gpu_metrics_1m", true)+`
+		ORDER BY ts, node_id, gpu_id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []RollupSample
+	for rows.Next() {
+		var s RollupSample
+		if err := rows.Scan(&s.TS, &s.NodeID, &s.GPUID, &s.GPUUtil, &s.Temperature, &s.PowerDraw, &s.FanSpeed); err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// GetAnomalyBaselines returns all anomaly detection baselines.
+func (db *DB) GetAnomalyBaselines() ([]AnomalyBaseline, error) {
+	rows, err := db.conn.Query("SELECT node_id, gpu_id, metric, band, n, mean, var, ts FROM anomaly_baselines")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var baselines []AnomalyBaseline
+	for rows.Next() {
+		var b AnomalyBaseline
+		if err := rows.Scan(&b.NodeID, &b.GPUID, &b.Metric, &b.Band, &b.N, &b.Mean, &b.Var, &b.TS); err != nil {
+			return nil, err
+		}
+		baselines = append(baselines, b)
+	}
+	return baselines, rows.Err()
+}
+
+// GetAnomalies returns the current anomalies last seen at or after since,
+// optionally of one node, highest score first.
+func (db *DB) GetAnomalies(since int64, nodeID string) ([]Anomaly, error) {
+	query := `SELECT node_id, gpu_id, metric, kind, since, ts, gpu_util, value, expected, score
+		FROM gpu_anomalies WHERE ts >= ?`
+	args := []any{since}
+	if nodeID != "" {
+		query += " AND node_id = ?"
+		args = append(args, nodeID)
+	}
+	rows, err := db.conn.Query(query+" ORDER BY ABS(score) DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anomalies []Anomaly
+	for rows.Next() {
+		var a Anomaly
+		if err := rows.Scan(&a.NodeID, &a.GPUID, &a.Metric, &a.Kind, &a.Since, &a.TS, &a.GPUUtil, &a.Value, &a.Expected, &a.Score); err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, rows.Err()
+}
+
+// SaveAnomalyState stores updated baselines, replaces the current anomalies
+// and logs new ones as GPU events, in one transaction.
+func (db *DB) SaveAnomalyState(baselines []AnomalyBaseline, anomalies []Anomaly, events []collector.GPUEvent) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO anomaly_baselines (node_id, gpu_id, metric, band, n, mean, var, ts)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, b := range baselines {
+			if _, err := stmt.Exec(b.NodeID, b.GPUID, b.Metric, b.Band, b.N, b.Mean, b.Var, b.TS); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec("DELETE FROM gpu_anomalies"); err != nil {
+			return err
+		}
+		for _, a := range anomalies {
+			_, err := tx.Exec(`INSERT INTO gpu_anomalies (node_id, gpu_id, metric, kind, since, ts, gpu_util, value, expected, score)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				a.NodeID, a.GPUID, a.Metric, a.Kind, a.Since, a.TS, a.GPUUtil, a.Value, a.Expected, a.Score)
+			if err != nil {
+				return err
+			}
+		}
+		return writeGPUEvents(tx, events)
+	})
+}
@@ -24,6 +24,48 @@ var migration003 string
 //go:embed migrations/004_rollup_unique.sql
 var migration004 string
 
+//go:embed migrations/005_memory_health.sql
+var migration005 string
+
+//go:embed migrations/006_gpu_events.sql
+var migration006 string
+
+//go:embed migrations/007_throttle.sql
+var migration007 string
+
+//go:embed migrations/008_nvlink.sql
+var migration008 string
+
+//go:embed migrations/009_mig.sql
+var migration009 string
+
+//go:embed migrations/010_process_util.sql
+var migration010 string
+
+//go:embed migrations/011_process_attribution.sql
+var migration011 string
+
+//go:embed migrations/012_jobs.sql
+var migration012 string
+
+//go:embed migrations/013_device_hash.sql
+var migration013 string
+
+//go:embed migrations/014_tokens.sql
+var migration014 string
+
+//go:embed migrations/015_alerts.sql
+var migration015 string
+
+//go:embed migrations/016_silences.sql
+var migration016 string
+
+//go:embed migrations/017_overrides.sql
+var migration017 string
+
+//go:embed migrations/018_anomalies.sql
+var migration018 string
+
 // DB wraps a SQLite connection with metrics-specific operations.
 type DB struct {
 	conn *sql.DB
@@ -93,6 +135,104 @@ func (db *DB) migrate() error {
 		log.Println("applied migration 004 (rollup unique constraints)")
 	}
 
+	if version < 5 {
+		if _, err := db.conn.Exec(migration005); err != nil {
+			return fmt.Errorf("migration 005: %w", err)
+		}
+		log.Println("applied migration 005 (memory health)")
+	}
+
+	if version < 6 {
+		if _, err := db.conn.Exec(migration006); err != nil {
+			return fmt.Errorf("migration 006: %w", err)
+		}
+		log.Println("applied migration 006 (gpu events)")
+	}
+
+	if version < 7 {
+		if _, err := db.conn.Exec(migration007); err != nil {
+			return fmt.Errorf("migration 007: %w", err)
+		}
+		log.Println("applied migration 007 (throttle reasons)")
+	}
+
+	if version < 8 {
+		if _, err := db.conn.Exec(migration008); err != nil {
+			return fmt.Errorf("migration 008: %w", err)
+		}
+		log.Println("applied migration 008 (nvlink)")
+	}
+
+	if version < 9 {
+		if _, err := db.conn.Exec(migration009); err != nil {
+			return fmt.Errorf("migration 009: %w", err)
+		}
+		log.Println("applied migration 009 (mig)")
+	}
+
+	if version < 10 {
+		if _, err := db.conn.Exec(migration010); err != nil {
+			return fmt.Errorf("migration 010: %w", err)
+		}
+		log.Println("applied migration 010 (process utilization)")
+	}
+
+	if version < 11 {
+		if _, err := db.conn.Exec(migration011); err != nil {
+			return fmt.Errorf("migration 011: %w", err)
+		}
+		log.Println("applied migration 011 (process attribution)")
+	}
+
+	if version < 12 {
+		if _, err := db.conn.Exec(migration012); err != nil {
+			return fmt.Errorf("migration 012: %w", err)
+		}
+		log.Println("applied migration 012 (jobs)")
+	}
+
+	if version < 13 {
+		if _, err := db.conn.Exec(migration013); err != nil {
+			return fmt.Errorf("migration 013: %w", err)
+		}
+		log.Println("applied migration 013 (device hash)")
+	}
+
+	if version < 14 {
+		if _, err := db.conn.Exec(migration014); err != nil {
+			return fmt.Errorf("migration 014: %w", err)
+		}
+		log.Println("applied migration 014 (tokens)")
+	}
+
+	if version < 15 {
+		if _, err := db.conn.Exec(migration015); err != nil {
+			return fmt.Errorf("migration 015: %w", err)
+		}
+		log.Println("applied migration 015 (alerts)")
+	}
+
+	if version < 16 {
+		if _, err := db.conn.Exec(migration016); err != nil {
+			return fmt.Errorf("migration 016: %w", err)
+		}
+		log.Println("applied migration 016 (silences)")
+	}
+
+	if version < 17 {
+		if _, err := db.conn.Exec(migration017); err != nil {
+			return fmt.Errorf("migration 017: %w", err)
+		}
+		log.Println("applied migration 017 (overrides)")
+	}
+
+	if version < 18 {
+		if _, err := db.conn.Exec(migration018); err != nil {
+			return fmt.Errorf("migration 018: %w", err)
+		}
+		log.Println("applied migration 018 (anomalies)")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sergey/cudascope/internal/collector"
+)
+
+// JobsQuery filters the job list. Jobs overlapping [From, To] are returned.
+type JobsQuery struct {
+	From   int64
+	To     int64
+	User   string // empty = all users
+	NodeID string // empty = all nodes
+}
+
+// jobSample aggregates one process batch for a job.
+type jobSample struct {
+	user, name  string
+	first, last int64
+	mem         map[string]uint64 // node -> total GPU memory of the job's processes
+	utilSum     float64
+	utilN       int
+}
+
+// updateJobs folds a process batch into the jobs and job_gpus tables.
+// Processes without a job ID are ignored.
+func updateJobs(tx *sql.Tx, procs []collector.GPUProcess) error {
+	jobs := make(map[string]*jobSample)
+	type gpuKey struct {
+		job, node string
+		gpu       int
+	}
+	gpus := make(map[gpuKey][2]int64)
+
+	for _, p := range procs {
+		if p.JobID == "" {
+			continue
+		}
+		nodeID := p.NodeID
+		if nodeID == "" {
+			nodeID = "local"
+		}
+
+		j, ok := jobs[p.JobID]
+		if !ok {
+			j = &jobSample{first: p.Timestamp, last: p.Timestamp, mem: make(map[string]uint64)}
+			jobs[p.JobID] = j
+		}
+		if j.user == "" {
+			j.user = p.JobUser
+		}
+		if j.name == "" {
+			j.name = p.JobName
+		}
+		j.first = min(j.first, p.Timestamp)
+		j.last = max(j.last, p.Timestamp)
+		j.mem[nodeID] += p.GPUMem
+		j.utilSum += p.SMUtil
+		j.utilN++
+
+		k := gpuKey{p.JobID, nodeID, p.GPUID}
+		if span, ok := gpus[k]; ok {
+			gpus[k] = [2]int64{min(span[0], p.Timestamp), max(span[1], p.Timestamp)}
+		} else {
+			gpus[k] = [2]int64{p.Timestamp, p.Timestamp}
+		}
+	}
+
+	for id, j := range jobs {
+		var peak uint64
+		for _, m := range j.mem {
+			peak = max(peak, m)
+		}
+		_, err := tx.Exec(`INSERT INTO jobs (job_id, user, name, first_seen, last_seen, peak_mem, util_sum, util_samples)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(job_id) DO UPDATE SET
+				user = COALESCE(NULLIF(jobs.user, ''), excluded.user),
+				name = COALESCE(NULLIF(jobs.name, ''), excluded.name),
+				first_seen = MIN(jobs.first_seen, excluded.first_seen),
+				last_seen = MAX(jobs.last_seen, excluded.last_seen),
+				peak_mem = MAX(jobs.peak_mem, excluded.peak_mem),
+				util_sum = jobs.util_sum + excluded.util_sum,
+				util_samples = jobs.util_samples + excluded.util_samples`,
+			id, j.user, j.name, j.first, j.last, peak, j.utilSum, j.utilN)
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, span := range gpus {
+		_, err := tx.Exec(`INSERT INTO job_gpus (job_id, node_id, gpu_id, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(job_id, node_id, gpu_id) DO UPDATE SET
+				first_seen = MIN(job_gpus.first_seen, excluded.first_seen),
+				last_seen = MAX(job_gpus.last_seen, excluded.last_seen)`,
+			k.job, k.node, k.gpu, span[0], span[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const jobCols = `job_id, COALESCE(user, ''), COALESCE(name, ''), first_seen, last_seen, COALESCE(peak_mem, 0),
+	CASE WHEN util_samples > 0 THEN util_sum / util_samples ELSE 0 END`
+
+// GetJobs returns jobs seen within a time range, most recent first.
+func (db *DB) GetJobs(q JobsQuery) ([]collector.Job, error) {
+	query := "SELECT " + jobCols + " FROM jobs WHERE last_seen >= ? AND first_seen <= ?"
+	args := []any{q.From, q.To}
+	if q.User != "" {
+		query += " AND user = ?"
+		args = append(args, q.User)
+	}
+	if q.NodeID != "" {
+		query += " AND job_id IN (SELECT job_id FROM job_gpus WHERE node_id = ?)"
+		args = append(args, q.NodeID)
+	}
+	query += " ORDER BY last_seen DESC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []collector.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadJobGPUs(jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetJob returns a single job, or nil if it is unknown.
+func (db *DB) GetJob(id string) (*collector.Job, error) {
+	j, err := scanJob(db.conn.QueryRow("SELECT "+jobCols+" FROM jobs WHERE job_id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []collector.Job{j}
+	if err := db.loadJobGPUs(jobs); err != nil {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func scanJob(row interface{ Scan(...any) error }) (collector.Job, error) {
+	var j collector.Job
+	err := row.Scan(&j.JobID, &j.User, &j.Name, &j.FirstSeen, &j.LastSeen, &j.PeakMem, &j.AvgUtil)
+	return j, err
+}
+
+// jobGPUsBatch bounds the job IDs per job_gpus query, well below SQLite's
+// limit on bound parameters.
+const jobGPUsBatch = 500
+
+// loadJobGPUs fills in the GPUs of each job and its GPU-seconds.
+func (db *DB) loadJobGPUs(jobs []collector.Job) error {
+	index := make(map[string]int, len(jobs))
+	for i := range jobs {
+		index[jobs[i].JobID] = i
+		jobs[i].GPUs = []collector.JobGPU{}
+	}
+
+	for start := 0; start < len(jobs); start += jobGPUsBatch {
+		batch := jobs[start:min(start+jobGPUsBatch, len(jobs))]
+		args := make([]any, len(batch))
+		for i := range batch {
+			args[i] = batch[i].JobID
+		}
+		if err := db.queryJobGPUs(jobs, index, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryJobGPUs adds the GPUs of the jobs with the given IDs.
+func (db *DB) queryJobGPUs(jobs []collector.Job, index map[string]int, ids []any) error {
+	rows, err := db.conn.Query(fmt.Sprintf(`SELECT job_id, node_id, gpu_id, first_seen, last_seen FROM job_gpus
+		WHERE job_id IN (?%s) ORDER BY node_id, gpu_id`, strings.Repeat(", ?", len(ids)-1)), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var g collector.JobGPU
+		if err := rows.Scan(&id, &g.NodeID, &g.GPUID, &g.FirstSeen, &g.LastSeen); err != nil {
+			return err
+		}
+		j := &jobs[index[id]]
+		j.GPUs = append(j.GPUs, g)
+		j.GPUSeconds += float64(g.LastSeen - g.FirstSeen)
+	}
+	return rows.Err()
+}
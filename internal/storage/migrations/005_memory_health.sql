@@ -0,0 +1,43 @@
+-- Migration 005: GPU memory health (ECC, retired pages, row remapping)
+-- Counters and flags; rollup tiers keep the bucket MAX under the same names.
+
+ALTER TABLE gpu_metrics_raw ADD COLUMN ecc_enabled INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN ecc_corr_volatile INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN ecc_uncorr_volatile INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN ecc_corr_aggregate INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN ecc_uncorr_aggregate INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN retired_pages_sbe INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN retired_pages_dbe INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN retired_pending INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN remapped_corr INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN remapped_uncorr INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN remap_pending INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN remap_failed INTEGER DEFAULT 0;
+
+ALTER TABLE gpu_metrics_1m ADD COLUMN ecc_enabled INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN ecc_corr_volatile INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN ecc_uncorr_volatile INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN ecc_corr_aggregate INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN ecc_uncorr_aggregate INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN retired_pages_sbe INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN retired_pages_dbe INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN retired_pending INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN remapped_corr INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN remapped_uncorr INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN remap_pending INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN remap_failed INTEGER DEFAULT 0;
+
+ALTER TABLE gpu_metrics_1h ADD COLUMN ecc_enabled INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN ecc_corr_volatile INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN ecc_uncorr_volatile INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN ecc_corr_aggregate INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN ecc_uncorr_aggregate INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN retired_pages_sbe INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN retired_pages_dbe INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN retired_pending INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN remapped_corr INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN remapped_uncorr INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN remap_pending INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN remap_failed INTEGER DEFAULT 0;
+
+INSERT INTO schema_version (version) VALUES (5);
@@ -0,0 +1,13 @@
+-- Migration 006: GPU event log (XID errors, ECC errors, power source, clock changes)
+CREATE TABLE IF NOT EXISTS gpu_events (
+    ts          INTEGER NOT NULL,
+    node_id     TEXT NOT NULL DEFAULT 'local',
+    gpu_id      INTEGER NOT NULL,
+    event_type  TEXT NOT NULL,
+    xid         INTEGER,
+    description TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_gpu_events_ts ON gpu_events(ts);
+CREATE INDEX IF NOT EXISTS idx_gpu_events_node ON gpu_events(node_id, gpu_id, ts);
+
+INSERT INTO schema_version (version) VALUES (6);
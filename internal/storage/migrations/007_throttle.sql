@@ -0,0 +1,29 @@
+-- Migration 007: clock throttle tracking
+-- Raw samples keep the throttle reason bitmask; rollups keep the seconds
+-- spent throttled per reason within each bucket.
+
+ALTER TABLE gpu_metrics_raw ADD COLUMN throttle_reasons INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN clock_gfx_app INTEGER DEFAULT 0;
+ALTER TABLE gpu_metrics_raw ADD COLUMN clock_mem_app INTEGER DEFAULT 0;
+
+ALTER TABLE gpu_metrics_1m ADD COLUMN throttle_sw_power_cap_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN throttle_hw_slowdown_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN throttle_sync_boost_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN throttle_sw_thermal_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN throttle_hw_thermal_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1m ADD COLUMN throttle_hw_power_brake_s REAL DEFAULT 0;
+
+ALTER TABLE gpu_metrics_1h ADD COLUMN throttle_sw_power_cap_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN throttle_hw_slowdown_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN throttle_sync_boost_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN throttle_sw_thermal_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN throttle_hw_thermal_s REAL DEFAULT 0;
+ALTER TABLE gpu_metrics_1h ADD COLUMN throttle_hw_power_brake_s REAL DEFAULT 0;
+
+-- Static clock limits and thermal thresholds per device
+ALTER TABLE gpu_devices ADD COLUMN clock_gfx_max INTEGER DEFAULT 0;
+ALTER TABLE gpu_devices ADD COLUMN clock_mem_max INTEGER DEFAULT 0;
+ALTER TABLE gpu_devices ADD COLUMN temp_slowdown INTEGER DEFAULT 0;
+ALTER TABLE gpu_devices ADD COLUMN temp_shutdown INTEGER DEFAULT 0;
+
+INSERT INTO schema_version (version) VALUES (7);
@@ -0,0 +1,20 @@
+-- Migration 008: NVLink state and counters (raw samples only)
+CREATE TABLE IF NOT EXISTS nvlink_metrics (
+    ts              INTEGER NOT NULL,
+    node_id         TEXT NOT NULL DEFAULT 'local',
+    gpu_id          INTEGER NOT NULL,
+    link            INTEGER NOT NULL,
+    active          INTEGER NOT NULL,
+    remote_type     TEXT,
+    remote_pci      TEXT,
+    remote_gpu_id   INTEGER,
+    tx_kbps         INTEGER,
+    rx_kbps         INTEGER,
+    crc_errors      INTEGER,
+    replay_errors   INTEGER,
+    recovery_errors INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_nvlink_ts ON nvlink_metrics(ts);
+CREATE INDEX IF NOT EXISTS idx_nvlink_node ON nvlink_metrics(node_id, gpu_id, ts);
+
+INSERT INTO schema_version (version) VALUES (8);
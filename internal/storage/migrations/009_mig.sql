@@ -0,0 +1,12 @@
+-- Migration 009: MIG instances as child devices in gpu_devices.
+-- Physical GPUs keep parent_uuid NULL; MIG instances reference their parent.
+ALTER TABLE gpu_devices ADD COLUMN mig_enabled INTEGER DEFAULT 0;
+ALTER TABLE gpu_devices ADD COLUMN parent_uuid TEXT;
+ALTER TABLE gpu_devices ADD COLUMN parent_id INTEGER;
+ALTER TABLE gpu_devices ADD COLUMN mig_profile TEXT;
+ALTER TABLE gpu_devices ADD COLUMN gi_id INTEGER;
+ALTER TABLE gpu_devices ADD COLUMN ci_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_gpu_devices_uuid ON gpu_devices(uuid);
+
+INSERT INTO schema_version (version) VALUES (9);
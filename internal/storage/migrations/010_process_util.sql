@@ -0,0 +1,7 @@
+-- Migration 010: per-process utilization (SM, memory, encoder, decoder %)
+ALTER TABLE gpu_processes ADD COLUMN sm_util REAL DEFAULT 0;
+ALTER TABLE gpu_processes ADD COLUMN mem_util REAL DEFAULT 0;
+ALTER TABLE gpu_processes ADD COLUMN enc_util REAL DEFAULT 0;
+ALTER TABLE gpu_processes ADD COLUMN dec_util REAL DEFAULT 0;
+
+INSERT INTO schema_version (version) VALUES (10);
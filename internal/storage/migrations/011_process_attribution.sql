@@ -0,0 +1,11 @@
+-- Migration 011: process attribution (command line, owner, cgroup, container, Kubernetes pod)
+ALTER TABLE gpu_processes ADD COLUMN cmdline TEXT;
+ALTER TABLE gpu_processes ADD COLUMN uid INTEGER DEFAULT -1;
+ALTER TABLE gpu_processes ADD COLUMN username TEXT;
+ALTER TABLE gpu_processes ADD COLUMN cgroup TEXT;
+ALTER TABLE gpu_processes ADD COLUMN container_id TEXT;
+ALTER TABLE gpu_processes ADD COLUMN container_name TEXT;
+ALTER TABLE gpu_processes ADD COLUMN pod_namespace TEXT;
+ALTER TABLE gpu_processes ADD COLUMN pod_name TEXT;
+
+INSERT INTO schema_version (version) VALUES (11);
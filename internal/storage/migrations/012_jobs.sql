@@ -0,0 +1,29 @@
+-- Migration 012: Slurm job correlation and per-job accounting
+ALTER TABLE gpu_processes ADD COLUMN job_id TEXT;
+ALTER TABLE gpu_processes ADD COLUMN job_user TEXT;
+ALTER TABLE gpu_processes ADD COLUMN job_name TEXT;
+
+-- One row per job; accounting is updated as process samples arrive
+CREATE TABLE IF NOT EXISTS jobs (
+    job_id       TEXT PRIMARY KEY,
+    user         TEXT,
+    name         TEXT,
+    first_seen   INTEGER NOT NULL,
+    last_seen    INTEGER NOT NULL,
+    peak_mem     INTEGER DEFAULT 0,
+    util_sum     REAL DEFAULT 0,
+    util_samples INTEGER DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_last_seen ON jobs(last_seen);
+
+-- GPUs each job ran on, used to stitch the job's GPU time series
+CREATE TABLE IF NOT EXISTS job_gpus (
+    job_id     TEXT NOT NULL,
+    node_id    TEXT NOT NULL,
+    gpu_id     INTEGER NOT NULL,
+    first_seen INTEGER NOT NULL,
+    last_seen  INTEGER NOT NULL,
+    PRIMARY KEY (job_id, node_id, gpu_id)
+);
+
+INSERT INTO schema_version (version) VALUES (12);
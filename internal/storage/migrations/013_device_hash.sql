@@ -0,0 +1,4 @@
+-- Migration 013: GPU inventory fingerprint per node, checked on ingest
+ALTER TABLE nodes ADD COLUMN device_hash TEXT;
+
+INSERT INTO schema_version (version) VALUES (13);
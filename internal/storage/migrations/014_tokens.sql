@@ -0,0 +1,14 @@
+-- Migration 014: API tokens with roles (only a SHA-256 hash of each token is stored)
+CREATE TABLE IF NOT EXISTS tokens (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT NOT NULL,
+    hash       TEXT NOT NULL UNIQUE,
+    prefix     TEXT NOT NULL,
+    role       TEXT NOT NULL,
+    node_id    TEXT,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER,
+    last_used  INTEGER
+);
+
+INSERT INTO schema_version (version) VALUES (14);
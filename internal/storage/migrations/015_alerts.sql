@@ -0,0 +1,20 @@
+-- Migration 015: alert instances and their state transitions
+CREATE TABLE IF NOT EXISTS alerts (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule        TEXT NOT NULL,
+    severity    TEXT NOT NULL,
+    state       TEXT NOT NULL,      -- pending, firing, resolved
+    node_id     TEXT NOT NULL,
+    gpu_id      INTEGER NOT NULL,   -- -1 for host alerts
+    metric      TEXT NOT NULL,
+    op          TEXT NOT NULL,
+    threshold   REAL NOT NULL,
+    value       REAL NOT NULL,      -- at the last transition
+    started_at  INTEGER NOT NULL,   -- condition first met
+    fired_at    INTEGER,
+    resolved_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_state ON alerts(state);
+CREATE INDEX IF NOT EXISTS idx_alerts_started ON alerts(started_at);
+
+INSERT INTO schema_version (version) VALUES (15);
@@ -0,0 +1,18 @@
+-- Migration 016: alert silences and node maintenance mode
+CREATE TABLE IF NOT EXISTS silences (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    node_id    TEXT NOT NULL DEFAULT '',   -- node ID glob, '' = any
+    gpu_id     INTEGER,                    -- NULL = any
+    metric     TEXT NOT NULL DEFAULT '',   -- '' = any
+    rule       TEXT NOT NULL DEFAULT '',   -- '' = any
+    starts_at  INTEGER NOT NULL,
+    ends_at    INTEGER NOT NULL,
+    comment    TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_silences_ends ON silences(ends_at);
+
+ALTER TABLE nodes ADD COLUMN maintenance INTEGER NOT NULL DEFAULT 0;
+
+INSERT INTO schema_version (version) VALUES (16);
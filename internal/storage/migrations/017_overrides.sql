@@ -0,0 +1,6 @@
+-- Migration 017: node labels and threshold override scopes
+ALTER TABLE nodes ADD COLUMN labels TEXT NOT NULL DEFAULT '{}'; -- JSON object
+
+ALTER TABLE alerts ADD COLUMN scope TEXT NOT NULL DEFAULT ''; -- override that set the threshold, '' = rule
+
+INSERT INTO schema_version (version) VALUES (17);
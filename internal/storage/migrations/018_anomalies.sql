@@ -0,0 +1,28 @@
+-- Migration 018: anomaly detection baselines and current anomalies
+CREATE TABLE IF NOT EXISTS anomaly_baselines (
+    node_id TEXT NOT NULL,
+    gpu_id  INTEGER NOT NULL,
+    metric  TEXT NOT NULL,
+    band    INTEGER NOT NULL, -- GPU utilization band (10% wide)
+    n       INTEGER NOT NULL, -- samples seen
+    mean    REAL NOT NULL,    -- EWMA
+    var     REAL NOT NULL,    -- EWMA variance
+    ts      INTEGER NOT NULL, -- last sample
+    PRIMARY KEY (node_id, gpu_id, metric, band)
+);
+
+CREATE TABLE IF NOT EXISTS gpu_anomalies (
+    node_id  TEXT NOT NULL,
+    gpu_id   INTEGER NOT NULL,
+    metric   TEXT NOT NULL,
+    kind     TEXT NOT NULL,   -- 'baseline' or 'peers'
+    since    INTEGER NOT NULL,
+    ts       INTEGER NOT NULL, -- last anomalous sample
+    gpu_util REAL NOT NULL,
+    value    REAL NOT NULL,
+    expected REAL NOT NULL,
+    score    REAL NOT NULL,   -- deviations from expected, signed
+    PRIMARY KEY (node_id, gpu_id, metric, kind)
+);
+
+INSERT INTO schema_version (version) VALUES (18);
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/sergey/cudascope/internal/collector"
@@ -16,9 +18,38 @@ type GPUMetricsQuery struct {
 	To     int64
 }
 
+// GPUEventsQuery filters the GPU event log.
+type GPUEventsQuery struct {
+	NodeID string // empty = all nodes
+	GPUID  int    // -1 = all GPUs
+	Type   string // empty = all event types
+	From   int64  // unix seconds
+	To     int64
+}
+
+// memHealthCols are the GPU memory health columns. They have the same names in
+// the raw, 1m and 1h tiers; rollups keep the bucket MAX since they are
+// counters and flags.
+var memHealthCols = []string{
+	"ecc_enabled", "ecc_corr_volatile", "ecc_uncorr_volatile", "ecc_corr_aggregate", "ecc_uncorr_aggregate",
+	"retired_pages_sbe", "retired_pages_dbe", "retired_pending",
+	"remapped_corr", "remapped_uncorr", "remap_pending", "remap_failed",
+}
+
+var memHealthColList = strings.Join(memHealthCols, ", ")
+
+// memHealthFields returns pointers to the GPUMetrics fields matching memHealthCols.
+func memHealthFields(m *collector.GPUMetrics) []any {
+	return []any{
+		&m.ECCEnabled, &m.ECCCorrVolatile, &m.ECCUncorrVolatile, &m.ECCCorrAggregate, &m.ECCUncorrAggregate,
+		&m.RetiredPagesSBE, &m.RetiredPagesDBE, &m.RetiredPending,
+		&m.RemappedCorr, &m.RemappedUncorr, &m.RemapPending, &m.RemapFailed,
+	}
+}
+
 // GetNodes returns all registered nodes with online status.
 func (db *DB) GetNodes() ([]collector.Node, error) {
-	rows, err := db.conn.Query("SELECT node_id, hostname, gpu_count, first_seen, last_seen FROM nodes ORDER BY node_id")
+	rows, err := db.conn.Query("SELECT node_id, hostname, gpu_count, first_seen, last_seen, COALESCE(device_hash, ''), maintenance, labels FROM nodes ORDER BY node_id")
 	if err != nil {
 		return nil, err
 	}
@@ -28,9 +59,13 @@ func (db *DB) GetNodes() ([]collector.Node, error) {
 	var nodes []collector.Node
 	for rows.Next() {
 		var n collector.Node
-		if err := rows.Scan(&n.NodeID, &n.Hostname, &n.GPUCount, &n.FirstSeen, &n.LastSeen); err != nil {
+		var labels string
+		if err := rows.Scan(&n.NodeID, &n.Hostname, &n.GPUCount, &n.FirstSeen, &n.LastSeen, &n.DeviceHash, &n.Maintenance, &labels); err != nil {
 			return nil, err
 		}
+		if err := json.Unmarshal([]byte(labels), &n.Labels); err != nil {
+			return nil, fmt.Errorf("node %s: labels: %w", n.NodeID, err)
+		}
 		// Node is online if seen within last 60 seconds
 		n.Online = (now - n.LastSeen) < 60
 		nodes = append(nodes, n)
@@ -38,15 +73,31 @@ func (db *DB) GetNodes() ([]collector.Node, error) {
 	return nodes, rows.Err()
 }
 
+// GetNodeDeviceHash returns the device hash a node registered with, and
+// ok=false if the node is unknown.
+func (db *DB) GetNodeDeviceHash(nodeID string) (hash string, ok bool, err error) {
+	err = db.conn.QueryRow("SELECT COALESCE(device_hash, '') FROM nodes WHERE node_id = ?", nodeID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return hash, err == nil, err
+}
+
+const deviceCols = "node_id, gpu_id, uuid, name, mem_total, COALESCE(driver_ver, ''), " +
+	"COALESCE(clock_gfx_max, 0), COALESCE(clock_mem_max, 0), COALESCE(temp_slowdown, 0), COALESCE(temp_shutdown, 0), " +
+	"COALESCE(mig_enabled, 0), COALESCE(parent_uuid, ''), COALESCE(parent_id, -1), COALESCE(mig_profile, ''), " +
+	"COALESCE(gi_id, 0), COALESCE(ci_id, 0)"
+
 // GetGPUDevices returns all registered GPU devices, optionally filtered by node.
+// MIG instances sort after the physical GPUs of their node.
 func (db *DB) GetGPUDevices(nodeID string) ([]collector.GPUDevice, error) {
 	var query string
 	var args []any
 	if nodeID != "" {
-		query = "SELECT node_id, gpu_id, uuid, name, mem_total, driver_ver FROM gpu_devices WHERE node_id = ? ORDER BY gpu_id"
+		query = "SELECT " + deviceCols + " FROM gpu_devices WHERE node_id = ? ORDER BY gpu_id"
 		args = []any{nodeID}
 	} else {
-		query = "SELECT node_id, gpu_id, uuid, name, mem_total, driver_ver FROM gpu_devices ORDER BY node_id, gpu_id"
+		query = "SELECT " + deviceCols + " FROM gpu_devices ORDER BY node_id, gpu_id"
 	}
 
 	rows, err := db.conn.Query(query, args...)
@@ -57,8 +108,8 @@ func (db *DB) GetGPUDevices(nodeID string) ([]collector.GPUDevice, error) {
 
 	var devices []collector.GPUDevice
 	for rows.Next() {
-		var d collector.GPUDevice
-		if err := rows.Scan(&d.NodeID, &d.ID, &d.UUID, &d.Name, &d.MemTotal, &d.DriverVer); err != nil {
+		d, err := scanGPUDevice(rows)
+		if err != nil {
 			return nil, err
 		}
 		devices = append(devices, d)
@@ -66,6 +117,27 @@ func (db *DB) GetGPUDevices(nodeID string) ([]collector.GPUDevice, error) {
 	return devices, rows.Err()
 }
 
+// GetGPUDeviceByUUID looks up a GPU or MIG instance by UUID.
+// It returns nil if no device has that UUID.
+func (db *DB) GetGPUDeviceByUUID(uuid string) (*collector.GPUDevice, error) {
+	d, err := scanGPUDevice(db.conn.QueryRow("SELECT "+deviceCols+" FROM gpu_devices WHERE uuid = ? LIMIT 1", uuid))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func scanGPUDevice(row interface{ Scan(...any) error }) (collector.GPUDevice, error) {
+	var d collector.GPUDevice
+	err := row.Scan(&d.NodeID, &d.ID, &d.UUID, &d.Name, &d.MemTotal, &d.DriverVer,
+		&d.ClockGfxMax, &d.ClockMemMax, &d.TempSlowdown, &d.TempShutdown,
+		&d.MIGEnabled, &d.ParentUUID, &d.ParentID, &d.MIGProfile, &d.GIID, &d.CIID)
+	return d, err
+}
+
 // GetGPUMetrics returns GPU metrics for a time range, auto-selecting resolution.
 func (db *DB) GetGPUMetrics(q GPUMetricsQuery) ([]collector.GPUMetrics, error) {
 	span := q.To - q.From
@@ -95,13 +167,13 @@ func selectResolution(spanSec int64) (table, cols string) {
 	switch {
 	case spanSec <= 3600: // <=1h: raw data
 		return "gpu_metrics_raw",
-			"ts, COALESCE(node_id, 'local'), gpu_id, gpu_util, mem_util, mem_used, temperature, fan_speed, power_draw, power_limit, clock_gfx, clock_mem, pcie_tx, pcie_rx, pstate, encoder_util, decoder_util"
+			"ts, COALESCE(node_id, 'local'), gpu_id, gpu_util, mem_util, mem_used, temperature, fan_speed, power_draw, power_limit, clock_gfx, clock_mem, pcie_tx, pcie_rx, pstate, encoder_util, decoder_util, throttle_reasons, clock_gfx_app, clock_mem_app, " + memHealthColList
 	case spanSec <= 2592000: // <=30d: 1m rollup (use max for util/temp to preserve spikes)
 		return "gpu_metrics_1m",
-			"ts, COALESCE(node_id, 'local'), gpu_id, gpu_util_max, mem_util_avg, CAST(mem_used_max AS INTEGER), temperature_max, CAST(fan_speed_avg AS INTEGER), power_draw_avg, 0, CAST(clock_gfx_avg AS INTEGER), CAST(clock_mem_avg AS INTEGER), CAST(pcie_tx_avg AS INTEGER), CAST(pcie_rx_avg AS INTEGER), 0, 0, 0"
+			"ts, COALESCE(node_id, 'local'), gpu_id, gpu_util_max, mem_util_avg, CAST(mem_used_max AS INTEGER), temperature_max, CAST(fan_speed_avg AS INTEGER), power_draw_avg, 0, CAST(clock_gfx_avg AS INTEGER), CAST(clock_mem_avg AS INTEGER), CAST(pcie_tx_avg AS INTEGER), CAST(pcie_rx_avg AS INTEGER), 0, 0, 0, 0, 0, 0, " + memHealthColList
 	default: // >30d: 1h rollup (use max for util/temp to preserve spikes)
 		return "gpu_metrics_1h",
-			"ts, COALESCE(node_id, 'local'), gpu_id, gpu_util_max, mem_util_avg, CAST(mem_used_max AS INTEGER), temperature_max, 0, power_draw_avg, 0, 0, 0, 0, 0, 0, 0, 0"
+			"ts, COALESCE(node_id, 'local'), gpu_id, gpu_util_max, mem_util_avg, CAST(mem_used_max AS INTEGER), temperature_max, 0, power_draw_avg, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, " + memHealthColList
 	}
 }
 
@@ -110,13 +182,14 @@ func scanGPUMetrics(rows *sql.Rows) ([]collector.GPUMetrics, error) {
 	for rows.Next() {
 		var m collector.GPUMetrics
 
-		err := rows.Scan(
+		dest := []any{
 			&m.Timestamp, &m.NodeID, &m.GPUID, &m.GPUUtil, &m.MemUtil, &m.MemUsed,
 			&m.Temperature, &m.FanSpeed, &m.PowerDraw, &m.PowerLimit,
 			&m.ClockGfx, &m.ClockMem, &m.PCIeTx, &m.PCIeRx,
 			&m.PState, &m.EncoderUtil, &m.DecoderUtil,
-		)
-		if err != nil {
+			&m.ThrottleReasons, &m.ClockGfxApp, &m.ClockMemApp,
+		}
+		if err := rows.Scan(append(dest, memHealthFields(&m)...)...); err != nil {
 			return nil, fmt.Errorf("scan: %w", err)
 		}
 		metrics = append(metrics, m)
@@ -172,6 +245,28 @@ func selectHostResolution(spanSec int64) (table, cols string) {
 	}
 }
 
+const procCols = "ts, COALESCE(node_id, 'local'), gpu_id, pid, name, gpu_mem, " +
+	"COALESCE(sm_util, 0), COALESCE(mem_util, 0), COALESCE(enc_util, 0), COALESCE(dec_util, 0), " +
+	"COALESCE(cmdline, ''), COALESCE(uid, -1), COALESCE(username, ''), COALESCE(cgroup, ''), " +
+	"COALESCE(container_id, ''), COALESCE(container_name, ''), COALESCE(pod_namespace, ''), COALESCE(pod_name, ''), " +
+	"COALESCE(job_id, ''), COALESCE(job_user, ''), COALESCE(job_name, '')"
+
+func scanGPUProcesses(rows *sql.Rows) ([]collector.GPUProcess, error) {
+	var procs []collector.GPUProcess
+	for rows.Next() {
+		var p collector.GPUProcess
+		err := rows.Scan(&p.Timestamp, &p.NodeID, &p.GPUID, &p.PID, &p.Name, &p.GPUMem,
+			&p.SMUtil, &p.MemUtil, &p.EncUtil, &p.DecUtil,
+			&p.Cmdline, &p.UID, &p.User, &p.Cgroup, &p.ContainerID, &p.ContainerName, &p.Namespace, &p.Pod,
+			&p.JobID, &p.JobUser, &p.JobName)
+		if err != nil {
+			return nil, err
+		}
+		procs = append(procs, p)
+	}
+	return procs, rows.Err()
+}
+
 // GetGPUProcesses returns current GPU processes (latest snapshot), optionally filtered by node.
 func (db *DB) GetGPUProcesses(gpuID int, nodeID string) ([]collector.GPUProcess, error) {
 	cutoff := time.Now().Unix() - 30
@@ -179,12 +274,12 @@ func (db *DB) GetGPUProcesses(gpuID int, nodeID string) ([]collector.GPUProcess,
 	var query string
 	var args []any
 	if nodeID != "" {
-		query = `SELECT ts, COALESCE(node_id, 'local'), gpu_id, pid, name, gpu_mem FROM gpu_processes
+		query = `SELECT ` + procCols + ` FROM gpu_processes
 			WHERE gpu_id = ? AND COALESCE(node_id, 'local') = ? AND ts >= ?
 			AND ts = (SELECT MAX(ts) FROM gpu_processes WHERE gpu_id = ? AND COALESCE(node_id, 'local') = ?)`
 		args = []any{gpuID, nodeID, cutoff, gpuID, nodeID}
 	} else {
-		query = `SELECT ts, COALESCE(node_id, 'local'), gpu_id, pid, name, gpu_mem FROM gpu_processes
+		query = `SELECT ` + procCols + ` FROM gpu_processes
 			WHERE gpu_id = ? AND ts >= ?
 			AND ts = (SELECT MAX(ts) FROM gpu_processes WHERE gpu_id = ?)`
 		args = []any{gpuID, cutoff, gpuID}
@@ -196,15 +291,7 @@ func (db *DB) GetGPUProcesses(gpuID int, nodeID string) ([]collector.GPUProcess,
 	}
 	defer rows.Close()
 
-	var procs []collector.GPUProcess
-	for rows.Next() {
-		var p collector.GPUProcess
-		if err := rows.Scan(&p.Timestamp, &p.NodeID, &p.GPUID, &p.PID, &p.Name, &p.GPUMem); err != nil {
-			return nil, err
-		}
-		procs = append(procs, p)
-	}
-	return procs, rows.Err()
+	return scanGPUProcesses(rows)
 }
 
 // GetLatestGPUMetrics returns the most recent metric for each GPU across all nodes.
@@ -215,32 +302,70 @@ func (db *DB) GetLatestGPUMetrics() ([]collector.GPUMetrics, error) {
 			SELECT ts, COALESCE(node_id, 'local') as node_id, gpu_id, gpu_util, mem_util, mem_used,
 				temperature, fan_speed, power_draw, power_limit, clock_gfx, clock_mem,
 				pcie_tx, pcie_rx, pstate, encoder_util, decoder_util,
+				throttle_reasons, clock_gfx_app, clock_mem_app, `+memHealthColList+`,
 				ROW_NUMBER() OVER (PARTITION BY COALESCE(node_id, 'local'), gpu_id ORDER BY ts DESC) as rn
 			FROM gpu_metrics_raw
 			WHERE ts >= ?
 		)
 		SELECT ts, node_id, gpu_id, gpu_util, mem_util, mem_used,
 			temperature, fan_speed, power_draw, power_limit, clock_gfx, clock_mem,
-			pcie_tx, pcie_rx, pstate, encoder_util, decoder_util
+			pcie_tx, pcie_rx, pstate, encoder_util, decoder_util,
+			throttle_reasons, clock_gfx_app, clock_mem_app, `+memHealthColList+`
 		FROM latest WHERE rn = 1 ORDER BY node_id, gpu_id`, cutoff)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var metrics []collector.GPUMetrics
+	return scanGPUMetrics(rows)
+}
+
+// GPUKey identifies a GPU across nodes.
+type GPUKey struct {
+	NodeID string
+	GPUID  int
+}
+
+// GetGPUActivity returns when each GPU last reported metrics since a time.
+func (db *DB) GetGPUActivity(since int64) (map[GPUKey]int64, error) {
+	rows, err := db.conn.Query(`SELECT COALESCE(node_id, 'local'), gpu_id, MAX(ts) FROM gpu_metrics_raw
+		WHERE ts >= ? GROUP BY 1, 2`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := make(map[GPUKey]int64)
 	for rows.Next() {
-		var m collector.GPUMetrics
-		err := rows.Scan(&m.Timestamp, &m.NodeID, &m.GPUID, &m.GPUUtil, &m.MemUtil, &m.MemUsed,
-			&m.Temperature, &m.FanSpeed, &m.PowerDraw, &m.PowerLimit,
-			&m.ClockGfx, &m.ClockMem, &m.PCIeTx, &m.PCIeRx,
-			&m.PState, &m.EncoderUtil, &m.DecoderUtil)
-		if err != nil {
+		var k GPUKey
+		var ts int64
+		if err := rows.Scan(&k.NodeID, &k.GPUID, &ts); err != nil {
 			return nil, err
 		}
-		metrics = append(metrics, m)
+		last[k] = ts
 	}
-	return metrics, rows.Err()
+	return last, rows.Err()
+}
+
+// GetHostActivity returns when each node last reported host metrics since
+// a time.
+func (db *DB) GetHostActivity(since int64) (map[string]int64, error) {
+	rows, err := db.conn.Query("SELECT node_id, MAX(ts) FROM host_metrics_raw WHERE ts >= ? GROUP BY node_id", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := make(map[string]int64)
+	for rows.Next() {
+		var node string
+		var ts int64
+		if err := rows.Scan(&node, &ts); err != nil {
+			return nil, err
+		}
+		last[node] = ts
+	}
+	return last, rows.Err()
 }
 
 // GetLatestHostMetrics returns the most recent host metrics (one per node).
@@ -283,24 +408,173 @@ func (db *DB) GetAllGPUProcesses() ([]collector.GPUProcess, error) {
 	rows, err := db.conn.Query(`
 		WITH latest AS (
 			SELECT ts, COALESCE(node_id, 'local') as node_id, gpu_id, pid, name, gpu_mem,
+				sm_util, mem_util, enc_util, dec_util, cmdline, uid, username, cgroup,
+				container_id, container_name, pod_namespace, pod_name, job_id, job_user, job_name,
 				ROW_NUMBER() OVER (PARTITION BY COALESCE(node_id, 'local'), gpu_id, pid ORDER BY ts DESC) as rn
 			FROM gpu_processes
 			WHERE ts >= ?
 		)
-		SELECT ts, node_id, gpu_id, pid, name, gpu_mem
+		SELECT `+procCols+`
 		FROM latest WHERE rn = 1 ORDER BY node_id, gpu_id, pid`, cutoff)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var procs []collector.GPUProcess
+	return scanGPUProcesses(rows)
+}
+
+// GetGPUEvents returns logged GPU events in a time range, newest first.
+func (db *DB) GetGPUEvents(q GPUEventsQuery) ([]collector.GPUEvent, error) {
+	query := "SELECT ts, node_id, gpu_id, event_type, COALESCE(xid, 0), COALESCE(description, '') FROM gpu_events WHERE ts >= ? AND ts <= ?"
+	args := []any{q.From, q.To}
+	if q.NodeID != "" {
+		query += " AND node_id = ?"
+		args = append(args, q.NodeID)
+	}
+	if q.GPUID >= 0 {
+		query += " AND gpu_id = ?"
+		args = append(args, q.GPUID)
+	}
+	if q.Type != "" {
+		query += " AND event_type = ?"
+		args = append(args, q.Type)
+	}
+	query += " ORDER BY ts DESC"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []collector.GPUEvent
 	for rows.Next() {
-		var p collector.GPUProcess
-		if err := rows.Scan(&p.Timestamp, &p.NodeID, &p.GPUID, &p.PID, &p.Name, &p.GPUMem); err != nil {
+		var e collector.GPUEvent
+		if err := rows.Scan(&e.Timestamp, &e.NodeID, &e.GPUID, &e.Type, &e.XID, &e.Description); err != nil {
 			return nil, err
 		}
-		procs = append(procs, p)
+		events = append(events, e)
 	}
-	return procs, rows.Err()
+	return events, rows.Err()
+}
+
+const nvlinkCols = `ts, node_id, gpu_id, link, active, COALESCE(remote_type, ''), COALESCE(remote_pci, ''),
+	COALESCE(remote_gpu_id, -1), COALESCE(tx_kbps, 0), COALESCE(rx_kbps, 0),
+	COALESCE(crc_errors, 0), COALESCE(replay_errors, 0), COALESCE(recovery_errors, 0)`
+
+// GetNVLinkMetrics returns raw NVLink samples of a GPU in a time range,
+// ordered by time and link. NVLink samples are not rolled up.
+func (db *DB) GetNVLinkMetrics(q GPUMetricsQuery) ([]collector.NVLinkMetrics, error) {
+	query := "SELECT " + nvlinkCols + " FROM nvlink_metrics WHERE gpu_id = ? AND ts >= ? AND ts <= ?"
+	args := []any{q.GPUID, q.From, q.To}
+	if q.NodeID != "" {
+		query += " AND node_id = ?"
+		args = append(args, q.NodeID)
+	}
+	query += " ORDER BY ts, link"
+	return db.queryNVLink(query, args...)
+}
+
+// GetLatestNVLinks returns the most recent sample of every link of a node,
+// ordered by GPU and link.
+func (db *DB) GetLatestNVLinks(nodeID string) ([]collector.NVLinkMetrics, error) {
+	return db.queryNVLink(`SELECT `+nvlinkCols+` FROM nvlink_metrics
+		WHERE node_id = ? AND ts = (SELECT MAX(ts) FROM nvlink_metrics WHERE node_id = ?)
+		ORDER BY gpu_id, link`, nodeID, nodeID)
+}
+
+func (db *DB) queryNVLink(query string, args ...any) ([]collector.NVLinkMetrics, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []collector.NVLinkMetrics
+	for rows.Next() {
+		var l collector.NVLinkMetrics
+		err := rows.Scan(&l.Timestamp, &l.NodeID, &l.GPUID, &l.Link, &l.Active, &l.RemoteType, &l.RemotePCI,
+			&l.RemoteGPUID, &l.TxKBps, &l.RxKBps, &l.CRCErrors, &l.ReplayErrors, &l.RecoveryErrors)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// throttleCol is the rollup column holding seconds throttled for a reason.
+func throttleCol(r collector.ThrottleReason) string {
+	return "throttle_" + r.Name + "_s"
+}
+
+// throttleSecondsExpr returns, per reason, the SQL expression for seconds
+// throttled within a bucket of bucketSec seconds computed from raw samples:
+// the fraction of samples with the bit set, scaled to the bucket length.
+// This holds regardless of the collection interval.
+func throttleSecondsExpr(bucketSec int) []string {
+	exprs := make([]string, len(collector.ThrottleReasons))
+	for i, r := range collector.ThrottleReasons {
+		exprs[i] = fmt.Sprintf("SUM((COALESCE(throttle_reasons, 0) & %d) != 0) * %d.0 / COUNT(*)", r.Bit, bucketSec)
+	}
+	return exprs
+}
+
+// GetThrottleSeconds returns seconds throttled per reason for a GPU, bucketed
+// by minute (<=30d) or hour (>30d). Spans of up to an hour are computed from
+// raw samples so the current minute is included.
+func (db *DB) GetThrottleSeconds(q GPUMetricsQuery) ([]collector.ThrottleBucket, error) {
+	span := q.To - q.From
+	cols := make([]string, len(collector.ThrottleReasons))
+	var from string
+	switch {
+	case span <= 3600:
+		copy(cols, throttleSecondsExpr(60))
+		from = "(SELECT (ts / 60) * 60 AS ts, COALESCE(node_id, 'local') AS node_id, gpu_id, throttle_reasons FROM gpu_metrics_raw)"
+	case span <= 2592000:
+		for i, r := range collector.ThrottleReasons {
+			cols[i] = "SUM(" + throttleCol(r) + ")"
+		}
+		from = "gpu_metrics_1m"
+	default:
+		for i, r := range collector.ThrottleReasons {
+			cols[i] = "SUM(" + throttleCol(r) + ")"
+		}
+		from = "gpu_metrics_1h"
+	}
+
+	query := fmt.Sprintf("SELECT ts, COALESCE(node_id, 'local'), gpu_id, %s FROM %s WHERE gpu_id = ? AND ts >= ? AND ts <= ?",
+		strings.Join(cols, ", "), from)
+	args := []any{q.GPUID, q.From, q.To}
+	if q.NodeID != "" {
+		query += " AND COALESCE(node_id, 'local') = ?"
+		args = append(args, q.NodeID)
+	}
+	query += " GROUP BY ts, COALESCE(node_id, 'local'), gpu_id ORDER BY ts"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []collector.ThrottleBucket
+	for rows.Next() {
+		var b collector.ThrottleBucket
+		secs := make([]float64, len(collector.ThrottleReasons))
+		dest := []any{&b.Timestamp, &b.NodeID, &b.GPUID}
+		for i := range secs {
+			dest = append(dest, &secs[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		b.Seconds = make(map[string]float64, len(secs))
+		for i, r := range collector.ThrottleReasons {
+			b.Seconds[r.Name] = secs[i]
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
 }
@@ -3,7 +3,10 @@ package storage
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/sergey/cudascope/internal/collector"
 )
 
 // RetentionConfig holds retention durations.
@@ -13,6 +16,27 @@ type RetentionConfig struct {
 	H1  time.Duration
 }
 
+// memHealthMax aggregates the memory health columns for a rollup bucket.
+var memHealthMax = func() string {
+	aggs := make([]string, len(memHealthCols))
+	for i, c := range memHealthCols {
+		aggs[i] = "MAX(" + c + ")"
+	}
+	return strings.Join(aggs, ", ")
+}()
+
+// throttleColList and throttleSum name and aggregate the per-reason throttle
+// seconds columns; the 1h tier is the sum of its 1m buckets.
+var throttleColList, throttleSum = func() (string, string) {
+	cols := make([]string, len(collector.ThrottleReasons))
+	sums := make([]string, len(collector.ThrottleReasons))
+	for i, r := range collector.ThrottleReasons {
+		cols[i] = throttleCol(r)
+		sums[i] = "SUM(" + cols[i] + ")"
+	}
+	return strings.Join(cols, ", "), strings.Join(sums, ", ")
+}()
+
 // RunRetention starts the background retention/rollup loop.
 func (db *DB) RunRetention(ctx context.Context, cfg RetentionConfig) {
 	ticker := time.NewTicker(60 * time.Second)
@@ -53,6 +77,13 @@ func (db *DB) doRetention(cfg RetentionConfig) {
 	db.prune("host_metrics_1m", m1Cutoff)
 	db.prune("host_metrics_1h", h1Cutoff)
 	db.prune("gpu_processes", rawCutoff)
+	db.prune("nvlink_metrics", rawCutoff)
+	db.prune("gpu_events", h1Cutoff)
+	db.pruneBy("jobs", "last_seen", h1Cutoff)
+	db.pruneBy("job_gpus", "last_seen", h1Cutoff)
+	db.pruneBy("alerts", "resolved_at", h1Cutoff)
+	db.pruneBy("silences", "ends_at", h1Cutoff)
+	db.prune("anomaly_baselines", h1Cutoff)
 }
 
 func (db *DB) rollupGPUTo1m(beforeTs int64) {
@@ -65,12 +96,14 @@ func (db *DB) rollupGPUTo1m(beforeTs int64) {
 	_, err := db.conn.Exec(`
 		INSERT OR REPLACE INTO gpu_metrics_1m (ts, node_id, gpu_id, gpu_util_avg, gpu_util_max, mem_util_avg,
 			mem_used_avg, mem_used_max, temperature_avg, temperature_max, fan_speed_avg,
-			power_draw_avg, power_draw_max, clock_gfx_avg, clock_mem_avg, pcie_tx_avg, pcie_rx_avg)
+			power_draw_avg, power_draw_max, clock_gfx_avg, clock_mem_avg, pcie_tx_avg, pcie_rx_avg,
+			`+memHealthColList+`, `+throttleColList+`)
 		SELECT
 			(ts / 60) * 60 as minute_ts, COALESCE(node_id, 'local'), gpu_id,
 			AVG(gpu_util), MAX(gpu_util), AVG(mem_util),
 			AVG(mem_used), MAX(mem_used), AVG(temperature), MAX(temperature), AVG(fan_speed),
-			AVG(power_draw), MAX(power_draw), AVG(clock_gfx), AVG(clock_mem), AVG(pcie_tx), AVG(pcie_rx)
+			AVG(power_draw), MAX(power_draw), AVG(clock_gfx), AVG(clock_mem), AVG(pcie_tx), AVG(pcie_rx),
+			`+memHealthMax+`, `+strings.Join(throttleSecondsExpr(60), ", ")+`
 		FROM gpu_metrics_raw
 		WHERE ts > ? AND ts <= ?
 		GROUP BY minute_ts, COALESCE(node_id, 'local'), gpu_id
@@ -89,12 +122,14 @@ func (db *DB) rollupGPUTo1h(beforeTs int64) {
 
 	_, err := db.conn.Exec(`
 		INSERT OR REPLACE INTO gpu_metrics_1h (ts, node_id, gpu_id, gpu_util_avg, gpu_util_max, mem_util_avg,
-			mem_used_avg, mem_used_max, temperature_avg, temperature_max, power_draw_avg, power_draw_max)
+			mem_used_avg, mem_used_max, temperature_avg, temperature_max, power_draw_avg, power_draw_max,
+			`+memHealthColList+`, `+throttleColList+`)
 		SELECT
 			(ts / 3600) * 3600 as hour_ts, COALESCE(node_id, 'local'), gpu_id,
 			AVG(gpu_util_avg), MAX(gpu_util_max), AVG(mem_util_avg),
 			AVG(mem_used_avg), MAX(mem_used_max), AVG(temperature_avg), MAX(temperature_max),
-			AVG(power_draw_avg), MAX(power_draw_max)
+			AVG(power_draw_avg), MAX(power_draw_max),
+			`+memHealthMax+`, `+throttleSum+`
 		FROM gpu_metrics_1m
 		WHERE ts > ? AND ts <= ?
 		GROUP BY hour_ts, COALESCE(node_id, 'local'), gpu_id
@@ -154,10 +189,15 @@ func (db *DB) rollupHostTo1h(beforeTs int64) {
 }
 
 func (db *DB) prune(table string, beforeTs int64) {
+	db.pruneBy(table, "ts", beforeTs)
+}
+
+// pruneBy deletes rows whose column is older than beforeTs.
+func (db *DB) pruneBy(table, column string, beforeTs int64) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	result, err := db.conn.Exec("DELETE FROM "+table+" WHERE ts < ?", beforeTs)
+	result, err := db.conn.Exec("DELETE FROM "+table+" WHERE "+column+" < ?", beforeTs)
 	if err != nil {
 		log.Printf("prune %s error: %v", table, err)
 		return
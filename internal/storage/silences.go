@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"database/sql"
+	"path"
+)
+
+// Silence suppresses notifications for matching alerts during a time
+// window. Empty matchers match anything.
+type Silence struct {
+	ID        int64  `json:"id"`
+	NodeID    string `json:"node_id,omitempty"` // node ID glob
+	GPUID     *int   `json:"gpu_id,omitempty"`
+	Metric    string `json:"metric,omitempty"`
+	Rule      string `json:"rule,omitempty"`
+	StartsAt  int64  `json:"starts_at"`
+	EndsAt    int64  `json:"ends_at"`
+	Comment   string `json:"comment,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Matches reports whether the silence's matchers match an alert.
+func (s *Silence) Matches(a *Alert) bool {
+	if s.NodeID != "" {
+		if ok, _ := path.Match(s.NodeID, a.NodeID); !ok {
+			return false
+		}
+	}
+	return (s.GPUID == nil || *s.GPUID == a.GPUID) &&
+		(s.Metric == "" || s.Metric == a.Metric) &&
+		(s.Rule == "" || s.Rule == a.Rule)
+}
+
+const silenceCols = "id, node_id, gpu_id, metric, rule, starts_at, ends_at, comment, created_by, created_at"
+
+// CreateSilence stores a silence and sets its ID.
+func (db *DB) CreateSilence(s *Silence) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	res, err := db.conn.Exec(`INSERT INTO silences (node_id, gpu_id, metric, rule, starts_at, ends_at, comment, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.NodeID, s.GPUID, s.Metric, s.Rule, s.StartsAt, s.EndsAt, s.Comment, s.CreatedBy, s.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	s.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetSilences returns the silences that have not ended by now (all
+// silences if expired is set), soonest start first.
+func (db *DB) GetSilences(now int64, expired bool) ([]Silence, error) {
+	query := "SELECT " + silenceCols + " FROM silences"
+	var args []any
+	if !expired {
+		query += " WHERE ends_at > ?"
+		args = append(args, now)
+	}
+	rows, err := db.conn.Query(query+" ORDER BY starts_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSilences(rows)
+}
+
+// GetActiveSilences returns the silences in effect at now.
+func (db *DB) GetActiveSilences(now int64) ([]Silence, error) {
+	rows, err := db.conn.Query("SELECT "+silenceCols+" FROM silences WHERE starts_at <= ? AND ends_at > ?", now, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanSilences(rows)
+}
+
+// DeleteSilence removes a silence, reporting whether it existed.
+func (db *DB) DeleteSilence(id int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	res, err := db.conn.Exec("DELETE FROM silences WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func scanSilences(rows *sql.Rows) ([]Silence, error) {
+	defer rows.Close()
+	var silences []Silence
+	for rows.Next() {
+		var s Silence
+		var gpu sql.NullInt64
+		err := rows.Scan(&s.ID, &s.NodeID, &gpu, &s.Metric, &s.Rule, &s.StartsAt, &s.EndsAt, &s.Comment, &s.CreatedBy, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if gpu.Valid {
+			id := int(gpu.Int64)
+			s.GPUID = &id
+		}
+		silences = append(silences, s)
+	}
+	return silences, rows.Err()
+}
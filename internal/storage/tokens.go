@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Token roles.
+const (
+	RoleViewer = "viewer" // read-only API, WebSocket and /metrics
+	RoleAdmin  = "admin"  // everything, including token management
+	RoleAgent  = "agent"  // agent ingest only
+)
+
+// ValidRole reports whether role is a known token role.
+func ValidRole(role string) bool {
+	return role == RoleViewer || role == RoleAdmin || role == RoleAgent
+}
+
+// tokenPrefix starts every token, so leaked tokens are easy to recognise.
+const tokenPrefix = "cst_"
+
+// Token is an API token. The secret itself is only returned by CreateToken.
+type Token struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Prefix    string `json:"prefix"`            // first characters of the secret, to tell tokens apart
+	Role      string `json:"role"`              // RoleViewer, RoleAdmin or RoleAgent
+	NodeID    string `json:"node_id,omitempty"` // agent tokens: the only node it may ingest as
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // 0 = never
+	LastUsed  int64  `json:"last_used,omitempty"`
+}
+
+const tokenCols = "id, name, prefix, role, COALESCE(node_id, ''), created_at, COALESCE(expires_at, 0), COALESCE(last_used, 0)"
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken creates a token and returns its secret, which is not stored.
+func (db *DB) CreateToken(name, role, nodeID string, expiresAt int64) (string, *Token, error) {
+	if !ValidRole(role) {
+		return "", nil, fmt.Errorf("unknown role %q", role)
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	secret := tokenPrefix + hex.EncodeToString(buf)
+
+	t := &Token{Name: name, Prefix: secret[:len(tokenPrefix)+6], Role: role, NodeID: nodeID, CreatedAt: time.Now().Unix(), ExpiresAt: expiresAt}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	res, err := db.conn.Exec(`INSERT INTO tokens (name, hash, prefix, role, node_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, 0))`,
+		t.Name, hashToken(secret), t.Prefix, t.Role, t.NodeID, t.CreatedAt, t.ExpiresAt,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	return secret, t, nil
+}
+
+// GetTokens returns all tokens, oldest first.
+func (db *DB) GetTokens() ([]Token, error) {
+	rows, err := db.conn.Query("SELECT " + tokenCols + " FROM tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// HasTokens reports whether any token exists.
+func (db *DB) HasTokens() (bool, error) {
+	var n int
+	err := db.conn.QueryRow("SELECT EXISTS (SELECT 1 FROM tokens)").Scan(&n)
+	return n == 1, err
+}
+
+// LookupToken returns the token with the given secret, or nil if there is
+// none or it has expired. Its last_used time is refreshed at most once a minute.
+func (db *DB) LookupToken(secret string) (*Token, error) {
+	t, err := scanToken(db.conn.QueryRow("SELECT "+tokenCols+" FROM tokens WHERE hash = ?", hashToken(secret)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	if t.ExpiresAt != 0 && t.ExpiresAt <= now {
+		return nil, nil
+	}
+	if now-t.LastUsed >= 60 {
+		db.mu.Lock()
+		_, err = db.conn.Exec("UPDATE tokens SET last_used = ? WHERE id = ?", now, t.ID)
+		db.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = now
+	}
+	return &t, nil
+}
+
+// RevokeToken deletes a token, reporting whether it existed.
+func (db *DB) RevokeToken(id int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	res, err := db.conn.Exec("DELETE FROM tokens WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func scanToken(row interface{ Scan(...any) error }) (Token, error) {
+	var t Token
+	err := row.Scan(&t.ID, &t.Name, &t.Prefix, &t.Role, &t.NodeID, &t.CreatedAt, &t.ExpiresAt, &t.LastUsed)
+	return t, err
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sergey/cudascope/internal/collector"
+)
+
+// WasteConfig defines an idle allocation: a GPU whose memory use stays at
+// or above MinMem while its utilization stays below MaxUtil.
+type WasteConfig struct {
+	MaxUtil float64       // GPU utilization, %
+	MinMem  uint64        // MiB
+	MinIdle time.Duration // how long before an idle GPU counts as wasted
+}
+
+// IdleGPU is a GPU that has held memory while idle since IdleSince.
+type IdleGPU struct {
+	NodeID      string        `json:"node_id"`
+	GPUID       int           `json:"gpu_id"`
+	IdleSince   int64         `json:"idle_since"`
+	IdleSeconds int64         `json:"idle_seconds"`
+	MemUsed     uint64        `json:"mem_used"` // MiB, at the last sample
+	GPUUtil     float64       `json:"gpu_util"` // average while idle
+	Processes   []IdleProcess `json:"processes"`
+}
+
+// IdleProcess is a process holding memory on an idle GPU.
+type IdleProcess struct {
+	PID           uint32 `json:"pid"`
+	Name          string `json:"name"`
+	User          string `json:"user,omitempty"` // process owner, or the Slurm job user
+	GPUMem        uint64 `json:"gpu_mem"`        // MiB
+	ContainerName string `json:"container_name,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Pod           string `json:"pod,omitempty"`
+	JobID         string `json:"job_id,omitempty"`
+	IdleSeconds   int64  `json:"idle_seconds"` // since the GPU or the process became idle, whichever is later
+}
+
+// physicalGPUs restricts a query on metrics table t to physical GPUs. MIG
+// instances (see collector.MIGDeviceID) report only their memory use, so
+// their rows are left out. Unless migMode is set, so are GPUs in MIG mode,
+// for which NVML reports no utilization.
+func physicalGPUs(t string, migMode bool) string {
+	devices := "COALESCE(d.parent_uuid, '') != ''"
+	if !migMode {
+		devices += " OR d.mig_enabled"
+	}
+	return fmt.Sprintf(`%[1]s.gpu_id < %[2]d AND NOT EXISTS (SELECT 1 FROM gpu_devices d
+		WHERE d.node_id = COALESCE(%[1]s.node_id, 'local') AND d.gpu_id = %[1]s.gpu_id AND (%[3]s))`,
+		t, collector.MIGDeviceID(0, 0, 0), devices)
+}
+
+// wasteLookback bounds how far back idle periods are traced; raw metrics
+// are usually pruned much earlier.
+const wasteLookback = 7 * 24 * time.Hour
+
+// GetIdleGPUs returns the physical GPUs that have been idle while holding
+// memory for at least cfg.MinIdle, longest idle first. Only GPUs that
+// reported in the last minute are considered; GPUs in MIG mode are not.
+func (db *DB) GetIdleGPUs(cfg WasteConfig, nodeID string) ([]IdleGPU, error) {
+	now := time.Now().Unix()
+	// busy is the last sample that was not an idle allocation; the idle
+	// period starts after it (or with the oldest sample if there is none)
+	query := `SELECT COALESCE(node_id, 'local') AS node, gpu_id, MIN(ts), MAX(ts),
+			COALESCE(MAX(CASE WHEN gpu_util >= ? OR mem_used < ? THEN ts END), 0)
+		FROM gpu_metrics_raw
+		WHERE ts >= ? AND ` + physicalGPUs("gpu_metrics_raw", false)
+	args := []any{cfg.MaxUtil, cfg.MinMem, now - int64(wasteLookback.Seconds())}
+	if nodeID != "" {
+		query += " AND COALESCE(node_id, 'local') = ?"
+		args = append(args, nodeID)
+	}
+	query += " GROUP BY node, gpu_id"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var idle []IdleGPU
+	for rows.Next() {
+		var g IdleGPU
+		var first, last, busy int64
+		if err := rows.Scan(&g.NodeID, &g.GPUID, &first, &last, &busy); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if last < now-60 || busy == last {
+			continue
+		}
+		g.IdleSince = first
+		if busy > 0 {
+			g.IdleSince = busy
+		}
+		g.IdleSeconds = last - g.IdleSince
+		if g.IdleSeconds >= int64(cfg.MinIdle.Seconds()) {
+			idle = append(idle, g)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range idle {
+		if err := db.loadIdleGPU(&idle[i]); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return idle[i].IdleSeconds > idle[j].IdleSeconds })
+	return idle, nil
+}
+
+// loadIdleGPU fills in the memory, utilization and processes of an idle GPU.
+func (db *DB) loadIdleGPU(g *IdleGPU) error {
+	err := db.conn.QueryRow(`SELECT COALESCE(AVG(gpu_util), 0),
+			COALESCE((SELECT mem_used FROM gpu_metrics_raw WHERE COALESCE(node_id, 'local') = ? AND gpu_id = ? ORDER BY ts DESC LIMIT 1), 0)
+		FROM gpu_metrics_raw WHERE ts > ? AND gpu_id = ? AND COALESCE(node_id, 'local') = ?`,
+		g.NodeID, g.GPUID, g.IdleSince, g.GPUID, g.NodeID,
+	).Scan(&g.GPUUtil, &g.MemUsed)
+	if err != nil {
+		return err
+	}
+
+	procs, err := db.GetGPUProcesses(g.GPUID, g.NodeID)
+	if err != nil {
+		return err
+	}
+	// When each process first showed up on the GPU
+	started := make(map[uint32]int64)
+	rows, err := db.conn.Query(`SELECT pid, MIN(ts) FROM gpu_processes
+		WHERE ts >= ? AND gpu_id = ? AND COALESCE(node_id, 'local') = ? GROUP BY pid`,
+		g.IdleSince, g.GPUID, g.NodeID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var pid uint32
+		var ts int64
+		if err := rows.Scan(&pid, &ts); err != nil {
+			rows.Close()
+			return err
+		}
+		started[pid] = ts
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	last := g.IdleSince + g.IdleSeconds
+	g.Processes = []IdleProcess{}
+	for _, p := range procs {
+		user := p.User
+		if user == "" {
+			user = p.JobUser
+		}
+		since := g.IdleSince
+		if ts, ok := started[p.PID]; ok && ts > since {
+			since = ts
+		}
+		g.Processes = append(g.Processes, IdleProcess{
+			PID:           p.PID,
+			Name:          p.Name,
+			User:          user,
+			GPUMem:        p.GPUMem,
+			ContainerName: p.ContainerName,
+			Namespace:     p.Namespace,
+			Pod:           p.Pod,
+			JobID:         p.JobID,
+			IdleSeconds:   max(last-since, 0),
+		})
+	}
+	return nil
+}
+
+// GetIdleGPUHours returns the GPU-hours in [from, to) that physical GPUs
+// (not in MIG mode) spent holding memory, and how many of those they were
+// idle, from the 1-minute rollups.
+func (db *DB) GetIdleGPUHours(cfg WasteConfig, from, to int64) (idle, allocated float64, err error) {
+	var idleMin, allocMin int64
+	err = db.conn.QueryRow(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN gpu_util_avg < ? THEN 1 ELSE 0 END), 0)
+		FROM gpu_metrics_1m WHERE ts >= ? AND ts < ? AND mem_used_avg >= ? AND `+physicalGPUs("gpu_metrics_1m", false),
+		cfg.MaxUtil, from, to, cfg.MinMem,
+	).Scan(&allocMin, &idleMin)
+	return float64(idleMin) / 60, float64(allocMin) / 60, err
+}
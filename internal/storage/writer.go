@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/sergey/cudascope/internal/collector"
@@ -9,6 +12,41 @@ import (
 
 // WriteGPUMetrics batch-inserts GPU metrics.
 func (db *DB) WriteGPUMetrics(metrics []collector.GPUMetrics) error {
+	return db.inTx(func(tx *sql.Tx) error { return writeGPUMetrics(tx, metrics) })
+}
+
+// WriteBatch writes a sequence of snapshots (as sent by an agent) in a
+// single transaction; either all of them are stored or none.
+func (db *DB) WriteBatch(snaps []collector.Snapshot) error {
+	return db.inTx(func(tx *sql.Tx) error {
+		for _, snap := range snaps {
+			var err error
+			switch snap.Type {
+			case "gpu_metrics":
+				err = writeGPUMetrics(tx, snap.GPUs)
+			case "gpu_processes":
+				err = writeGPUProcesses(tx, snap.Processes)
+			case "gpu_events":
+				err = writeGPUEvents(tx, snap.Events)
+			case "nvlink":
+				err = writeNVLinkMetrics(tx, snap.NVLinks)
+			case "host_metrics":
+				if snap.Host != nil {
+					err = writeHostMetrics(tx, snap.Host)
+				}
+			default:
+				err = fmt.Errorf("unknown snapshot type %q", snap.Type)
+			}
+			if err != nil {
+				return fmt.Errorf("%s at %d: %w", snap.Type, snap.Timestamp, err)
+			}
+		}
+		return nil
+	})
+}
+
+// inTx runs fn in a write transaction, committing if it succeeds.
+func (db *DB) inTx(fn func(tx *sql.Tx) error) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -18,11 +56,19 @@ func (db *DB) WriteGPUMetrics(metrics []collector.GPUMetrics) error {
 	}
 	defer tx.Rollback()
 
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func writeGPUMetrics(tx *sql.Tx, metrics []collector.GPUMetrics) error {
 	stmt, err := tx.Prepare(`INSERT INTO gpu_metrics_raw
 		(ts, node_id, gpu_id, gpu_util, mem_util, mem_used, temperature, fan_speed,
 		 power_draw, power_limit, clock_gfx, clock_mem, pcie_tx, pcie_rx,
-		 pstate, encoder_util, decoder_util)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		 pstate, encoder_util, decoder_util, throttle_reasons, clock_gfx_app, clock_mem_app,
+		 ` + memHealthColList + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?` + strings.Repeat(", ?", len(memHealthCols)) + `)`)
 	if err != nil {
 		return fmt.Errorf("prepare: %w", err)
 	}
@@ -33,26 +79,28 @@ func (db *DB) WriteGPUMetrics(metrics []collector.GPUMetrics) error {
 		if nodeID == "" {
 			nodeID = "local"
 		}
-		_, err := stmt.Exec(
+		args := []any{
 			m.Timestamp, nodeID, m.GPUID, m.GPUUtil, m.MemUtil, m.MemUsed,
 			m.Temperature, m.FanSpeed, m.PowerDraw, m.PowerLimit,
 			m.ClockGfx, m.ClockMem, m.PCIeTx, m.PCIeRx,
 			m.PState, m.EncoderUtil, m.DecoderUtil,
-		)
+			m.ThrottleReasons, m.ClockGfxApp, m.ClockMemApp,
+		}
+		_, err := stmt.Exec(append(args, memHealthFields(&m)...)...)
 		if err != nil {
 			return fmt.Errorf("exec: %w", err)
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 // WriteHostMetrics inserts a host metrics snapshot.
 func (db *DB) WriteHostMetrics(m *collector.HostMetrics) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.inTx(func(tx *sql.Tx) error { return writeHostMetrics(tx, m) })
+}
 
-	_, err := db.conn.Exec(`INSERT INTO host_metrics_raw
+func writeHostMetrics(tx *sql.Tx, m *collector.HostMetrics) error {
+	_, err := tx.Exec(`INSERT INTO host_metrics_raw
 		(ts, node_id, cpu_percent, mem_used, mem_total, disk_used, disk_total,
 		 net_rx, net_tx, load_1m, load_5m, load_15m)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
@@ -68,68 +116,180 @@ func (db *DB) WriteGPUProcesses(procs []collector.GPUProcess) error {
 	if len(procs) == 0 {
 		return nil
 	}
+	return db.inTx(func(tx *sql.Tx) error { return writeGPUProcesses(tx, procs) })
+}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+func writeGPUProcesses(tx *sql.Tx, procs []collector.GPUProcess) error {
+	if len(procs) == 0 {
+		return nil
+	}
 
-	tx, err := db.conn.Begin()
+	stmt, err := tx.Prepare(`INSERT INTO gpu_processes (ts, node_id, gpu_id, pid, name, gpu_mem, sm_util, mem_util, enc_util, dec_util,
+		cmdline, uid, username, cgroup, container_id, container_name, pod_namespace, pod_name, job_id, job_user, job_name)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	defer stmt.Close()
+
+	for _, p := range procs {
+		nodeID := p.NodeID
+		if nodeID == "" {
+			nodeID = "local"
+		}
+		_, err := stmt.Exec(p.Timestamp, nodeID, p.GPUID, p.PID, p.Name, p.GPUMem, p.SMUtil, p.MemUtil, p.EncUtil, p.DecUtil,
+			p.Cmdline, p.UID, p.User, p.Cgroup, p.ContainerID, p.ContainerName, p.Namespace, p.Pod,
+			p.JobID, p.JobUser, p.JobName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := updateJobs(tx, procs); err != nil {
+		return fmt.Errorf("update jobs: %w", err)
+	}
+	return nil
+}
 
-	stmt, err := tx.Prepare(`INSERT INTO gpu_processes (ts, node_id, gpu_id, pid, name, gpu_mem) VALUES (?, ?, ?, ?, ?, ?)`)
+// WriteGPUEvents appends GPU events to the event log.
+func (db *DB) WriteGPUEvents(events []collector.GPUEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return db.inTx(func(tx *sql.Tx) error { return writeGPUEvents(tx, events) })
+}
+
+func writeGPUEvents(tx *sql.Tx, events []collector.GPUEvent) error {
+	stmt, err := tx.Prepare(`INSERT INTO gpu_events (ts, node_id, gpu_id, event_type, xid, description) VALUES (?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	for _, p := range procs {
-		nodeID := p.NodeID
+	for _, e := range events {
+		nodeID := e.NodeID
 		if nodeID == "" {
 			nodeID = "local"
 		}
-		if _, err := stmt.Exec(p.Timestamp, nodeID, p.GPUID, p.PID, p.Name, p.GPUMem); err != nil {
+		if _, err := stmt.Exec(e.Timestamp, nodeID, e.GPUID, e.Type, e.XID, e.Description); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	return tx.Commit()
+// WriteNVLinkMetrics inserts a batch of NVLink samples.
+func (db *DB) WriteNVLinkMetrics(links []collector.NVLinkMetrics) error {
+	if len(links) == 0 {
+		return nil
+	}
+	return db.inTx(func(tx *sql.Tx) error { return writeNVLinkMetrics(tx, links) })
+}
+
+func writeNVLinkMetrics(tx *sql.Tx, links []collector.NVLinkMetrics) error {
+	stmt, err := tx.Prepare(`INSERT INTO nvlink_metrics (ts, node_id, gpu_id, link, active, remote_type, remote_pci, remote_gpu_id,
+		tx_kbps, rx_kbps, crc_errors, replay_errors, recovery_errors)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, l := range links {
+		nodeID := l.NodeID
+		if nodeID == "" {
+			nodeID = "local"
+		}
+		_, err := stmt.Exec(l.Timestamp, nodeID, l.GPUID, l.Link, l.Active, l.RemoteType, l.RemotePCI, l.RemoteGPUID,
+			l.TxKBps, l.RxKBps, l.CRCErrors, l.ReplayErrors, l.RecoveryErrors)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// RegisterGPUDevices upserts GPU device info for a given node.
+// RegisterGPUDevices upserts GPU device info for a given node. MIG instances
+// of the node that are no longer reported (repartitioned GPUs) are removed.
 func (db *DB) RegisterGPUDevices(nodeID string, devices []collector.GPUDevice) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	now := time.Now().Unix()
+	ids := make([]any, 0, len(devices)+1)
+	ids = append(ids, nodeID)
 	for _, d := range devices {
-		_, err := db.conn.Exec(`INSERT INTO gpu_devices (node_id, gpu_id, uuid, name, mem_total, driver_ver, first_seen)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-			ON CONFLICT(node_id, gpu_id) DO UPDATE SET name=excluded.name, mem_total=excluded.mem_total, driver_ver=excluded.driver_ver, uuid=excluded.uuid`,
+		var parentUUID, parentID, profile any // NULL for physical GPUs
+		if d.IsMIG() {
+			parentUUID, parentID, profile = d.ParentUUID, d.ParentID, d.MIGProfile
+		}
+		_, err := db.conn.Exec(`INSERT INTO gpu_devices (node_id, gpu_id, uuid, name, mem_total, driver_ver, first_seen,
+				clock_gfx_max, clock_mem_max, temp_slowdown, temp_shutdown,
+				mig_enabled, parent_uuid, parent_id, mig_profile, gi_id, ci_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(node_id, gpu_id) DO UPDATE SET name=excluded.name, mem_total=excluded.mem_total, driver_ver=excluded.driver_ver, uuid=excluded.uuid,
+				clock_gfx_max=excluded.clock_gfx_max, clock_mem_max=excluded.clock_mem_max,
+				temp_slowdown=excluded.temp_slowdown, temp_shutdown=excluded.temp_shutdown,
+				mig_enabled=excluded.mig_enabled, parent_uuid=excluded.parent_uuid, parent_id=excluded.parent_id,
+				mig_profile=excluded.mig_profile, gi_id=excluded.gi_id, ci_id=excluded.ci_id`,
 			nodeID, d.ID, d.UUID, d.Name, d.MemTotal, d.DriverVer, now,
+			d.ClockGfxMax, d.ClockMemMax, d.TempSlowdown, d.TempShutdown,
+			d.MIGEnabled, parentUUID, parentID, profile, d.GIID, d.CIID,
 		)
 		if err != nil {
 			return fmt.Errorf("register device %d: %w", d.ID, err)
 		}
+		ids = append(ids, d.ID)
+	}
+
+	query := "DELETE FROM gpu_devices WHERE node_id = ? AND parent_uuid IS NOT NULL"
+	if len(ids) > 1 {
+		query += " AND gpu_id NOT IN (?" + strings.Repeat(", ?", len(ids)-2) + ")"
+	}
+	if _, err := db.conn.Exec(query, ids...); err != nil {
+		return fmt.Errorf("remove stale MIG devices: %w", err)
 	}
 	return nil
 }
 
-// RegisterNode registers or updates a node in the nodes table.
-func (db *DB) RegisterNode(nodeID, hostname string, gpuCount int) error {
+// RegisterNode registers or updates a node in the nodes table, along with
+// the collector.DeviceHash of its GPUs and its labels.
+func (db *DB) RegisterNode(nodeID, hostname string, gpuCount int, deviceHash string, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	now := time.Now().Unix()
-	_, err := db.conn.Exec(`INSERT INTO nodes (node_id, hostname, gpu_count, first_seen, last_seen)
-		VALUES (?, ?, ?, ?, ?)
-		ON CONFLICT(node_id) DO UPDATE SET hostname=excluded.hostname, gpu_count=excluded.gpu_count, last_seen=excluded.last_seen`,
-		nodeID, hostname, gpuCount, now, now,
+	_, err = db.conn.Exec(`INSERT INTO nodes (node_id, hostname, gpu_count, first_seen, last_seen, device_hash, labels)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(node_id) DO UPDATE SET hostname=excluded.hostname, gpu_count=excluded.gpu_count, last_seen=excluded.last_seen,
+			device_hash=excluded.device_hash, labels=excluded.labels`,
+		nodeID, hostname, gpuCount, now, now, deviceHash, string(labelsJSON),
 	)
 	return err
 }
 
+// SetNodeMaintenance turns maintenance mode of a node on or off, reporting
+// whether the node exists.
+func (db *DB) SetNodeMaintenance(nodeID string, on bool) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	res, err := db.conn.Exec(`UPDATE nodes SET maintenance = ? WHERE node_id = ?`, on, nodeID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
 // UpdateNodeSeen updates the last_seen timestamp for a node.
 func (db *DB) UpdateNodeSeen(nodeID string) error {
 	db.mu.Lock()
@@ -0,0 +1,138 @@
+// Package yaml decodes YAML config files with gopkg.in/yaml.v3 into Go
+// values through encoding/json, so the json struct tags (and UnmarshalJSON
+// methods, e.g. of durations) of the target type apply. Documents that
+// start with { or [ are decoded as JSON.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Unmarshal decodes a YAML document into v. Fields that v does not have are
+// errors, so that misspelled keys are not silently ignored.
+func Unmarshal(data []byte, v any) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return decodeJSON(trimmed, v)
+	}
+	tree, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	js, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(js, v)
+}
+
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Errorf("%s: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	}
+	if err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
+
+// Parse parses a YAML document into maps (map[string]any), slices ([]any)
+// and scalars (string, bool, int, float64 or nil). Timestamps are kept as
+// written, as strings.
+func Parse(data []byte) (any, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return value(doc.Content[0])
+}
+
+// value converts a node into a JSON-encodable value.
+func value(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return value(n.Alias)
+
+	case yaml.SequenceNode:
+		items := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := value(c)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		var merged []*yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, c := n.Content[i], n.Content[i+1]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be scalars", k.Line)
+			}
+			if k.Tag == "!!merge" {
+				merged = append(merged, c)
+				continue
+			}
+			if _, ok := m[k.Value]; ok {
+				return nil, fmt.Errorf("line %d: key %q already defined", k.Line, k.Value)
+			}
+			v, err := value(c)
+			if err != nil {
+				return nil, err
+			}
+			m[k.Value] = v
+		}
+		// Keys of the mapping itself win over merged ones
+		for _, mn := range merged {
+			if mn.Kind == yaml.AliasNode {
+				mn = mn.Alias
+			}
+			sources := []*yaml.Node{mn}
+			if mn.Kind == yaml.SequenceNode {
+				sources = mn.Content
+			}
+			for _, src := range sources {
+				v, err := value(src)
+				if err != nil {
+					return nil, err
+				}
+				sm, ok := v.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("line %d: only mappings can be merged", src.Line)
+				}
+				for k, v := range sm {
+					if _, ok := m[k]; !ok {
+						m[k] = v
+					}
+				}
+			}
+		}
+		return m, nil
+
+	case yaml.ScalarNode:
+		if n.Tag == "!!timestamp" {
+			return n.Value, nil
+		}
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported YAML node", n.Line)
+}
@@ -0,0 +1,251 @@
+package yaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"empty", "", nil},
+		{"comment only", "# nothing here\n", nil},
+		{"scalars", `
+s: text
+n: 42
+f: 2.5
+neg: -3
+b: true
+off: false
+null1: ~
+null2:
+yes: yes
+ver: 1.10
+`, map[string]any{"s": "text", "n": 42, "f": 2.5, "neg": -3, "b": true, "off": false,
+			"null1": nil, "null2": nil, "yes": "yes", "ver": 1.1}},
+		{"quoting", `
+single: 'it''s # not a comment'
+double: "tab\tand \"quotes\" # kept"
+number: "85"
+bool: 'true'
+op: ">"
+empty: ""
+colon: "a: b"
+`, map[string]any{"single": "it's # not a comment", "double": "tab\tand \"quotes\" # kept", "number": "85",
+			"bool": "true", "op": ">", "empty": "", "colon": "a: b"}},
+		{"comments", `
+# leading comment
+a: 1 # trailing comment
+b: x#y   # '#' inside a word is not a comment
+  # indented comment
+c: [1, 2] # after a flow sequence
+`, map[string]any{"a": 1, "b": "x#y", "c": []any{1, 2}}},
+		{"nesting", `
+a:
+  b:
+    c: deep
+    d: [x, y]
+  e: {f: 1, g: {h: 2}}
+`, map[string]any{"a": map[string]any{
+			"b": map[string]any{"c": "deep", "d": []any{"x", "y"}},
+			"e": map[string]any{"f": 1, "g": map[string]any{"h": 2}},
+		}}},
+		{"lists of maps", `
+rules:
+  - name: hot
+    for: 5m
+    notify: [oncall, slack]
+  - name: idle
+    node: "train-*"
+  -
+    name: indented
+  - {name: flow, gpu: 0}
+`, map[string]any{"rules": []any{
+			map[string]any{"name": "hot", "for": "5m", "notify": []any{"oncall", "slack"}},
+			map[string]any{"name": "idle", "node": "train-*"},
+			map[string]any{"name": "indented"},
+			map[string]any{"name": "flow", "gpu": 0},
+		}}},
+		{"sequence at the key's indent", `
+a:
+- 1
+- 2
+b: 3
+`, map[string]any{"a": []any{1, 2}, "b": 3}},
+		{"nested sequences", `
+- - 1
+  - 2
+- [3, [4]]
+`, []any{[]any{1, 2}, []any{3, []any{4}}}},
+		{"block scalars", `
+literal: |
+  {"a": 1,
+   "b": 2}
+folded: >
+  one
+  two
+keep: |+
+  x
+
+strip: |-
+  y
+`, map[string]any{"literal": "{\"a\": 1,\n \"b\": 2}\n", "folded": "one two\n", "keep": "x\n\n", "strip": "y"}},
+		{"multi-line flow collections", `
+a: [1,
+    2]
+b: {
+  c: 3
+}
+`, map[string]any{"a": []any{1, 2}, "b": map[string]any{"c": 3}}},
+		{"timestamps are kept as written", `
+starts_at: 2026-12-24T00:00:00Z
+day: 2026-12-24
+`, map[string]any{"starts_at": "2026-12-24T00:00:00Z", "day": "2026-12-24"}},
+		{"anchors and merge keys", `
+base: &base {op: ">", severity: warning}
+rules:
+  - <<: *base
+    name: a
+    severity: critical
+  - <<: [*base]
+    name: b
+  - *base
+`, map[string]any{
+			"base": map[string]any{"op": ">", "severity": "warning"},
+			"rules": []any{
+				map[string]any{"op": ">", "severity": "critical", "name": "a"},
+				map[string]any{"op": ">", "severity": "warning", "name": "b"},
+				map[string]any{"op": ">", "severity": "warning"},
+			},
+		}},
+		{"non-string keys", "1: one\ntrue: yes\n", map[string]any{"1": "one", "true": "yes"}},
+		{"crlf line endings", "a: 1\r\nb:\r\n  - x\r\n", map[string]any{"a": 1, "b": []any{"x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse:\n got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "line 2"},
+		{"bad indentation", "a:\n  b: 1\n c: 2\n", "did not find expected key"},
+		{"unclosed quote", "a: \"text\n", "unexpected end of stream"},
+		{"unclosed flow sequence", "a: [1, 2\n", "line"},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: key "a" already defined`},
+		{"duplicate nested key", "a:\n  - b: 1\n    b: 2\n", `line 3: key "b" already defined`},
+		{"sequence item in a mapping", "a: 1\n- b\n", "did not find expected key"},
+		{"mapping value after a scalar", "a: b: c\n", "mapping values are not allowed"},
+		{"unknown alias", "a: *nope\n", "unknown anchor"},
+		{"complex key", "? [1, 2]\n: x\n", "mapping keys must be scalars"},
+		{"merging a scalar", "a: &x 1\nb:\n  <<: *x\n", "only mappings can be merged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse = %#v, %v; want error containing %q", got, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// duration decodes from a JSON string, like alert.Duration.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+type testRule struct {
+	Name      string   `json:"name"`
+	Threshold float64  `json:"threshold"`
+	For       duration `json:"for,omitempty"`
+	GPU       *int     `json:"gpu,omitempty"`
+	Notify    []string `json:"notify,omitempty"`
+}
+
+type testFile struct {
+	Rules []testRule `json:"rules"`
+	Since time.Time  `json:"since"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	gpu := 3
+	want := testFile{
+		Rules: []testRule{
+			{Name: "gpu_hot", Threshold: 85, For: duration(5 * time.Minute), Notify: []string{"oncall"}},
+			{Name: "gpu_idle", Threshold: 5.5, GPU: &gpu},
+		},
+		Since: time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC),
+	}
+	for _, in := range []string{
+		`
+rules:
+  - name: gpu_hot
+    threshold: 85
+    for: 5m
+    notify: [oncall]
+  - name: gpu_idle
+    threshold: 5.5
+    gpu: 3
+since: 2026-12-24T00:00:00Z
+`,
+		// JSON is decoded as is
+		`{"rules": [{"name": "gpu_hot", "threshold": 85, "for": "5m", "notify": ["oncall"]},
+		  {"name": "gpu_idle", "threshold": 5.5, "gpu": 3}], "since": "2026-12-24T00:00:00Z"}`,
+	} {
+		var got testFile
+		if err := Unmarshal([]byte(in), &got); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal:\n got %+v\nwant %+v", got, want)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"unknown field", "rules:\n  - name: a\n    treshold: 85\n", `unknown field "treshold"`},
+		{"wrong type", "rules:\n  - name: a\n    threshold: high\n", "rules.0.threshold: cannot use string as float64"},
+		{"list instead of a map", "rules:\n  name: a\n", "cannot use object"},
+		{"bad duration", "rules:\n  - name: a\n    for: soon\n", "invalid duration"},
+		{"bad timestamp", "since: christmas\n", "cannot parse"},
+		{"malformed YAML", "rules:\n  - name: a\n   threshold: 85\n", "did not find expected"},
+		{"malformed JSON", `{"rules": [}`, "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f testFile
+			err := Unmarshal([]byte(tt.in), &f)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Unmarshal = %+v, %v; want error containing %q", f, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -61,14 +61,26 @@ export interface Node {
 	first_seen: number;
 	last_seen: number;
 	online: boolean;
+	maintenance: boolean;
+	labels?: Record<string, string>;
 }
 
 export interface Alert {
+	id: number;
+	rule: string;
+	severity: 'info' | 'warning' | 'critical';
+	state: 'pending' | 'firing' | 'resolved';
 	node_id: string;
-	gpu_id: number;
+	gpu_id: number; // -1 for host alerts
 	metric: string;
+	op: string;
 	value: number;
 	threshold: number;
+	scope?: string; // threshold override, e.g. "model=*RTX 4090*"
+	started_at: number;
+	fired_at?: number;
+	resolved_at?: number;
+	silenced: boolean;
 }
 
 // Helper: create a composite key for multi-node GPU identification